@@ -0,0 +1,182 @@
+package prolog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ichiban/prolog/engine"
+)
+
+var atomDot = engine.NewAtom(".")
+
+// Var is a named variable in a goal built in Go. Every occurrence of the same name in a goal is the same variable and,
+// unless it's "_", its value is available to Solutions.Scan by the name.
+type Var string
+
+// WriteTerm outputs the Var to an io.Writer.
+func (v Var) WriteTerm(w io.Writer, _ *engine.WriteOptions, _ *engine.Env) error {
+	_, err := io.WriteString(w, string(v))
+	return err
+}
+
+// Compare compares the Var with a Term.
+func (v Var) Compare(t engine.Term, env *engine.Env) int {
+	return engine.CompareAtomic[Var](v, t, func(v, w Var) int {
+		return strings.Compare(string(v), string(w))
+	}, env)
+}
+
+// invalidTerm is a term which failed to build. QueryTerm reports the error.
+type invalidTerm struct {
+	err error
+}
+
+func (t invalidTerm) WriteTerm(w io.Writer, _ *engine.WriteOptions, _ *engine.Env) error {
+	_, err := fmt.Fprintf(w, "<invalid>(%v)", t.err)
+	return err
+}
+
+func (t invalidTerm) Compare(u engine.Term, env *engine.Env) int {
+	return engine.CompareAtomic[invalidTerm](t, u, func(invalidTerm, invalidTerm) int {
+		return 0
+	}, env)
+}
+
+// Compound builds a compound term of which the functor is name and the arguments are args.
+// The arguments are either terms, including the ones built by Var, Compound, List, and PartialList, or Go values which
+// are converted in the same way as the arguments for placeholders.
+func Compound(name string, args ...interface{}) engine.Term {
+	ts, err := termsOf(args)
+	if err != nil {
+		return invalidTerm{err: err}
+	}
+	return engine.NewAtom(name).Apply(ts...)
+}
+
+// List builds a list of elems. The elements are converted in the same way as the arguments of Compound.
+func List(elems ...interface{}) engine.Term {
+	ts, err := termsOf(elems)
+	if err != nil {
+		return invalidTerm{err: err}
+	}
+	return engine.List(ts...)
+}
+
+// PartialList builds a list of elems followed by tail, e.g. [a, b|T]. The elements and the tail are converted in the
+// same way as the arguments of Compound.
+func PartialList(tail interface{}, elems ...interface{}) engine.Term {
+	ts, err := termsOf(append([]interface{}{tail}, elems...))
+	if err != nil {
+		return invalidTerm{err: err}
+	}
+	return engine.PartialList(ts[0], ts[1:]...)
+}
+
+func termsOf(vs []interface{}) ([]engine.Term, error) {
+	ts := make([]engine.Term, len(vs))
+	for i, v := range vs {
+		t, err := engine.TermOf(v)
+		if err != nil {
+			return nil, err
+		}
+		ts[i] = t
+	}
+	return ts, nil
+}
+
+// QueryTerm executes a goal built in Go and returns *Solutions with context.
+func (i *Interpreter) QueryTerm(ctx context.Context, goal engine.Term) (*Solutions, error) {
+	var b goalBuilder
+	t, _, err := b.resolve(goal)
+	if err != nil {
+		return nil, err
+	}
+
+	call := func(vm *engine.VM, k engine.Cont, env *engine.Env) *engine.Promise {
+		return engine.Call(vm, t, k, env)
+	}
+	return newSolutions(ctx, &i.VM, b.vars, call, engine.NewEnv().WithContext(ctx)), nil
+}
+
+// goalBuilder replaces the named variables in a goal with variables.
+type goalBuilder struct {
+	vars []engine.ParsedVariable
+}
+
+// resolve returns t of which the named variables are replaced. ok is false if t doesn't contain any of them.
+func (b *goalBuilder) resolve(t engine.Term) (engine.Term, bool, error) {
+	switch t := t.(type) {
+	case Var:
+		return b.variable(t), true, nil
+	case invalidTerm:
+		return nil, false, t.err
+	case engine.Compound:
+		if t.Functor() == atomDot && t.Arity() == 2 {
+			return b.resolveList(t)
+		}
+		args := make([]engine.Term, t.Arity())
+		var changed bool
+		for i := range args {
+			a, ok, err := b.resolve(t.Arg(i))
+			if err != nil {
+				return nil, false, err
+			}
+			args[i] = a
+			changed = changed || ok
+		}
+		if !changed {
+			return t, false, nil
+		}
+		return t.Functor().Apply(args...), true, nil
+	default:
+		return t, false, nil
+	}
+}
+
+// resolveList resolves the elements of the list l one by one so that a long list doesn't exhaust the stack.
+func (b *goalBuilder) resolveList(l engine.Compound) (engine.Term, bool, error) {
+	var (
+		elems   []engine.Term
+		tail    engine.Term = l
+		changed bool
+	)
+	for {
+		c, ok := tail.(engine.Compound)
+		if !ok || c.Functor() != atomDot || c.Arity() != 2 {
+			break
+		}
+		e, ok, err := b.resolve(c.Arg(0))
+		if err != nil {
+			return nil, false, err
+		}
+		elems = append(elems, e)
+		changed = changed || ok
+		tail = c.Arg(1)
+	}
+	tail, ok, err := b.resolve(tail)
+	if err != nil {
+		return nil, false, err
+	}
+	if !changed && !ok {
+		return l, false, nil
+	}
+	return engine.PartialList(tail, elems...), true, nil
+}
+
+func (b *goalBuilder) variable(v Var) engine.Variable {
+	if v == "_" {
+		return engine.NewVariable()
+	}
+	n := engine.NewAtom(string(v))
+	for i, pv := range b.vars {
+		if pv.Name == n {
+			b.vars[i].Count++
+			return pv.Variable
+		}
+	}
+	w := engine.NewVariable()
+	b.vars = append(b.vars, engine.ParsedVariable{Name: n, Variable: w, Count: 1})
+	return w
+}
@@ -0,0 +1,69 @@
+package prolog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ichiban/prolog/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpreter_QueryTerm(t *testing.T) {
+	i := New(nil, nil)
+	assert.NoError(t, i.Exec(`
+parent(alice, bob).
+parent(bob, 'Carol O''Brien').
+`))
+
+	type result struct {
+		X, Y string
+		L    []string
+		T    []string
+	}
+
+	tests := []struct {
+		title  string
+		goal   engine.Term
+		err    bool
+		result []result
+	}{
+		{title: "compound", goal: Compound("parent", "alice", Var("X")), result: []result{{X: "bob"}}},
+		{title: "quoting", goal: Compound("parent", Var("X"), "Carol O'Brien"), result: []result{{X: "bob"}}},
+		{title: "conjunction", goal: Compound(",", Compound("parent", Var("X"), Var("_")), Compound("parent", Var("_"), Var("Y"))), result: []result{
+			{X: "alice", Y: "bob"},
+			{X: "alice", Y: "Carol O'Brien"},
+			{X: "bob", Y: "bob"},
+			{X: "bob", Y: "Carol O'Brien"},
+		}},
+		{title: "shared variable", goal: Compound(",", Compound("parent", Var("X"), Var("Y")), Compound("parent", Var("Y"), Var("_"))), result: []result{{X: "alice", Y: "bob"}}},
+		{title: "list of atoms", goal: Compound(",", Compound("=", Var("X"), "x"), Compound("=", Var("L"), List("a", Var("X")))), result: []result{{X: "x", L: []string{"a", "x"}}}},
+		{title: "partial list", goal: Compound(",", Compound("=", PartialList(Var("T"), "a", "b"), List("a", "b", "c")), Compound("=", Var("L"), PartialList(Var("T"), "z"))), result: []result{{L: []string{"z", "c"}, T: []string{"c"}}}},
+		{title: "invalid argument", goal: Compound("parent", make(chan int)), err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			sols, err := i.QueryTerm(context.Background(), tt.goal)
+			assert.Equal(t, tt.err, err != nil)
+			if err != nil {
+				return
+			}
+			defer sols.Close()
+
+			var rs []result
+			for sols.Next() {
+				var r result
+				assert.NoError(t, sols.Scan(&r))
+				rs = append(rs, r)
+			}
+			assert.NoError(t, sols.Err())
+			assert.Equal(t, tt.result, rs)
+		})
+	}
+}
+
+func TestVar_Compare(t *testing.T) {
+	assert.Equal(t, 0, Var("X").Compare(Var("X"), nil))
+	assert.Equal(t, -1, Var("X").Compare(Var("Y"), nil))
+	assert.Equal(t, 1, Var("X").Compare(engine.NewAtom("x"), nil))
+}
@@ -76,6 +76,9 @@ Type Ctrl-C or 'halt.' to exit.
 		_, _ = engine.WriteTerm(&i.VM, s, name.Apply(args...), engine.List(engine.NewAtom("quoted").Apply(engine.NewAtom("true"))), engine.Success, env).Force(context.Background())
 		log.Printf("UNKNOWN %s", &sb)
 	}
+	i.Warn = func(w engine.Warning) {
+		log.Printf("WARNING %s", w)
+	}
 
 	// Consult arguments.
 	if err := i.QuerySolution(`consult(?).`, flag.Args()).Err(); err != nil {
@@ -148,12 +151,16 @@ func handleLine(ctx context.Context, buf *strings.Builder, p *prolog.Interpreter
 			return err
 		}
 
-		r, _, err := keys.ReadRune()
-		if err != nil {
-			return err
-		}
-		if r != ';' {
-			r = '.'
+		// There's no need to ask for other solutions if it's the last one.
+		r := '.'
+		if !sols.Deterministic() {
+			r, _, err = keys.ReadRune()
+			if err != nil {
+				return err
+			}
+			if r != ';' {
+				r = '.'
+			}
 		}
 		if _, err := fmt.Fprintf(t, "%s\n", string(r)); err != nil {
 			return err
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ichiban/prolog"
+	"github.com/ichiban/prolog/engine"
+)
+
+// chunkSize is the number of bytes of the image in a line of the generated string literal.
+const chunkSize = 48
+
+// generate consults the Prolog texts in files and writes Go source code of the package pkg which defines the function
+// fn to load the compiled procedures into a VM.
+// The image includes the files loaded by them, e.g. by ensure_loaded/1, and their initialization goals.
+func generate(w io.Writer, pkg, fn string, files []string, warn func(engine.Warning)) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no files")
+	}
+
+	p := prolog.New(nil, nil)
+	p.Warn = warn
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = filepath.ToSlash(filepath.Clean(f))
+	}
+	if err := p.QuerySolution(`consult(?).`, paths).Err(); err != nil {
+		return err
+	}
+
+	// The VM is fresh so that the loaded files are the ones in files and their dependencies.
+	var image bytes.Buffer
+	if err := p.SaveImage(&image, p.LoadedFiles()...); err != nil {
+		return err
+	}
+
+	name := strings.ToLower(fn[:1]) + fn[1:] + "Image"
+
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "// Code generated by prolog2go from %s; DO NOT EDIT.\n\n", strings.Join(paths, ", "))
+	_, _ = fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	_, _ = fmt.Fprintf(&buf, "import (\n\t\"strings\"\n\n\t\"github.com/ichiban/prolog/engine\"\n)\n\n")
+	_, _ = fmt.Fprintf(&buf, "// %s adds the procedures defined in %s and the files they load to vm.\n", fn, strings.Join(paths, ", "))
+	_, _ = fmt.Fprintf(&buf, "// Then, it runs their initialization goals.\n")
+	_, _ = fmt.Fprintf(&buf, "func %s(vm *engine.VM) error {\n\treturn vm.LoadImage(strings.NewReader(%s))\n}\n\n", fn, name)
+	_, _ = fmt.Fprintf(&buf, "const %s = \"\"", name)
+	b := image.Bytes()
+	for len(b) > 0 {
+		n := chunkSize
+		if n > len(b) {
+			n = len(b)
+		}
+		_, _ = fmt.Fprintf(&buf, " +\n\t%s", strconv.Quote(string(b[:n])))
+		b = b[n:]
+	}
+	_, _ = fmt.Fprintf(&buf, "\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ichiban/prolog"
+	"github.com/ichiban/prolog/engine"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "rules.pl")
+	assert.NoError(t, os.WriteFile(file, []byte(`
+:- op(700, xfx, likes).
+alice likes 'café au lait'.
+friend(X, Y) :- X likes Y.
+unused(X).
+`), 0644))
+
+	var buf bytes.Buffer
+	var warnings []engine.Warning
+	assert.NoError(t, generate(&buf, "rules", "LoadRules", []string{file}, func(w engine.Warning) {
+		warnings = append(warnings, w)
+	}))
+	assert.Len(t, warnings, 1)
+	assert.Equal(t, engine.WarningSingletons, warnings[0].Kind)
+
+	f, err := parser.ParseFile(token.NewFileSet(), "rules.go", buf.Bytes(), parser.ParseComments)
+	assert.NoError(t, err)
+	assert.Equal(t, "rules", f.Name.Name)
+	assert.True(t, strings.HasPrefix(f.Comments[0].Text(), "Code generated by prolog2go"))
+	assert.NotNil(t, f.Scope.Lookup("LoadRules"))
+
+	var image strings.Builder
+	ast.Inspect(f.Scope.Lookup("loadRulesImage").Decl.(ast.Node), func(n ast.Node) bool {
+		if l, ok := n.(*ast.BasicLit); ok && l.Kind == token.STRING {
+			s, err := strconv.Unquote(l.Value)
+			assert.NoError(t, err)
+			image.WriteString(s)
+		}
+		return true
+	})
+
+	p := prolog.New(nil, nil)
+	assert.NoError(t, p.LoadImage(strings.NewReader(image.String())))
+	var s struct {
+		Y string
+	}
+	assert.NoError(t, p.QuerySolution(`friend(alice, Y).`).Scan(&s))
+	assert.Equal(t, "café au lait", s.Y)
+}
+
+func TestGenerate_dependencies(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "helper.pl"), []byte(`
+helper(X) :- member(X, [a, b]).
+`), 0644))
+	file := filepath.Join(dir, "rules.pl")
+	assert.NoError(t, os.WriteFile(file, []byte(`
+:- ensure_loaded(helper).
+:- dynamic(ready/0).
+:- initialization(assertz(ready)).
+main_rule(X) :- helper(X).
+`), 0644))
+
+	var buf bytes.Buffer
+	assert.NoError(t, generate(&buf, "rules", "Load", []string{file}, nil))
+
+	f, err := parser.ParseFile(token.NewFileSet(), "rules.go", buf.Bytes(), parser.ParseComments)
+	assert.NoError(t, err)
+	var image strings.Builder
+	ast.Inspect(f.Scope.Lookup("loadImage").Decl.(ast.Node), func(n ast.Node) bool {
+		if l, ok := n.(*ast.BasicLit); ok && l.Kind == token.STRING {
+			s, err := strconv.Unquote(l.Value)
+			assert.NoError(t, err)
+			image.WriteString(s)
+		}
+		return true
+	})
+
+	p := prolog.New(nil, nil)
+	assert.NoError(t, p.LoadImage(strings.NewReader(image.String())))
+
+	var xs []struct {
+		X string
+	}
+	assert.NoError(t, p.QueryAll(context.Background(), &xs, `main_rule(X).`))
+	assert.Len(t, xs, 2)
+
+	// The initialization goal runs once when the image is loaded.
+	var n struct {
+		N int
+	}
+	assert.NoError(t, p.QuerySolution(`findall(x, ready, L), length(L, N).`).Scan(&n))
+	assert.Equal(t, 1, n.N)
+}
+
+func TestGenerate_error(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "rules.pl")
+	assert.NoError(t, os.WriteFile(file, []byte("foo(.\n"), 0644))
+
+	var buf bytes.Buffer
+	assert.Error(t, generate(&buf, "rules", "Load", []string{file}, nil))
+	assert.Empty(t, buf.String())
+
+	assert.Error(t, generate(&buf, "rules", "Load", nil, nil))
+}
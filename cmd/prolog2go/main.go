@@ -0,0 +1,65 @@
+// Command prolog2go compiles Prolog texts into Go source code so that programs embedded in a Go binary don't need to be
+// parsed at runtime.
+//
+// Usage:
+//
+//	//go:generate go run github.com/ichiban/prolog/cmd/prolog2go -o rules.go rules.pl
+//
+// The generated file defines a function, Load by default, which adds the compiled procedures, including the ones in the
+// files loaded by the Prolog texts, to an engine.VM and runs their initialization goals:
+//
+//	p := prolog.New(nil, nil)
+//	if err := Load(&p.VM); err != nil {
+//		panic(err)
+//	}
+//
+// Syntax errors and failed directives in the Prolog texts are reported at go generate time.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ichiban/prolog/engine"
+)
+
+func main() {
+	var (
+		out = flag.String("o", "", `output file (default: standard output)`)
+		pkg = flag.String("pkg", os.Getenv("GOPACKAGE"), `package name (default: $GOPACKAGE or main)`)
+		fn  = flag.String("func", "Load", `name of the generated function`)
+	)
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("prolog2go: ")
+
+	if *pkg == "" {
+		*pkg = "main"
+	}
+	if *fn == "" {
+		log.Fatal("empty function name")
+	}
+
+	var buf bytes.Buffer
+	if err := generate(&buf, *pkg, *fn, flag.Args(), func(w engine.Warning) {
+		log.Printf("WARNING %s", w)
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	if *out == "" {
+		_, _ = os.Stdout.Write(buf.Bytes())
+		return
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
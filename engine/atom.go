@@ -28,6 +28,7 @@ var (
 	atomEmpty             = NewAtom("")
 	atomSlash             = NewAtom("/")
 	atomSlashSlash        = NewAtom("//")
+	atomColon             = NewAtom(":")
 	atomIf                = NewAtom(":-")
 	atomEmptyList         = NewAtom("[]")
 	atomEmptyBlock        = NewAtom("{}")
@@ -46,6 +47,7 @@ var (
 	atomNegation          = NewAtom(`\+`)
 	atomThen              = NewAtom("->")
 	atomCaret             = NewAtom("^")
+	atomAtSign            = NewAtom("@")
 	atomArrow             = NewAtom("-->")
 	atomBackSlash         = NewAtom(`\`)
 	atomBitwiseRightShift = NewAtom(">>")
@@ -56,7 +58,9 @@ var (
 	atomAbs                     = NewAtom("abs")
 	atomAccess                  = NewAtom("access")
 	atomAcos                    = NewAtom("acos")
+	atomAbsoluteFileNameOption  = NewAtom("absolute_file_name_option")
 	atomAlias                   = NewAtom("alias")
+	atomAll                     = NewAtom("all")
 	atomAppend                  = NewAtom("append")
 	atomAsin                    = NewAtom("asin")
 	atomAt                      = NewAtom("at")
@@ -64,12 +68,15 @@ var (
 	atomAtan2                   = NewAtom("atan2")
 	atomAtom                    = NewAtom("atom")
 	atomAtomic                  = NewAtom("atomic")
+	atomBagof                   = NewAtom("bagof")
 	atomBinary                  = NewAtom("binary")
 	atomBinaryStream            = NewAtom("binary_stream")
 	atomBounded                 = NewAtom("bounded")
+	atomBraceTermPosition       = NewAtom("brace_term_position")
 	atomByte                    = NewAtom("byte")
 	atomCall                    = NewAtom("call")
 	atomCallable                = NewAtom("callable")
+	atomCatch                   = NewAtom("catch")
 	atomCeiling                 = NewAtom("ceiling")
 	atomCharConversion          = NewAtom("char_conversion")
 	atomCharacter               = NewAtom("character")
@@ -78,10 +85,15 @@ var (
 	atomChars                   = NewAtom("chars")
 	atomCloseOption             = NewAtom("close_option")
 	atomCodes                   = NewAtom("codes")
+	atomComments                = NewAtom("comments")
 	atomCompound                = NewAtom("compound")
 	atomCos                     = NewAtom("cos")
 	atomCreate                  = NewAtom("create")
+	atomCycles                  = NewAtom("cycles")
 	atomDebug                   = NewAtom("debug")
+	atomDec10                   = NewAtom("dec10")
+	atomDefined                 = NewAtom("defined")
+	atomDirectory               = NewAtom("directory")
 	atomDiscontiguous           = NewAtom("discontiguous")
 	atomDiv                     = NewAtom("div")
 	atomDomainError             = NewAtom("domain_error")
@@ -90,14 +102,27 @@ var (
 	atomE                       = NewAtom("E")
 	atomEOFAction               = NewAtom("eof_action")
 	atomEOFCode                 = NewAtom("eof_code")
+	atomElif                    = NewAtom("elif")
+	atomElse                    = NewAtom("else")
 	atomEndOfFile               = NewAtom("end_of_file")
 	atomEndOfStream             = NewAtom("end_of_stream")
+	atomEndif                   = NewAtom("endif")
 	atomEnsureLoaded            = NewAtom("ensure_loaded")
 	atomError                   = NewAtom("error")
 	atomEvaluable               = NewAtom("evaluable")
 	atomEvaluationError         = NewAtom("evaluation_error")
+	atomExecute                 = NewAtom("execute")
+	atomExist                   = NewAtom("exist")
 	atomExistenceError          = NewAtom("existence_error")
 	atomExp                     = NewAtom("exp")
+	atomExtensions              = NewAtom("extensions")
+	atomFile                    = NewAtom("file")
+	atomFileErrors              = NewAtom("file_errors")
+	atomFileSearchPath          = NewAtom("file_search_path")
+	atomFileType                = NewAtom("file_type")
+	atomFindall                 = NewAtom("findall")
+	atomFirst                   = NewAtom("first")
+	atomForall                  = NewAtom("forall")
 	atomFX                      = NewAtom("fx")
 	atomFY                      = NewAtom("fy")
 	atomFail                    = NewAtom("fail")
@@ -109,10 +134,15 @@ var (
 	atomFloat                   = NewAtom("float")
 	atomFloatFractionalPart     = NewAtom("float_fractional_part")
 	atomFloatIntegerPart        = NewAtom("float_integer_part")
+	atomForeign                 = NewAtom("foreign")
 	atomFloatOverflow           = NewAtom("float_overflow")
 	atomFloor                   = NewAtom("floor")
 	atomForce                   = NewAtom("force")
+	atomGoalExpansion           = NewAtom("goal_expansion")
+	atomGoalExpansionDepth      = NewAtom("goal_expansion_depth")
+	atomIgnore                  = NewAtom("ignore")
 	atomIOMode                  = NewAtom("io_mode")
+	atomIfDirective             = NewAtom("if")
 	atomIgnoreOps               = NewAtom("ignore_ops")
 	atomInByte                  = NewAtom("in_byte")
 	atomInCharacter             = NewAtom("in_character")
@@ -125,6 +155,7 @@ var (
 	atomInteger                 = NewAtom("integer")
 	atomIntegerRoundingFunction = NewAtom("integer_rounding_function")
 	atomList                    = NewAtom("list")
+	atomListPosition            = NewAtom("list_position")
 	atomLog                     = NewAtom("log")
 	atomMax                     = NewAtom("max")
 	atomMaxArity                = NewAtom("max_arity")
@@ -135,14 +166,19 @@ var (
 	atomMod                     = NewAtom("mod")
 	atomMode                    = NewAtom("mode")
 	atomModify                  = NewAtom("modify")
+	atomModule                  = NewAtom("module")
 	atomMultifile               = NewAtom("multifile")
 	atomNonEmptyList            = NewAtom("non_empty_list")
+	atomNone                    = NewAtom("none")
 	atomNot                     = NewAtom("not")
 	atomNotLessThanZero         = NewAtom("not_less_than_zero")
 	atomNumber                  = NewAtom("number")
+	atomNumberOfClauses         = NewAtom("number_of_clauses")
 	atomNumberVars              = NewAtom("numbervars")
+	atomOccursCheck             = NewAtom("occurs_check")
 	atomOff                     = NewAtom("off")
 	atomOn                      = NewAtom("on")
+	atomOnce                    = NewAtom("once")
 	atomOpen                    = NewAtom("open")
 	atomOperator                = NewAtom("operator")
 	atomOperatorPriority        = NewAtom("operator_priority")
@@ -150,6 +186,7 @@ var (
 	atomOrder                   = NewAtom("order")
 	atomOutput                  = NewAtom("output")
 	atomPair                    = NewAtom("pair")
+	atomParenthesesTermPosition = NewAtom("parentheses_term_position")
 	atomPast                    = NewAtom("past")
 	atomPastEndOfStream         = NewAtom("past_enf_of_stream")
 	atomPermissionError         = NewAtom("permission_error")
@@ -159,42 +196,57 @@ var (
 	atomPredicateIndicator      = NewAtom("predicate_indicator")
 	atomPrivateProcedure        = NewAtom("private_procedure")
 	atomProcedure               = NewAtom("procedure")
+	atomProlog                  = NewAtom("prolog")
 	atomPrologFlag              = NewAtom("prolog_flag")
+	atomQuiet                   = NewAtom("quiet")
 	atomQuoted                  = NewAtom("quoted")
 	atomRead                    = NewAtom("read")
 	atomReadOption              = NewAtom("read_option")
+	atomRelativeTo              = NewAtom("relative_to")
 	atomRem                     = NewAtom("rem")
 	atomReposition              = NewAtom("reposition")
 	atomRepresentationError     = NewAtom("representation_error")
 	atomReset                   = NewAtom("reset")
 	atomResourceError           = NewAtom("resource_error")
 	atomRound                   = NewAtom("round")
+	atomSerializable            = NewAtom("serializable")
+	atomSetof                   = NewAtom("setof")
 	atomSign                    = NewAtom("sign")
 	atomSin                     = NewAtom("sin")
 	atomSingletons              = NewAtom("singletons")
 	atomSmallE                  = NewAtom("e")
+	atomSolutions               = NewAtom("solutions")
+	atomSource                  = NewAtom("source")
 	atomSourceSink              = NewAtom("source_sink")
 	atomSqrt                    = NewAtom("sqrt")
+	atomStatic                  = NewAtom("static")
 	atomStaticProcedure         = NewAtom("static_procedure")
 	atomStream                  = NewAtom("stream")
 	atomStreamOption            = NewAtom("stream_option")
 	atomStreamOrAlias           = NewAtom("stream_or_alias")
 	atomStreamPosition          = NewAtom("stream_position")
 	atomStreamProperty          = NewAtom("stream_property")
+	atomStringPosition          = NewAtom("string_position")
+	atomSubtermPositions        = NewAtom("subterm_positions")
 	atomSyntaxError             = NewAtom("syntax_error")
+	atomSyntaxErrors            = NewAtom("syntax_errors")
 	atomTan                     = NewAtom("tan")
+	atomTermDepth               = NewAtom("term_depth")
 	atomTermExpansion           = NewAtom("term_expansion")
+	atomTermPosition            = NewAtom("term_position")
 	atomText                    = NewAtom("text")
 	atomTextStream              = NewAtom("text_stream")
 	atomTowardZero              = NewAtom("toward_zero")
 	atomTrue                    = NewAtom("true")
 	atomTruncate                = NewAtom("truncate")
+	atomTxt                     = NewAtom("txt")
 	atomType                    = NewAtom("type")
 	atomTypeError               = NewAtom("type_error")
 	atomUnbounded               = NewAtom("unbounded")
 	atomUndefined               = NewAtom("undefined")
 	atomUnderflow               = NewAtom("underflow")
 	atomUnknown                 = NewAtom("unknown")
+	atomUser                    = NewAtom("user")
 	atomUserInput               = NewAtom("user_input")
 	atomUserOutput              = NewAtom("user_output")
 	atomVar                     = NewAtom("$VAR")
@@ -7,7 +7,9 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -58,6 +60,35 @@ func Call(vm *VM, goal Term, k Cont, env *Env) *Promise {
 	}
 }
 
+// PreparedGoal is a goal compiled in advance so that it can be called repeatedly, even concurrently, without
+// recompilation.
+type PreparedGoal struct {
+	args []Term // the free variables of the goal
+	proc userDefined
+}
+
+// PrepareGoal compiles goal for later calls. The free variables of goal can be bound by the environment of each call.
+func PrepareGoal(goal Term) (*PreparedGoal, error) {
+	if v, ok := goal.(Variable); ok {
+		goal = atomCall.Apply(v)
+	}
+	fvs := NewEnv().freeVariables(goal)
+	args := make([]Term, len(fvs))
+	for i, fv := range fvs {
+		args[i] = fv
+	}
+	cs, err := compile(atomIf.Apply(tuple(args...), goal), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &PreparedGoal{args: args, proc: userDefined{clauses: cs}}, nil
+}
+
+// Call executes the prepared goal in the same way as Call.
+func (g *PreparedGoal) Call(vm *VM, k Cont, env *Env) *Promise {
+	return g.proc.call(vm, g.args, k, env)
+}
+
 // Call1 succeeds if closure with an additional argument succeeds.
 func Call1(vm *VM, closure, arg1 Term, k Cont, env *Env) *Promise {
 	return callN(vm, closure, []Term{arg1}, k, env)
@@ -151,9 +182,12 @@ func CallNth(vm *VM, goal, nth Term, k Cont, env *Env) *Promise {
 	return p
 }
 
-// Unify unifies x and y without occurs check (i.e., X = f(X) is allowed).
-func Unify(_ *VM, x, y Term, k Cont, env *Env) *Promise {
-	env, ok := env.Unify(x, y)
+// Unify unifies x and y. Whether X = f(X) is allowed depends on current_prolog_flag(occurs_check, _).
+func Unify(vm *VM, x, y Term, k Cont, env *Env) *Promise {
+	env, ok, err := vm.unify(x, y, env)
+	if err != nil {
+		return Error(err)
+	}
 	if !ok {
 		return Bool(false)
 	}
@@ -225,30 +259,52 @@ func TypeCompound(_ *VM, t Term, k Cont, env *Env) *Promise {
 
 // AcyclicTerm checks if t is acyclic.
 func AcyclicTerm(_ *VM, t Term, k Cont, env *Env) *Promise {
-	if cyclicTerm(t, nil, env) {
+	if cyclicTerm(t, env) {
 		return Bool(false)
 	}
 	return k(env)
 }
 
-func cyclicTerm(t Term, visited []Term, env *Env) bool {
-	t = env.Resolve(t)
+func cyclicTerm(t Term, env *Env) bool {
+	c, ok := env.Resolve(t).(Compound)
+	if !ok {
+		return false
+	}
 
-	for _, v := range visited {
-		if t == v {
-			return true
-		}
+	// Depth-first search with the compounds on the current path. Compounds known to be acyclic are visited only once.
+	type frame struct {
+		c Compound
+		i int
 	}
-	visited = append(visited, t)
+	var (
+		path    = map[termID]struct{}{id(c): {}}
+		acyclic = map[termID]struct{}{}
+		stack   = []frame{{c: c}}
+	)
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		if f.i == f.c.Arity() {
+			delete(path, id(f.c))
+			acyclic[id(f.c)] = struct{}{}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		arg := env.Resolve(f.c.Arg(f.i))
+		f.i++
 
-	if c, ok := t.(Compound); ok {
-		for i := 0; i < c.Arity(); i++ {
-			if cyclicTerm(c.Arg(i), visited, env) {
-				return true
-			}
+		c, ok := arg.(Compound)
+		if !ok {
+			continue
+		}
+		if _, ok := acyclic[id(c)]; ok {
+			continue
+		}
+		if _, ok := path[id(c)]; ok {
+			return true
 		}
+		path[id(c)] = struct{}{}
+		stack = append(stack, frame{c: c})
 	}
-
 	return false
 }
 
@@ -392,68 +448,119 @@ func renamedCopy(t Term, copied map[termID]Term, env *Env) (Term, error) {
 	if copied == nil {
 		copied = map[termID]Term{}
 	}
-	t = env.Resolve(t)
-	if c, ok := copied[id(t)]; ok {
-		return c, nil
-	}
-	switch t := t.(type) {
-	case Variable:
-		v := NewVariable()
-		copied[id(t)] = v
-		return v, nil
-	case charList, codeList:
-		return t, nil
-	case list:
-		s, err := makeSlice(len(t))
-		if err != nil {
-			return nil, resourceError(resourceMemory, env)
+
+	var (
+		ret   Term
+		stack = []rebuildTask{{src: t, dst: &ret}}
+	)
+	for len(stack) > 0 {
+		var task rebuildTask
+		task, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		if task.fix != nil {
+			task.fix()
+			continue
+		}
+
+		t := env.Resolve(task.src)
+		if c, ok := copied[id(t)]; ok {
+			*task.dst = c
+			continue
 		}
-		l := list(s)
-		copied[id(t)] = l
-		for i := range t {
-			c, err := renamedCopy(t[i], copied, env)
+		switch t := t.(type) {
+		case Variable:
+			v := NewVariable()
+			copied[id(t)] = v
+			*task.dst = v
+		case charList, codeList:
+			*task.dst = t
+		case list:
+			s, err := makeSlice(len(t))
 			if err != nil {
-				return nil, err
+				return nil, resourceError(resourceMemory, env)
 			}
-			l[i] = c
-		}
-		return l, nil
-	case *partial:
-		var p partial
-		copied[id(t)] = &p
-		cp, err := renamedCopy(t.Compound, copied, env)
-		if err != nil {
-			return nil, err
-		}
-		p.Compound = cp.(Compound)
-		cp, err = renamedCopy(*t.tail, copied, env)
-		if err != nil {
-			return nil, err
-		}
-		tail := cp
-		p.tail = &tail
-		return &p, nil
-	case Compound:
-		args, err := makeSlice(t.Arity())
-		if err != nil {
-			return nil, resourceError(resourceMemory, env)
-		}
-		c := compound{
-			functor: t.Functor(),
-			args:    args,
-		}
-		copied[id(t)] = &c
-		for i := 0; i < t.Arity(); i++ {
-			cp, err := renamedCopy(t.Arg(i), copied, env)
+			l := list(s)
+			copied[id(t)] = l
+			*task.dst = l
+			for i := len(t) - 1; i >= 0; i-- {
+				stack = append(stack, rebuildTask{src: t[i], dst: &l[i]})
+			}
+		case *partial:
+			p := partial{tail: new(Term)}
+			copied[id(t)] = &p
+			*task.dst = &p
+			stack = append(stack, rebuildPartial(&p, t)...)
+		case Compound:
+			args, err := makeSlice(t.Arity())
 			if err != nil {
-				return nil, err
+				return nil, resourceError(resourceMemory, env)
+			}
+			c := compound{
+				functor: t.Functor(),
+				args:    args,
+			}
+			copied[id(t)] = &c
+			*task.dst = &c
+			for i := t.Arity() - 1; i >= 0; i-- {
+				stack = append(stack, rebuildTask{src: t.Arg(i), dst: &c.args[i]})
+			}
+		default:
+			*task.dst = t
+		}
+	}
+	return ret, nil
+}
+
+// TermToBinary unifies bin with a list of bytes which encodes term. binary_to_term/2 restores it.
+func TermToBinary(vm *VM, term, bin Term, k Cont, env *Env) *Promise {
+	b, err := MarshalTerm(term, env)
+	switch err {
+	case nil:
+		break
+	case errMaxTermDepth:
+		return Error(resourceError(resourceTermDepth, env))
+	default:
+		return Error(err)
+	}
+
+	bs := make([]Term, len(b))
+	for i, e := range b {
+		bs[i] = Integer(e)
+	}
+	return Unify(vm, bin, List(bs...), k, env)
+}
+
+// BinaryToTerm decodes a list of bytes bin encoded by term_to_binary/2 and unifies the result with term.
+// Variables in the result are fresh but share with each other as they did in the encoded term.
+func BinaryToTerm(vm *VM, bin, term Term, k Cont, env *Env) *Promise {
+	var b []byte
+	iter := ListIterator{List: bin, Env: env}
+	for iter.Next() {
+		switch e := env.Resolve(iter.Current()).(type) {
+		case Variable:
+			return Error(InstantiationError(env))
+		case Integer:
+			if e < 0 || e > 255 {
+				return Error(typeError(validTypeByte, e, env))
 			}
-			c.args[i] = cp
+			b = append(b, byte(e))
+		default:
+			return Error(typeError(validTypeByte, e, env))
 		}
-		return &c, nil
+	}
+	if err := iter.Err(); err != nil {
+		return Error(err)
+	}
+
+	t, err := UnmarshalTerm(b)
+	switch err {
+	case nil:
+		break
+	case errMaxTermDepth:
+		return Error(resourceError(resourceTermDepth, env))
 	default:
-		return t, nil
+		return Error(syntaxError(err, env))
 	}
+	return Unify(vm, term, t, k, env)
 }
 
 // TermVariables succeeds if vars unifies with a list of variables in term.
@@ -463,9 +570,10 @@ func TermVariables(vm *VM, term, vars Term, k Cont, env *Env) *Promise {
 		ret      []Term
 		t        Term
 		traverse = []Term{term}
+		visited  visitedTerms
 	)
 	for len(traverse) > 0 {
-		t, traverse = traverse[0], traverse[1:]
+		t, traverse = traverse[len(traverse)-1], traverse[:len(traverse)-1]
 		switch t := env.Resolve(t).(type) {
 		case Variable:
 			if _, ok := witness[t]; !ok {
@@ -473,14 +581,17 @@ func TermVariables(vm *VM, term, vars Term, k Cont, env *Env) *Promise {
 			}
 			witness[t] = struct{}{}
 		case Compound:
+			if visited.visit(t) {
+				continue
+			}
 			args, err := makeSlice(t.Arity())
 			if err != nil {
 				return Error(resourceError(resourceMemory, env))
 			}
-			for i := 0; i < t.Arity(); i++ {
-				args[i] = t.Arg(i)
+			for i := range args {
+				args[i] = t.Arg(t.Arity() - 1 - i)
 			}
-			traverse = append(args, traverse...)
+			traverse = append(traverse, args...)
 		}
 	}
 
@@ -494,6 +605,130 @@ func TermVariables(vm *VM, term, vars Term, k Cont, env *Env) *Promise {
 	return Unify(vm, vars, List(ret...), k, env)
 }
 
+// TermFactorized succeeds if skeleton unifies with term whose cycles and shared subterms are replaced by variables and
+// subst unifies with a list of V=T which binds each of the variables to the subterm it replaced.
+func TermFactorized(vm *VM, term, skeleton, subst Term, k Cont, env *Env) *Promise {
+	skel, s := factorize(term, false, env)
+	return Unify(vm, tuple(skeleton, subst), tuple(skel, List(s...)), k, env)
+}
+
+// factorize returns the skeleton of t and a list of V=T.
+// If cyclesOnly is true, only the compounds which contain themselves are replaced.
+// Otherwise, the compounds reachable from the root through more than one path are also replaced.
+func factorize(t Term, cyclesOnly bool, env *Env) (Term, []Term) {
+	// First, we go through the term and find out the compounds to be replaced.
+	type frame struct {
+		c Compound
+		i int
+	}
+	var (
+		order  []Compound
+		count  = map[termID]int{}
+		onPath = map[termID]struct{}{}
+		cyclic = map[termID]struct{}{}
+		stack  []frame
+	)
+	visit := func(t Term) {
+		c, ok := factorizable(t, env)
+		if !ok {
+			return
+		}
+		k := id(c)
+		count[k]++
+		if _, ok := onPath[k]; ok {
+			cyclic[k] = struct{}{}
+			return
+		}
+		if count[k] > 1 {
+			return
+		}
+		onPath[k] = struct{}{}
+		order = append(order, c)
+		stack = append(stack, frame{c: c})
+	}
+	visit(t)
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		if f.i == f.c.Arity() {
+			delete(onPath, id(f.c))
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		arg := f.c.Arg(f.i)
+		f.i++
+		visit(arg)
+	}
+
+	vars := map[termID]Variable{}
+	for _, c := range order {
+		k := id(c)
+		_, ok := cyclic[k]
+		if ok || (!cyclesOnly && count[k] > 1) {
+			vars[k] = NewVariable()
+		}
+	}
+
+	// Then, we build the skeleton and the substitutions in which the compounds are replaced by the variables.
+	built := map[termID]Term{}
+	build := func(tasks []rebuildTask) {
+		for len(tasks) > 0 {
+			var task rebuildTask
+			task, tasks = tasks[len(tasks)-1], tasks[:len(tasks)-1]
+			c, ok := factorizable(task.src, env)
+			if !ok {
+				*task.dst = env.Resolve(task.src)
+				continue
+			}
+			k := id(c)
+			if v, ok := vars[k]; ok {
+				*task.dst = v
+				continue
+			}
+			if b, ok := built[k]; ok {
+				*task.dst = b
+				continue
+			}
+			n := compound{functor: c.Functor(), args: make([]Term, c.Arity())}
+			built[k] = &n
+			*task.dst = &n
+			for i := c.Arity() - 1; i >= 0; i-- {
+				tasks = append(tasks, rebuildTask{src: c.Arg(i), dst: &n.args[i]})
+			}
+		}
+	}
+
+	var skel Term
+	build([]rebuildTask{{src: t, dst: &skel}})
+
+	var subst []Term
+	for _, c := range order {
+		v, ok := vars[id(c)]
+		if !ok {
+			continue
+		}
+		n := compound{functor: c.Functor(), args: make([]Term, c.Arity())}
+		tasks := make([]rebuildTask, c.Arity())
+		for i := range tasks {
+			tasks[c.Arity()-1-i] = rebuildTask{src: c.Arg(i), dst: &n.args[i]}
+		}
+		build(tasks)
+		subst = append(subst, atomEqual.Apply(v, &n))
+	}
+	return skel, subst
+}
+
+// factorizable returns the compound that t refers to unless it's a character/code list which can't be cyclic.
+func factorizable(t Term, env *Env) (Compound, bool) {
+	switch t := env.Resolve(t).(type) {
+	case charList, codeList:
+		return nil, false
+	case Compound:
+		return t, true
+	default:
+		return nil, false
+	}
+}
+
 var operatorSpecifiers = map[Atom]operatorSpecifier{
 	atomFX:  operatorSpecifierFX,
 	atomFY:  operatorSpecifierFY,
@@ -719,6 +954,7 @@ func assertMerge(vm *VM, t Term, merge func([]clause, []clause) []clause, env *E
 	}
 
 	u.clauses = merge(u.clauses, added)
+	u.invalidate()
 	return nil
 }
 
@@ -1030,7 +1266,7 @@ func Throw(_ *VM, ball Term, _ Cont, env *Env) *Promise {
 // Catch calls goal. If an exception is thrown and unifies with catcher, it calls recover.
 func Catch(vm *VM, goal, catcher, recover Term, k Cont, env *Env) *Promise {
 	return catch(func(err error) *Promise {
-		e, ok := err.(Exception)
+		e, ok := exceptionOf(err, env)
 		if !ok {
 			e = Exception{term: atomError.Apply(NewAtom("system_error"), NewAtom(err.Error()))}
 		}
@@ -1080,6 +1316,66 @@ func CurrentPredicate(vm *VM, pi Term, k Cont, env *Env) *Promise {
 	return Delay(ks...)
 }
 
+// PredicateProperty succeeds iff head is the head of a procedure in the database with property.
+// Unlike current_predicate/1, it also finds the procedures defined in Go, e.g. by Register1.
+// The properties are defined, dynamic, static, multifile, discontiguous, number_of_clauses(N), and foreign for the
+// procedures defined in Go.
+func PredicateProperty(vm *VM, head, property Term, k Cont, env *Env) *Promise {
+	var pis []procedureIndicator
+	switch h := env.Resolve(head).(type) {
+	case Variable:
+		pis = make([]procedureIndicator, 0, len(vm.procedures))
+		for pi := range vm.procedures {
+			pis = append(pis, pi)
+		}
+	default:
+		pi, _, err := piArg(h, env)
+		if err != nil {
+			return Error(err)
+		}
+		if _, ok := vm.procedures[pi]; !ok {
+			return Bool(false)
+		}
+		pis = []procedureIndicator{pi}
+	}
+
+	var ks []func(context.Context) *Promise
+	for _, pi := range pis {
+		args := make([]Term, pi.arity)
+		for i := range args {
+			args[i] = NewVariable()
+		}
+		h := pi.name.Apply(args...)
+		for _, p := range procedureProperties(vm.procedures[pi]) {
+			p := p
+			ks = append(ks, func(context.Context) *Promise {
+				return Unify(vm, tuple(head, property), tuple(h, p), k, env)
+			})
+		}
+	}
+	return Delay(ks...)
+}
+
+func procedureProperties(p procedure) []Term {
+	u, ok := p.(*userDefined)
+	if !ok {
+		return []Term{atomDefined, atomStatic, atomForeign}
+	}
+	ps := []Term{atomDefined}
+	if u.dynamic {
+		ps = append(ps, atomDynamic)
+	} else {
+		ps = append(ps, atomStatic)
+	}
+	if u.multifile {
+		ps = append(ps, atomMultifile)
+	}
+	if u.discontiguous {
+		ps = append(ps, atomDiscontiguous)
+	}
+	return append(ps, atomNumberOfClauses.Apply(Integer(len(u.clauses))))
+}
+
 // Retract removes the first clause that matches with t.
 func Retract(vm *VM, t Term, k Cont, env *Env) *Promise {
 	t = rulify(t, env)
@@ -1109,6 +1405,7 @@ func Retract(vm *VM, t Term, k Cont, env *Env) *Promise {
 			return Unify(vm, t, raw, func(env *Env) *Promise {
 				j := i - deleted
 				u.clauses, u.clauses[len(u.clauses)-1] = append(u.clauses[:j], u.clauses[j+1:]...), clause{}
+				u.invalidate()
 				deleted++
 				return k(env)
 			}, env)
@@ -1488,13 +1785,33 @@ func WriteTerm(vm *VM, streamOrAlias, t, options Term, k Cont, env *Env) *Promis
 		return Error(err)
 	}
 
-	if err := env.Resolve(t).WriteTerm(w, &opts, env); err != nil {
+	t = env.Resolve(t)
+	if opts.cycles && cyclicTerm(t, env) {
+		t = writeCycles(&opts, t, env)
+	}
+
+	if err := t.WriteTerm(w, &opts, env); err != nil {
 		return Error(err)
 	}
 
 	return k(env)
 }
 
+// writeCycles returns @(Skeleton, Substitutions) of the cyclic term t and names the variables _S1, _S2, ... in opts.
+func writeCycles(opts *WriteOptions, t Term, env *Env) Term {
+	skel, subst := factorize(t, true, env)
+	vns := make(map[Variable]Atom, len(opts.variableNames)+len(subst))
+	for v, n := range opts.variableNames {
+		vns[v] = n
+	}
+	for i, s := range subst {
+		v := s.(Compound).Arg(0).(Variable)
+		vns[v] = NewAtom("_S" + strconv.Itoa(i+1))
+	}
+	opts.variableNames = vns
+	return atomAtSign.Apply(skel, List(subst...))
+}
+
 func writeTermOption(opts *WriteOptions, option Term, env *Env) error {
 	switch o := env.Resolve(option).(type) {
 	case Variable:
@@ -1540,6 +1857,9 @@ func writeTermOption(opts *WriteOptions, option Term, env *Env) error {
 		case atomNumberVars:
 			opts.numberVars = b
 			return nil
+		case atomCycles:
+			opts.cycles = b
+			return nil
 		default:
 			return domainError(validDomainWriteOption, o, env)
 		}
@@ -1702,9 +2022,13 @@ func PutChar(vm *VM, streamOrAlias, char Term, k Cont, env *Env) *Promise {
 }
 
 type readTermOptions struct {
-	singletons    Term
-	variables     Term
-	variableNames Term
+	singletons       Term
+	variables        Term
+	variableNames    Term
+	termPosition     Term
+	subtermPositions Term
+	comments         Term
+	syntaxErrors     Atom
 }
 
 // ReadTerm reads from the stream represented by streamOrAlias and unifies with stream.
@@ -1715,9 +2039,13 @@ func ReadTerm(vm *VM, streamOrAlias, out, options Term, k Cont, env *Env) *Promi
 	}
 
 	opts := readTermOptions{
-		singletons:    NewVariable(),
-		variables:     NewVariable(),
-		variableNames: NewVariable(),
+		singletons:       NewVariable(),
+		variables:        NewVariable(),
+		variableNames:    NewVariable(),
+		termPosition:     NewVariable(),
+		subtermPositions: NewVariable(),
+		comments:         NewVariable(),
+		syntaxErrors:     atomError,
 	}
 	iter := ListIterator{List: options, Env: env}
 	for iter.Next() {
@@ -1730,11 +2058,22 @@ func ReadTerm(vm *VM, streamOrAlias, out, options Term, k Cont, env *Env) *Promi
 	}
 
 	p := NewParser(vm, s)
+	p.keepPositions = true
+	p.lexer.keepComments = true
 	defer func() {
 		_ = s.UnreadRune()
 	}()
 
 	t, err := p.Term()
+	for opts.syntaxErrors == atomDec10 && isSyntaxError(err) {
+		// Skip the erroneous term and read the next one.
+		if err = p.resync(); err != nil {
+			break
+		}
+		p.Vars = nil
+		p.lexer.comments = nil
+		t, err = p.Term()
+	}
 	switch err {
 	case nil:
 		break
@@ -1746,7 +2085,12 @@ func ReadTerm(vm *VM, streamOrAlias, out, options Term, k Cont, env *Env) *Promi
 		return Error(permissionError(operationInput, permissionTypeBinaryStream, streamOrAlias, env))
 	case errPastEndOfStream:
 		return Error(permissionError(operationInput, permissionTypePastEndOfStream, streamOrAlias, env))
+	case errMaxTermDepth:
+		return Error(resourceError(resourceTermDepth, env))
 	default:
+		if opts.syntaxErrors != atomError {
+			return Bool(false)
+		}
 		return Error(syntaxError(err, env))
 	}
 
@@ -1759,16 +2103,27 @@ func ReadTerm(vm *VM, streamOrAlias, out, options Term, k Cont, env *Env) *Promi
 		variableNames = append(variableNames, atomEqual.Apply(v.Name, v.Variable))
 	}
 
+	comments := make([]Term, len(p.lexer.comments))
+	for i, c := range p.lexer.comments {
+		comments[i] = pair(c.pos.Term(), NewAtom(c.text))
+	}
+
 	return Unify(vm, tuple(
 		out,
 		opts.singletons,
 		opts.variables,
 		opts.variableNames,
+		opts.termPosition,
+		opts.subtermPositions,
+		opts.comments,
 	), tuple(
 		t,
 		List(singletons...),
 		List(variables...),
 		List(variableNames...),
+		p.termPosition().Term(),
+		p.subtermPositions(),
+		List(comments...),
 	), k, env)
 }
 
@@ -1789,6 +2144,19 @@ func readTermOption(opts *readTermOptions, option Term, env *Env) error {
 			opts.variables = v
 		case atomVariableNames:
 			opts.variableNames = v
+		case atomTermPosition:
+			opts.termPosition = v
+		case atomSubtermPositions:
+			opts.subtermPositions = v
+		case atomComments:
+			opts.comments = v
+		case atomSyntaxErrors:
+			switch v {
+			case atomError, atomFail, atomQuiet, atomDec10:
+				opts.syntaxErrors = v.(Atom)
+			default:
+				return domainError(validDomainReadOption, option, env)
+			}
 		default:
 			return domainError(validDomainReadOption, option, env)
 		}
@@ -1798,6 +2166,16 @@ func readTermOption(opts *readTermOptions, option Term, env *Env) error {
 	}
 }
 
+// isSyntaxError checks if err is caused by the text rather than the stream.
+func isSyntaxError(err error) bool {
+	switch err {
+	case nil, io.EOF, errWrongIOMode, errWrongStreamType, errPastEndOfStream, errMaxTermDepth:
+		return false
+	default:
+		return true
+	}
+}
+
 // GetByte reads a byte from the stream represented by streamOrAlias and unifies it with inByte.
 func GetByte(vm *VM, streamOrAlias, inByte Term, k Cont, env *Env) *Promise {
 	s, err := stream(vm, streamOrAlias, env)
@@ -2618,6 +2996,8 @@ func SetPrologFlag(vm *VM, flag, value Term, k Cont, env *Env) *Promise {
 			modify = modifyUnknown
 		case atomDoubleQuotes:
 			modify = modifyDoubleQuotes
+		case atomOccursCheck:
+			modify = modifyOccursCheck
 		default:
 			return Error(domainError(validDomainPrologFlag, f, env))
 		}
@@ -2690,6 +3070,20 @@ func modifyDoubleQuotes(vm *VM, value Atom) error {
 	return nil
 }
 
+func modifyOccursCheck(vm *VM, value Atom) error {
+	switch value {
+	case atomFalse:
+		vm.occursCheck = occursCheckFalse
+	case atomTrue:
+		vm.occursCheck = occursCheckTrue
+	case atomError:
+		vm.occursCheck = occursCheckError
+	default:
+		return domainError(validDomainFlagValue, atomPlus.Apply(atomOccursCheck, value), nil)
+	}
+	return nil
+}
+
 // CurrentPrologFlag succeeds iff flag is set to value.
 func CurrentPrologFlag(vm *VM, flag, value Term, k Cont, env *Env) *Promise {
 	switch f := env.Resolve(flag).(type) {
@@ -2697,7 +3091,7 @@ func CurrentPrologFlag(vm *VM, flag, value Term, k Cont, env *Env) *Promise {
 		break
 	case Atom:
 		switch f {
-		case atomBounded, atomMaxInteger, atomMinInteger, atomIntegerRoundingFunction, atomCharConversion, atomDebug, atomMaxArity, atomUnknown, atomDoubleQuotes:
+		case atomBounded, atomMaxInteger, atomMinInteger, atomIntegerRoundingFunction, atomCharConversion, atomDebug, atomMaxArity, atomUnknown, atomDoubleQuotes, atomOccursCheck:
 			break
 		default:
 			return Error(domainError(validDomainPrologFlag, f, env))
@@ -2717,6 +3111,7 @@ func CurrentPrologFlag(vm *VM, flag, value Term, k Cont, env *Env) *Promise {
 		tuple(atomMaxArity, atomUnbounded),
 		tuple(atomUnknown, NewAtom(vm.unknown.String())),
 		tuple(atomDoubleQuotes, NewAtom(vm.doubleQuotes.String())),
+		tuple(atomOccursCheck, NewAtom(vm.occursCheck.String())),
 	}
 	ks := make([]func(context.Context) *Promise, len(flags))
 	for i := range flags {
@@ -2768,6 +3163,289 @@ func expand(vm *VM, term Term, env *Env) (Term, error) {
 	return t, err
 }
 
+// ExpandGoal transforms goal1 and its subgoals according to goal_expansion/2 then unifies with goal2.
+func ExpandGoal(vm *VM, goal1, goal2 Term, k Cont, env *Env) *Promise {
+	return Delay(func(ctx context.Context) *Promise {
+		g, err := expandGoal(ctx, vm, goal1, 0, env)
+		if err != nil {
+			return Error(err)
+		}
+
+		return Unify(vm, g, goal2, k, env)
+	})
+}
+
+// metaArgs are the positions of the arguments of control constructs and meta predicates which are goals.
+var metaArgs = map[procedureIndicator][]int{
+	{name: atomComma, arity: 2}:     {0, 1},
+	{name: atomSemiColon, arity: 2}: {0, 1},
+	{name: atomThen, arity: 2}:      {0, 1},
+	{name: atomNegation, arity: 1}:  {0},
+	{name: atomCaret, arity: 2}:     {1},
+	{name: atomCall, arity: 1}:      {0},
+	{name: atomCatch, arity: 3}:     {0, 2},
+	{name: atomFindall, arity: 3}:   {1},
+	{name: atomFindall, arity: 4}:   {1},
+	{name: atomBagof, arity: 3}:     {1},
+	{name: atomSetof, arity: 3}:     {1},
+	{name: atomForall, arity: 2}:    {0, 1},
+	{name: atomOnce, arity: 1}:      {0},
+	{name: atomIgnore, arity: 1}:    {0},
+}
+
+// maxGoalExpansionDepth is the maximum number of times goal_expansion/2 rewrites a goal and its enclosing goals.
+// Beyond that, expansion fails with resource_error(goal_expansion_depth) instead of looping forever with rules like
+// goal_expansion(G, (G, true)).
+const maxGoalExpansionDepth = 256
+
+// expandGoal expands goal and its subgoals. depth is the number of the rewrites so far of goal and its enclosing goals.
+func expandGoal(ctx context.Context, vm *VM, goal Term, depth int, env *Env) (Term, error) {
+	goal = env.Resolve(goal)
+	if _, ok := goal.(Variable); ok {
+		return goal, nil
+	}
+
+	if _, ok := vm.procedures[procedureIndicator{name: atomGoalExpansion, arity: 2}]; ok {
+		// Expand until it reaches the fixed point.
+		for {
+			if depth >= maxGoalExpansionDepth {
+				return nil, resourceError(resourceGoalExpansionDepth, env)
+			}
+			var ret Term
+			v := NewVariable()
+			vars := env.freeVariables(goal)
+			ok, err := Call(vm, atomGoalExpansion.Apply(goal, v), func(env *Env) *Promise {
+				// Keep the variables in goal so that they're still shared with the rest of the clause.
+				var renamed *Env
+				for _, x := range vars {
+					if r, ok := env.Resolve(x).(Variable); ok && r != x {
+						if _, ok := renamed.lookup(r); !ok {
+							renamed = renamed.bind(r, x)
+						}
+					}
+				}
+				ret = renamed.simplify(env.simplify(v))
+				return Bool(true)
+			}, env).Force(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if !ok || goal.Compare(ret, env) == 0 {
+				break
+			}
+			goal = ret
+			depth++
+			if _, ok := goal.(Variable); ok {
+				return goal, nil
+			}
+		}
+	}
+
+	c, ok := goal.(Compound)
+	if !ok {
+		return goal, nil
+	}
+	ps, ok := metaArgs[procedureIndicator{name: c.Functor(), arity: Integer(c.Arity())}]
+	if !ok {
+		return goal, nil
+	}
+	args := make([]Term, c.Arity())
+	for i := range args {
+		args[i] = c.Arg(i)
+	}
+	for _, p := range ps {
+		g, err := expandGoal(ctx, vm, args[p], depth, env)
+		if err != nil {
+			return nil, err
+		}
+		args[p] = g
+	}
+	return c.Functor().Apply(args...), nil
+}
+
+type absoluteFileNameOptions struct {
+	exts       []string
+	directory  bool
+	access     bool
+	fail       bool
+	all        bool
+	relativeTo string
+}
+
+// AbsoluteFileName unifies absolute with the path of the file specified by spec which is either a path or an alias spec
+// Alias(Path) resolved by file_search_path/2. The path is relative to the root of VM.FS unless it's an absolute path.
+func AbsoluteFileName(vm *VM, spec, absolute, options Term, k Cont, env *Env) *Promise {
+	opts := absoluteFileNameOptions{
+		exts:       []string{""},
+		relativeTo: vm.loadingDir(),
+	}
+	iter := ListIterator{List: options, Env: env}
+	for iter.Next() {
+		if err := absoluteFileNameOption(&opts, iter.Current(), env); err != nil {
+			return Error(err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return Error(err)
+	}
+
+	return Delay(func(ctx context.Context) *Promise {
+		ps, err := vm.filePaths(ctx, spec, opts.exts, opts.relativeTo, env)
+		if err != nil {
+			return Error(err)
+		}
+
+		var found []Term
+		for _, p := range ps {
+			fi, err := fs.Stat(vm.FS, p)
+			if err != nil || fi.IsDir() != opts.directory {
+				continue
+			}
+			found = append(found, NewAtom(p))
+			if !opts.all {
+				break
+			}
+		}
+		if len(found) == 0 {
+			switch {
+			case !opts.access && len(ps) > 0:
+				found = []Term{NewAtom(ps[0])}
+			case opts.fail:
+				return Bool(false)
+			default:
+				return Error(existenceError(objectTypeSourceSink, spec, env))
+			}
+		}
+
+		ks := make([]func(context.Context) *Promise, len(found))
+		for i := range found {
+			f := found[i]
+			ks[i] = func(context.Context) *Promise {
+				return Unify(vm, absolute, f, k, env)
+			}
+		}
+		return Delay(ks...)
+	})
+}
+
+func absoluteFileNameOption(opts *absoluteFileNameOptions, option Term, env *Env) error {
+	switch o := env.Resolve(option).(type) {
+	case Variable:
+		return InstantiationError(env)
+	case Compound:
+		if o.Arity() != 1 {
+			return domainError(validDomainAbsoluteFileNameOption, option, env)
+		}
+
+		v := env.Resolve(o.Arg(0))
+		if _, ok := v.(Variable); ok {
+			return InstantiationError(env)
+		}
+		switch o.Functor() {
+		case atomExtensions:
+			opts.exts = opts.exts[:0]
+			iter := ListIterator{List: v, Env: env}
+			for iter.Next() {
+				e, ok := env.Resolve(iter.Current()).(Atom)
+				if !ok {
+					return domainError(validDomainAbsoluteFileNameOption, option, env)
+				}
+				ext := e.String()
+				if ext != "" && !strings.HasPrefix(ext, ".") {
+					ext = "." + ext
+				}
+				opts.exts = append(opts.exts, ext)
+			}
+			if err := iter.Err(); err != nil {
+				return domainError(validDomainAbsoluteFileNameOption, option, env)
+			}
+		case atomFileType:
+			switch v {
+			case atomTxt:
+				opts.exts, opts.directory = []string{""}, false
+			case atomProlog, atomSource:
+				opts.exts, opts.directory = []string{"", ".pl"}, false
+			case atomDirectory:
+				opts.exts, opts.directory = []string{""}, true
+			default:
+				return domainError(validDomainAbsoluteFileNameOption, option, env)
+			}
+		case atomAccess:
+			switch v {
+			case atomNone:
+				opts.access = false
+			case atomRead, atomWrite, atomAppend, atomExecute, atomExist:
+				opts.access = true
+			default:
+				return domainError(validDomainAbsoluteFileNameOption, option, env)
+			}
+		case atomFileErrors:
+			switch v {
+			case atomError:
+				opts.fail = false
+			case atomFail:
+				opts.fail = true
+			default:
+				return domainError(validDomainAbsoluteFileNameOption, option, env)
+			}
+		case atomSolutions:
+			switch v {
+			case atomFirst:
+				opts.all = false
+			case atomAll:
+				opts.all = true
+			default:
+				return domainError(validDomainAbsoluteFileNameOption, option, env)
+			}
+		case atomRelativeTo:
+			d, ok := v.(Atom)
+			if !ok {
+				return domainError(validDomainAbsoluteFileNameOption, option, env)
+			}
+			opts.relativeTo = d.String()
+		default:
+			return domainError(validDomainAbsoluteFileNameOption, option, env)
+		}
+		return nil
+	default:
+		return domainError(validDomainAbsoluteFileNameOption, option, env)
+	}
+}
+
+// PrologLoadContext succeeds iff key is one of source, file, directory, term_position, or module and value is the
+// corresponding information about the Prolog text being loaded.
+func PrologLoadContext(vm *VM, key, value Term, k Cont, env *Env) *Promise {
+	t := vm.loading
+	if t == nil {
+		return Bool(false)
+	}
+
+	var contexts []Term
+	if t.source != "" {
+		contexts = append(contexts, tuple(atomSource, NewAtom(t.source)))
+	}
+	if t.file != "" {
+		contexts = append(contexts,
+			tuple(atomFile, NewAtom(t.file)),
+			tuple(atomDirectory, NewAtom(path.Dir(t.file))),
+		)
+	}
+	contexts = append(contexts,
+		tuple(atomTermPosition, t.pos.Term()),
+		tuple(atomModule, atomUser),
+	)
+
+	pattern := tuple(key, value)
+	ks := make([]func(context.Context) *Promise, len(contexts))
+	for i := range contexts {
+		c := contexts[i]
+		ks[i] = func(context.Context) *Promise {
+			return Unify(vm, pattern, c, k, env)
+		}
+	}
+	return Delay(ks...)
+}
+
 // Nth0 succeeds if elem is the n-th element of list, counting from 0.
 func Nth0(vm *VM, n, list, elem Term, k Cont, env *Env) *Promise {
 	return nth(vm, 0, n, list, elem, k, env)
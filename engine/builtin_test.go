@@ -14,6 +14,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"unicode/utf8"
 )
 
@@ -62,6 +63,54 @@ f(g([a, [b, c|X]])).
 	}
 }
 
+func TestPrepareGoal(t *testing.T) {
+	var vm VM
+	assert.NoError(t, vm.Compile(context.Background(), `
+foo(a).
+foo(b).
+`))
+	vm.Register1(atomCall, func(vm *VM, goal Term, k Cont, env *Env) *Promise {
+		return Call(vm, goal, k, env)
+	})
+
+	x, y := NewVariable(), NewVariable()
+	tests := []struct {
+		title string
+		goal  Term
+		env   *Env
+		err   error
+		want  []Term
+	}{
+		{title: "free variable", goal: NewAtom("foo").Apply(x), want: []Term{NewAtom("a"), NewAtom("b")}},
+		{title: "bound variable", goal: NewAtom("foo").Apply(x), env: NewEnv().bind(x, NewAtom("b")), want: []Term{NewAtom("b")}},
+		{title: "disjunction", goal: atomSemiColon.Apply(NewAtom("foo").Apply(x), NewAtom("foo").Apply(x)), want: []Term{NewAtom("a"), NewAtom("b"), NewAtom("a"), NewAtom("b")}},
+		{title: "variable goal", goal: y, env: NewEnv().bind(y, NewAtom("foo").Apply(x)), want: []Term{NewAtom("a"), NewAtom("b")}},
+		{title: "not callable", goal: Integer(0), err: typeError(validTypeCallable, Integer(0), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			g, err := PrepareGoal(tt.goal)
+			assert.Equal(t, tt.err, err)
+			if err != nil {
+				return
+			}
+
+			// The prepared goal can be called repeatedly.
+			for i := 0; i < 2; i++ {
+				var got []Term
+				ok, err := g.Call(&vm, func(env *Env) *Promise {
+					got = append(got, env.Resolve(x))
+					return Bool(false)
+				}, tt.env).Force(context.Background())
+				assert.NoError(t, err)
+				assert.False(t, ok)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
 func TestCall1(t *testing.T) {
 	tests := []struct {
 		title      string
@@ -415,6 +464,34 @@ func TestUnify(t *testing.T) {
 	}
 }
 
+func TestUnify_occursCheck(t *testing.T) {
+	x := NewVariable()
+	tests := []struct {
+		title       string
+		occursCheck occursCheck
+		ok          bool
+		err         error
+	}{
+		{title: "false", occursCheck: occursCheckFalse, ok: true},
+		{title: "true", occursCheck: occursCheckTrue, ok: false},
+		{title: "error", occursCheck: occursCheckError, ok: false, err: occursCheckException(x, NewAtom("a").Apply(x), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			vm := VM{occursCheck: tt.occursCheck}
+			ok, err := Unify(&vm, x, NewAtom("a").Apply(x), Success, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				_, ok := NewEnv().Unify(tt.err.(Exception).term, err.(Exception).term)
+				assert.True(t, ok)
+			}
+		})
+	}
+}
+
 func TestUnifyWithOccursCheck(t *testing.T) {
 	x, y := NewVariable(), NewVariable()
 	tests := []struct {
@@ -587,6 +664,16 @@ func TestAcyclicTerm(t *testing.T) {
 	})
 }
 
+func TestAcyclicTerm_deep(t *testing.T) {
+	var c Term = NewAtom("a")
+	for i := 0; i < 1_000_000; i++ {
+		c = NewAtom("f").Apply(c, c)
+	}
+	ok, err := AcyclicTerm(nil, c, Success, nil).Force(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
 func TestFunctor(t *testing.T) {
 	x, y := NewVariable(), NewVariable()
 	a, b := NewVariable(), NewVariable()
@@ -864,6 +951,85 @@ func TestCopyTerm(t *testing.T) {
 	}
 }
 
+func TestCopyTerm_deep(t *testing.T) {
+	x := NewVariable()
+	var in Term = x
+	for i := 0; i < 1_000_000; i++ {
+		in = NewAtom("f").Apply(in)
+	}
+	out := NewVariable()
+	ok, err := CopyTerm(nil, in, out, func(env *Env) *Promise {
+		c := env.Resolve(out)
+		for i := 0; i < 1_000_000; i++ {
+			c = env.Resolve(c.(Compound).Arg(0))
+		}
+		assert.NotEqual(t, x, c)
+		assert.IsType(t, Variable(0), c)
+		return Bool(true)
+	}, nil).Force(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestTermToBinary(t *testing.T) {
+	x, y := NewVariable(), NewVariable()
+	in := NewAtom("f").Apply(x, List(NewAtom("a"), x), Float(2.5), y)
+	bin := NewVariable()
+	ok, err := TermToBinary(nil, in, bin, func(env *Env) *Promise {
+		return BinaryToTerm(nil, bin, NewAtom("f").Apply(NewAtom("b"), List(NewAtom("a"), NewAtom("b")), Float(2.5), y), Success, env)
+	}, nil).Force(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	t.Run("term depth", func(t *testing.T) {
+		var in Term = NewAtom("a")
+		for i := 0; i < 200_000; i++ {
+			in = NewAtom("f").Apply(in)
+		}
+		ok, err := TermToBinary(nil, in, NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, resourceError(resourceTermDepth, nil), err)
+		assert.False(t, ok)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		s := &Stream{}
+		ok, err := TermToBinary(nil, s, NewVariable(), Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeSerializable, s, nil), err)
+		assert.False(t, ok)
+	})
+}
+
+func TestBinaryToTerm(t *testing.T) {
+	tests := []struct {
+		title string
+		bin   Term
+		term  Term
+		ok    bool
+		err   error
+	}{
+		{title: "ok", bin: List(Integer(termBinaryVersion), Integer(termTagInteger), Integer(2)), term: Integer(1), ok: true},
+		{title: "bin is a partial list", bin: PartialList(NewVariable(), Integer(termBinaryVersion)), err: InstantiationError(nil)},
+		{title: "an element of bin is a variable", bin: List(NewVariable()), err: InstantiationError(nil)},
+		{title: "an element of bin is not a byte", bin: List(Integer(256)), err: typeError(validTypeByte, Integer(256), nil)},
+		{title: "an element of bin is not an integer", bin: List(NewAtom("a")), err: typeError(validTypeByte, NewAtom("a"), nil)},
+		{title: "invalid encoding", bin: List(Integer(termBinaryVersion)), err: syntaxError(errInvalidEncoding, nil)},
+		{title: "compound of arity 0", bin: List(Integer(1), Integer(6), Integer(1), Integer(1), Integer(102), Integer(0)), err: syntaxError(errInvalidEncoding, nil)},
+		{title: "nil argument", bin: List(Integer(1), Integer(6), Integer(1), Integer(1), Integer(102), Integer(1), Integer(0)), err: syntaxError(errInvalidEncoding, nil)},
+		{title: "empty list", bin: List(Integer(1), Integer(7), Integer(0)), err: syntaxError(errInvalidEncoding, nil)},
+		{title: "empty char list", bin: List(Integer(1), Integer(9), Integer(0)), err: syntaxError(errInvalidEncoding, nil)},
+		{title: "empty code list", bin: List(Integer(1), Integer(10), Integer(0)), err: syntaxError(errInvalidEncoding, nil)},
+		{title: "procedure indicator", bin: List(Integer(1), Integer(11), Integer(1), Integer(1), Integer(102), Integer(2)), err: syntaxError(errInvalidEncoding, nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := BinaryToTerm(nil, tt.bin, tt.term, Success, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
 func TestTermVariables(t *testing.T) {
 	vars := NewVariable()
 	vs, vt := NewVariable(), NewVariable()
@@ -926,6 +1092,62 @@ func TestTermVariables(t *testing.T) {
 	}
 }
 
+func TestTermVariables_cyclic(t *testing.T) {
+	f := NewAtom("f")
+	x, y, vars := NewVariable(), NewVariable(), NewVariable()
+	env := NewEnv().bind(x, f.Apply(x, y))
+	ok, err := TermVariables(nil, x, vars, func(env *Env) *Promise {
+		assert.Equal(t, List(y), env.Resolve(vars))
+		return Bool(true)
+	}, env).Force(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestTermFactorized(t *testing.T) {
+	f, g := NewAtom("f"), NewAtom("g")
+
+	t.Run("cyclic", func(t *testing.T) {
+		x, skel, subst := NewVariable(), NewVariable(), NewVariable()
+		env := NewEnv().bind(x, f.Apply(x))
+		ok, err := TermFactorized(nil, x, skel, subst, func(env *Env) *Promise {
+			v, ok := env.Resolve(skel).(Variable)
+			assert.True(t, ok)
+			assert.Equal(t, List(atomEqual.Apply(v, f.Apply(v))), env.simplify(subst))
+			return Bool(true)
+		}, env).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		a := g.Apply(NewAtom("a"))
+		skel, subst := NewVariable(), NewVariable()
+		ok, err := TermFactorized(nil, f.Apply(a, a, g.Apply(NewAtom("b"))), skel, subst, func(env *Env) *Promise {
+			s, ok := env.Resolve(skel).(Compound)
+			assert.True(t, ok)
+			v, ok := env.Resolve(s.Arg(0)).(Variable)
+			assert.True(t, ok)
+			assert.Equal(t, f.Apply(v, v, g.Apply(NewAtom("b"))), env.simplify(s))
+			assert.Equal(t, List(atomEqual.Apply(v, a)), env.simplify(subst))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("acyclic", func(t *testing.T) {
+		skel, subst := NewVariable(), NewVariable()
+		ok, err := TermFactorized(nil, f.Apply(NewAtom("a")), skel, subst, func(env *Env) *Promise {
+			assert.Equal(t, f.Apply(NewAtom("a")), env.Resolve(skel))
+			assert.Equal(t, List(), env.Resolve(subst))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
 func TestOp(t *testing.T) {
 	t.Run("insert", func(t *testing.T) {
 		t.Run("atom", func(t *testing.T) {
@@ -2385,6 +2607,14 @@ func TestCatch(t *testing.T) {
 	vm.Register0(atomFail, func(*VM, Cont, *Env) *Promise {
 		return Bool(false)
 	})
+	vm.Register1(NewAtom("domain"), func(_ *VM, x Term, _ Cont, env *Env) *Promise {
+		return Error(&DomainErr{Domain: NewAtom("positive"), Culprit: env.Resolve(x)})
+	})
+	vm.Register0(NewAtom("delayed"), func(*VM, Cont, *Env) *Promise {
+		return Delay(func(context.Context) *Promise {
+			return Error(fmt.Errorf("wrapped: %w", &TypeErr{Type: atomInteger, Culprit: NewAtom("a")}))
+		})
+	})
 
 	t.Run("match", func(t *testing.T) {
 		v := NewVariable()
@@ -2429,6 +2659,18 @@ func TestCatch(t *testing.T) {
 		assert.Error(t, err)
 		assert.False(t, ok)
 	})
+	t.Run("typed error", func(t *testing.T) {
+		x := NewVariable()
+		ok, err := Catch(&vm, NewAtom("domain").Apply(Integer(-1)), atomError.Apply(atomDomainError.Apply(NewAtom("positive"), x), atomSlash.Apply(NewAtom("domain"), Integer(1))), atomEqual.Apply(x, Integer(-1)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("delayed typed error", func(t *testing.T) {
+		ok, err := Catch(&vm, NewAtom("delayed"), atomError.Apply(atomTypeError.Apply(atomInteger, NewAtom("a")), atomSlash.Apply(NewAtom("delayed"), Integer(0))), atomTrue, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
 }
 
 func TestCurrentPredicate(t *testing.T) {
@@ -2896,6 +3138,59 @@ func TestAsserta(t *testing.T) {
 	})
 }
 
+func TestPredicateProperty(t *testing.T) {
+	vm := VM{procedures: map[procedureIndicator]procedure{
+		{name: NewAtom("foo"), arity: 1}: &userDefined{dynamic: true, multifile: true, clauses: clauses{{}, {}}},
+		{name: NewAtom("bar"), arity: 0}: &userDefined{discontiguous: true},
+		{name: NewAtom("baz"), arity: 2}: Predicate2(func(_ *VM, _, _ Term, k Cont, env *Env) *Promise {
+			return k(env)
+		}),
+	}}
+
+	properties := func(head Term) []Term {
+		var ps []Term
+		p := NewVariable()
+		ok, err := PredicateProperty(&vm, head, p, func(env *Env) *Promise {
+			ps = append(ps, env.Resolve(p))
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		return ps
+	}
+
+	t.Run("user defined", func(t *testing.T) {
+		assert.Equal(t, []Term{atomDefined, atomDynamic, atomMultifile, atomNumberOfClauses.Apply(Integer(2))}, properties(NewAtom("foo").Apply(NewAtom("a"))))
+		assert.Equal(t, []Term{atomDefined, atomStatic, atomDiscontiguous, atomNumberOfClauses.Apply(Integer(0))}, properties(NewAtom("bar")))
+	})
+
+	t.Run("defined in Go", func(t *testing.T) {
+		assert.Equal(t, []Term{atomDefined, atomStatic, atomForeign}, properties(NewAtom("baz").Apply(NewVariable(), NewVariable())))
+	})
+
+	t.Run("undefined", func(t *testing.T) {
+		assert.Empty(t, properties(NewAtom("qux")))
+	})
+
+	t.Run("variable", func(t *testing.T) {
+		var heads []Term
+		h := NewVariable()
+		ok, err := PredicateProperty(&vm, h, atomForeign, func(env *Env) *Promise {
+			heads = append(heads, env.Resolve(h))
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Len(t, heads, 1)
+		assert.Equal(t, NewAtom("baz"), heads[0].(Compound).Functor())
+	})
+
+	t.Run("not callable", func(t *testing.T) {
+		_, err := PredicateProperty(&vm, Integer(0), atomDefined, Success, nil).Force(context.Background())
+		assert.Equal(t, typeError(validTypeCallable, Integer(0), nil), err)
+	})
+}
+
 func TestRetract(t *testing.T) {
 	t.Run("retract the first one", func(t *testing.T) {
 		vm := VM{
@@ -3973,6 +4268,7 @@ func TestWriteTerm(t *testing.T) {
 	l := NewVariable()
 	e := NewVariable()
 	n, v := NewVariable(), NewVariable()
+	c := NewVariable()
 
 	err := errors.New("failed")
 
@@ -4034,6 +4330,9 @@ func TestWriteTerm(t *testing.T) {
 			atomEqual.Apply(NewAtom("a"), NewAtom("b")), // ignored
 		))), ok: true, output: `n`},
 
+		{title: `cycles`, sOrA: w, term: c, options: List(atomCycles.Apply(atomTrue)), env: NewEnv().bind(c, NewAtom("f").Apply(c)), ok: true, output: `@(_S1,[=(_S1,f(_S1))])`},
+		{title: `cycles acyclic`, sOrA: w, term: NewAtom("f").Apply(NewAtom("a")), options: List(atomCycles.Apply(atomTrue)), ok: true, output: `f(a)`},
+
 		{title: `failure`, sOrA: mw, term: NewAtom("foo"), options: List(), err: err},
 	}
 
@@ -4377,6 +4676,95 @@ func TestReadTerm(t *testing.T) {
 		assert.True(t, ok)
 	})
 
+	t.Run("positions", func(t *testing.T) {
+		s := &Stream{source: strings.NewReader("foo(X). % first\n% second\n  bar(\n  Y)."), mode: ioModeRead}
+
+		var vm VM
+		pos, subPos, comments := NewVariable(), NewVariable(), NewVariable()
+		options := List(atomTermPosition.Apply(pos), atomSubtermPositions.Apply(subPos), atomComments.Apply(comments))
+
+		ok, err := ReadTerm(&vm, s, NewVariable(), options, func(env *Env) *Promise {
+			assert.Equal(t, Position{Offset: 0, Line: 1, Column: 1}.Term(), env.Resolve(pos))
+			assert.Equal(t, atomTermPosition.Apply(Integer(0), Integer(6), Integer(0), Integer(3), List(pair(Integer(4), Integer(5)))), env.Resolve(subPos))
+			assert.Equal(t, List(), env.Resolve(comments))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = ReadTerm(&vm, s, NewVariable(), options, func(env *Env) *Promise {
+			assert.Equal(t, Position{Offset: 27, Line: 3, Column: 3}.Term(), env.Resolve(pos))
+			assert.Equal(t, atomTermPosition.Apply(Integer(27), Integer(36), Integer(27), Integer(30), List(pair(Integer(34), Integer(35)))), env.Resolve(subPos))
+			assert.Equal(t, List(
+				pair(Position{Offset: 8, Line: 1, Column: 9}.Term(), NewAtom("% first")),
+				pair(Position{Offset: 16, Line: 2, Column: 1}.Term(), NewAtom("% second")),
+			), env.Resolve(comments))
+			return Bool(true)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("syntax error position", func(t *testing.T) {
+		s := &Stream{source: strings.NewReader("foo.\nbar(a b)."), mode: ioModeRead}
+
+		var vm VM
+		ok, err := ReadTerm(&vm, s, NewVariable(), List(), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = ReadTerm(&vm, s, NewVariable(), List(), Success, nil).Force(context.Background())
+		assert.Equal(t, NewException(atomError.Apply(
+			atomSyntaxError.Apply(NewAtom("unexpected token: letter digit(b)")),
+			atomPosition.Apply(Integer(2), Integer(7), Integer(11)),
+		), nil), err)
+		assert.False(t, ok)
+	})
+
+	t.Run("syntax_errors", func(t *testing.T) {
+		tests := []struct {
+			title  string
+			option Term
+			ok     bool
+			err    error
+			term   Term
+		}{
+			{title: "error", option: atomError, err: NewException(atomError.Apply(
+				atomSyntaxError.Apply(NewAtom("unexpected token: letter digit(b)")),
+				atomPosition.Apply(Integer(1), Integer(7), Integer(6)),
+			), nil)},
+			{title: "fail", option: atomFail},
+			{title: "quiet", option: atomQuiet},
+			{title: "dec10", option: atomDec10, ok: true, term: NewAtom("baz")},
+			{title: "unknown", option: NewAtom("foo"), err: domainError(validDomainReadOption, atomSyntaxErrors.Apply(NewAtom("foo")), nil)},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.title, func(t *testing.T) {
+				s := &Stream{source: strings.NewReader("bar(a b).\nbaz."), mode: ioModeRead}
+
+				var vm VM
+				v := NewVariable()
+				ok, err := ReadTerm(&vm, s, v, List(atomSyntaxErrors.Apply(tt.option)), func(env *Env) *Promise {
+					assert.Equal(t, tt.term, env.Resolve(v))
+					return Bool(true)
+				}, nil).Force(context.Background())
+				assert.Equal(t, tt.err, err)
+				assert.Equal(t, tt.ok, ok)
+			})
+		}
+	})
+
+	t.Run("too deep", func(t *testing.T) {
+		n := maxTermDepth + 1
+		s := &Stream{source: strings.NewReader(strings.Repeat("f(", n) + "a" + strings.Repeat(")", n) + "."), mode: ioModeRead}
+
+		var vm VM
+		ok, err := ReadTerm(&vm, s, NewVariable(), List(), Success, nil).Force(context.Background())
+		assert.Equal(t, resourceError(resourceTermDepth, nil), err)
+		assert.False(t, ok)
+	})
+
 	t.Run("singletons", func(t *testing.T) {
 		f, err := os.Open("testdata/vars.txt")
 		assert.NoError(t, err)
@@ -4640,7 +5028,7 @@ func TestReadTerm(t *testing.T) {
 
 			var vm VM
 			ok, err := ReadTerm(&vm, s, NewVariable(), List(), Success, nil).Force(context.Background())
-			assert.Equal(t, syntaxError(unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "bar"}}, nil), err)
+			assert.Equal(t, syntaxError(&SyntaxErr{Position: Position{Offset: 4, Line: 1, Column: 5}, Err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "bar"}}}, nil), err)
 			assert.False(t, ok)
 		})
 
@@ -4676,7 +5064,7 @@ func TestReadTerm(t *testing.T) {
 
 		var vm VM
 		ok, err := ReadTerm(&vm, s, NewVariable(), List(), Success, nil).Force(context.Background())
-		assert.Equal(t, syntaxError(unexpectedTokenError{actual: Token{kind: tokenGraphic, val: "="}}, nil), err)
+		assert.Equal(t, syntaxError(&SyntaxErr{Position: Position{Offset: 2, Line: 1, Column: 3}, Err: unexpectedTokenError{actual: Token{kind: tokenGraphic, val: "="}}}, nil), err)
 		assert.False(t, ok)
 	})
 }
@@ -6538,6 +6926,39 @@ func TestSetPrologFlag(t *testing.T) {
 		})
 	})
 
+	t.Run("occurs_check", func(t *testing.T) {
+		t.Run("false", func(t *testing.T) {
+			vm := VM{occursCheck: occursCheckError}
+			ok, err := SetPrologFlag(&vm, atomOccursCheck, atomFalse, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, occursCheckFalse, vm.occursCheck)
+		})
+
+		t.Run("true", func(t *testing.T) {
+			var vm VM
+			ok, err := SetPrologFlag(&vm, atomOccursCheck, atomTrue, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, occursCheckTrue, vm.occursCheck)
+		})
+
+		t.Run("error", func(t *testing.T) {
+			var vm VM
+			ok, err := SetPrologFlag(&vm, atomOccursCheck, atomError, Success, nil).Force(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, occursCheckError, vm.occursCheck)
+		})
+
+		t.Run("unknown", func(t *testing.T) {
+			var vm VM
+			ok, err := SetPrologFlag(&vm, atomOccursCheck, NewAtom("foo"), Success, nil).Force(context.Background())
+			assert.Equal(t, domainError(validDomainFlagValue, atomPlus.Apply(atomOccursCheck, NewAtom("foo")), nil), err)
+			assert.False(t, ok)
+		})
+	})
+
 	t.Run("flag is a variable", func(t *testing.T) {
 		var vm VM
 		ok, err := SetPrologFlag(&vm, NewVariable(), atomFail, Success, nil).Force(context.Background())
@@ -6619,6 +7040,10 @@ func TestCurrentPrologFlag(t *testing.T) {
 		ok, err = CurrentPrologFlag(&vm, atomUnknown, atomError, Success, nil).Force(context.Background())
 		assert.NoError(t, err)
 		assert.True(t, ok)
+
+		ok, err = CurrentPrologFlag(&vm, atomOccursCheck, atomFalse, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
 	})
 
 	t.Run("not specified", func(t *testing.T) {
@@ -6653,6 +7078,9 @@ func TestCurrentPrologFlag(t *testing.T) {
 			case 8:
 				assert.Equal(t, atomDoubleQuotes, env.Resolve(flag))
 				assert.Equal(t, NewAtom(vm.doubleQuotes.String()), env.Resolve(value))
+			case 9:
+				assert.Equal(t, atomOccursCheck, env.Resolve(flag))
+				assert.Equal(t, atomFalse, env.Resolve(value))
 			default:
 				assert.Fail(t, "unreachable")
 			}
@@ -6661,7 +7089,7 @@ func TestCurrentPrologFlag(t *testing.T) {
 		}, nil).Force(context.Background())
 		assert.NoError(t, err)
 		assert.False(t, ok)
-		assert.Equal(t, 9, c)
+		assert.Equal(t, 10, c)
 	})
 
 	t.Run("flag is neither a variable nor an atom", func(t *testing.T) {
@@ -6984,6 +7412,140 @@ term_expansion(f(X), g(X)).
 	}
 }
 
+func TestExpandGoal(t *testing.T) {
+	f, g, h := NewAtom("f"), NewAtom("g"), NewAtom("h")
+	a, b := NewAtom("a"), NewAtom("b")
+	x := NewVariable()
+
+	var vm VM
+	vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+	assert.NoError(t, vm.Compile(context.Background(), `
+goal_expansion(f(X), g(X)).
+goal_expansion(g(a), h(b)).
+goal_expansion(loop, loop).
+goal_expansion(grow(X), grow(','(X, true))).
+goal_expansion(nest, ','(nest, true)).
+`))
+
+	tests := []struct {
+		title   string
+		in, out Term
+		ok      bool
+		err     error
+	}{
+		{title: "not applicable", in: h.Apply(a), out: h.Apply(a), ok: true},
+		{title: "applicable", in: f.Apply(b), out: g.Apply(b), ok: true},
+		{title: "repeatedly", in: f.Apply(a), out: h.Apply(b), ok: true},
+		{title: "fixed point", in: NewAtom("loop"), out: NewAtom("loop"), ok: true},
+		{title: "no fixed point", in: NewAtom("grow").Apply(atomTrue), out: NewVariable(), err: resourceError(resourceGoalExpansionDepth, nil)},
+		{title: "no fixed point in subgoals", in: NewAtom("nest"), out: NewVariable(), err: resourceError(resourceGoalExpansionDepth, nil)},
+		{title: "variable", in: x, out: x, ok: true},
+		{title: "control constructs", in: atomComma.Apply(f.Apply(a), atomSemiColon.Apply(atomThen.Apply(f.Apply(b), atomNegation.Apply(f.Apply(b))), h.Apply(a))), out: atomComma.Apply(h.Apply(b), atomSemiColon.Apply(atomThen.Apply(g.Apply(b), atomNegation.Apply(g.Apply(b))), h.Apply(a))), ok: true},
+		{title: "meta predicates", in: atomFindall.Apply(x, atomCaret.Apply(x, f.Apply(b)), NewVariable()), out: atomFindall.Apply(x, atomCaret.Apply(x, g.Apply(b)), NewVariable()), ok: true},
+		{title: "non-goal arguments", in: atomFindall.Apply(f.Apply(a), f.Apply(a), f.Apply(a)), out: atomFindall.Apply(f.Apply(a), h.Apply(b), f.Apply(a)), ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := ExpandGoal(&vm, tt.in, tt.out, Success, nil).Force(context.Background())
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestAbsoluteFileName(t *testing.T) {
+	var vm VM
+	vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+	vm.operators.define(400, operatorSpecifierYFX, atomSlash)
+	vm.FS = fstest.MapFS{
+		"lib/lists.pl":     &fstest.MapFile{Data: []byte("")},
+		"lib/lists.txt":    &fstest.MapFile{Data: []byte("")},
+		"extra/lists.pl":   &fstest.MapFile{Data: []byte("")},
+		"lib/sub/assoc.pl": &fstest.MapFile{Data: []byte("")},
+		"src/main.pl":      &fstest.MapFile{Data: []byte("")},
+		"src/util/x.pl":    &fstest.MapFile{Data: []byte("")},
+	}
+	assert.NoError(t, vm.Compile(context.Background(), `
+file_search_path(library, lib).
+file_search_path(library, extra).
+`))
+
+	f := NewVariable()
+	tests := []struct {
+		title   string
+		spec    Term
+		options Term
+		paths   []Term
+		err     error
+	}{
+		{title: "path", spec: NewAtom("lib/lists.pl"), options: List(), paths: []Term{NewAtom("lib/lists.pl")}},
+		{title: "clean", spec: NewAtom("./lib/../lib/lists.pl"), options: List(), paths: []Term{NewAtom("lib/lists.pl")}},
+		{title: "not found", spec: NewAtom("lib/foo"), options: List(), paths: []Term{NewAtom("lib/foo")}},
+		{title: "file_type(prolog)", spec: NewAtom("lib/lists"), options: List(atomFileType.Apply(atomProlog)), paths: []Term{NewAtom("lib/lists.pl")}},
+		{title: "extensions", spec: NewAtom("lib/lists"), options: List(atomExtensions.Apply(List(NewAtom("txt"), NewAtom(".pl")))), paths: []Term{NewAtom("lib/lists.txt")}},
+		{title: "file_type(directory)", spec: NewAtom("lib/sub"), options: List(atomFileType.Apply(atomDirectory), atomAccess.Apply(atomExist)), paths: []Term{NewAtom("lib/sub")}},
+		{title: "alias", spec: NewAtom("library").Apply(NewAtom("lists")), options: List(atomFileType.Apply(atomProlog)), paths: []Term{NewAtom("lib/lists.pl")}},
+		{title: "alias, sub directory", spec: NewAtom("library").Apply(atomSlash.Apply(NewAtom("sub"), NewAtom("assoc"))), options: List(atomFileType.Apply(atomProlog)), paths: []Term{NewAtom("lib/sub/assoc.pl")}},
+		{title: "solutions(all)", spec: NewAtom("library").Apply(NewAtom("lists")), options: List(atomFileType.Apply(atomProlog), atomSolutions.Apply(atomAll)), paths: []Term{NewAtom("lib/lists.pl"), NewAtom("extra/lists.pl")}},
+		{title: "relative_to", spec: NewAtom("util/x"), options: List(atomFileType.Apply(atomProlog), atomRelativeTo.Apply(NewAtom("src"))), paths: []Term{NewAtom("src/util/x.pl")}},
+		{title: "access, file_errors(fail)", spec: NewAtom("lib/foo"), options: List(atomAccess.Apply(atomRead), atomFileErrors.Apply(atomFail))},
+		{title: "access", spec: NewAtom("lib/foo"), options: List(atomAccess.Apply(atomRead)), err: existenceError(objectTypeSourceSink, NewAtom("lib/foo"), nil)},
+		{title: "unknown alias", spec: NewAtom("foo").Apply(NewAtom("bar")), options: List(atomAccess.Apply(atomRead)), err: existenceError(objectTypeSourceSink, NewAtom("foo").Apply(NewAtom("bar")), nil)},
+		{title: "spec is a variable", spec: NewVariable(), options: List(), err: InstantiationError(nil)},
+		{title: "spec is not a path", spec: Integer(1), options: List(), err: typeError(validTypeAtom, Integer(1), nil)},
+		{title: "unknown option", spec: NewAtom("lib/lists"), options: List(NewAtom("foo").Apply(NewAtom("bar"))), err: domainError(validDomainAbsoluteFileNameOption, NewAtom("foo").Apply(NewAtom("bar")), nil)},
+		{title: "invalid option value", spec: NewAtom("lib/lists"), options: List(atomSolutions.Apply(NewAtom("some"))), err: domainError(validDomainAbsoluteFileNameOption, atomSolutions.Apply(NewAtom("some")), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			var paths []Term
+			_, err := AbsoluteFileName(&vm, tt.spec, f, tt.options, func(env *Env) *Promise {
+				paths = append(paths, env.Resolve(f))
+				return Bool(false)
+			}, nil).Force(context.Background())
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.paths, paths)
+		})
+	}
+}
+
+func TestPrologLoadContext(t *testing.T) {
+	var vm VM
+	vm.operators.define(1200, operatorSpecifierFX, atomIf)
+	vm.FS = fstest.MapFS{
+		"lib/a.pl": &fstest.MapFile{Data: []byte(":- include('lib/b').\n")},
+		"lib/b.pl": &fstest.MapFile{Data: []byte("\n:- record.\n")},
+	}
+	var contexts []Term
+	vm.Register0(NewAtom("record"), func(vm *VM, k Cont, env *Env) *Promise {
+		key, value := NewVariable(), NewVariable()
+		_, _ = PrologLoadContext(vm, key, value, func(env *Env) *Promise {
+			contexts = append(contexts, atomMinus.Apply(env.Resolve(key), env.Resolve(value)))
+			return Bool(false)
+		}, env).Force(context.Background())
+		return k(env)
+	})
+
+	t.Run("not loading", func(t *testing.T) {
+		ok, err := PrologLoadContext(&vm, NewVariable(), NewVariable(), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("loading", func(t *testing.T) {
+		assert.NoError(t, vm.Compile(context.Background(), `:- ensure_loaded('lib/a').`))
+		assert.Equal(t, []Term{
+			atomMinus.Apply(atomSource, NewAtom("lib/a.pl")),
+			atomMinus.Apply(atomFile, NewAtom("lib/b.pl")),
+			atomMinus.Apply(atomDirectory, NewAtom("lib")),
+			atomMinus.Apply(atomTermPosition, atomPosition.Apply(Integer(2), Integer(1), Integer(1))),
+			atomMinus.Apply(atomModule, atomUser),
+		}, contexts)
+	})
+}
+
 func TestNth0(t *testing.T) {
 	t.Run("n is a variable", func(t *testing.T) {
 		t.Run("list is a proper list", func(t *testing.T) {
@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"errors"
+	"sync/atomic"
 )
 
 type userDefined struct {
@@ -11,8 +12,14 @@ type userDefined struct {
 	multifile     bool
 	discontiguous bool
 
+	// file is the name of the file that defines the procedure.
+	file string
+
 	// 7.4.3 says "If no clauses are defined for a procedure indicated by a directive ... then the procedure shall exist but have no clauses."
 	clauses
+
+	// compiled is a cache of the clauses translated for BackendRegister.
+	compiled atomic.Pointer[regProcedure]
 }
 
 type clauses []clause
@@ -61,6 +68,7 @@ type clause struct {
 	raw      Term
 	vars     []Variable
 	bytecode bytecode
+	file     string // the file which defines the clause
 }
 
 func compileClause(head Term, body Term, env *Env) (clause, error) {
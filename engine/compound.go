@@ -19,44 +19,97 @@ type Compound interface {
 
 // WriteCompound outputs the Compound to an io.Writer.
 func WriteCompound(w io.Writer, c Compound, opts *WriteOptions, env *Env) error {
-	ok, err := writeCompoundVisit(w, c, opts)
-	if err != nil || ok {
-		return err
+	if opts.visited == nil {
+		opts.visited = map[termID]struct{}{}
+	}
+
+	// The steps to write the compound in reverse order. We don't recurse so that deep terms don't overflow the call stack.
+	var (
+		ew    = newErrWriter(w)
+		stack = []writeStep{{term: c, opts: opts}}
+	)
+	for len(stack) > 0 && ew.err == nil {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		switch {
+		case s.leave != nil:
+			delete(s.opts.visited, id(s.leave))
+		case s.term == nil:
+			_, _ = fmt.Fprint(ew, s.s)
+		default:
+			t := s.term
+			if v, ok := t.(Variable); ok {
+				t = env.Resolve(v)
+			}
+			c, ok := writtenByWriteCompound(t)
+			if !ok {
+				ew.writeTerm(t, s.opts, env)
+				continue
+			}
+			if ok, _ := writeCompoundVisit(ew, c, s.opts); ok {
+				continue
+			}
+			stack = append(stack, writeStep{leave: c, opts: s.opts})
+			steps := writeCompoundSteps(c, s.opts, env)
+			for i := len(steps) - 1; i >= 0; i-- {
+				stack = append(stack, steps[i])
+			}
+		}
+	}
+	return ew.err
+}
+
+// writeStep is either a term, a string, or the end of a compound to write.
+type writeStep struct {
+	term  Term
+	s     string
+	opts  *WriteOptions
+	leave Compound // the compound which is no longer on the path from the root.
+}
+
+// writtenByWriteCompound returns t as a Compound if its WriteTerm is WriteCompound.
+// We write them in the same loop instead of calling WriteTerm.
+func writtenByWriteCompound(t Term) (Compound, bool) {
+	switch t := t.(type) {
+	case *compound, list, *partial, charList, codeList, procedureIndicator:
+		return t.(Compound), true
+	default:
+		return nil, false
 	}
+}
 
+// writeCompoundSteps returns the steps to write c in order.
+func writeCompoundSteps(c Compound, opts *WriteOptions, env *Env) []writeStep {
 	a := env.Resolve(c.Arg(0))
 	if n, ok := a.(Integer); ok && opts.numberVars && c.Functor() == atomVar && c.Arity() == 1 && n >= 0 {
-		return writeCompoundNumberVars(w, n)
+		return []writeStep{{s: writeCompoundNumberVars(n)}}
 	}
 
 	if !opts.ignoreOps {
 		if c.Functor() == atomDot && c.Arity() == 2 {
-			return writeCompoundList(w, c, opts, env)
+			return writeCompoundList(c, opts, env)
 		}
 
 		if c.Functor() == atomEmptyBlock && c.Arity() == 1 {
-			return writeCompoundCurlyBracketed(w, c, opts, env)
+			return writeCompoundCurlyBracketed(c, opts)
 		}
 	}
 
 	if opts.ignoreOps {
-		return writeCompoundFunctionalNotation(w, c, opts, env)
+		return writeCompoundFunctionalNotation(c, opts)
 	}
 
 	for _, o := range opts.ops[c.Functor()] {
 		if o.specifier.arity() == c.Arity() {
-			return writeCompoundOp(w, c, opts, env, &o)
+			return writeCompoundOp(c, opts, &o)
 		}
 	}
 
-	return writeCompoundFunctionalNotation(w, c, opts, env)
+	return writeCompoundFunctionalNotation(c, opts)
 }
 
 func writeCompoundVisit(w io.Writer, c Compound, opts *WriteOptions) (bool, error) {
-	if opts.visited == nil {
-		opts.visited = map[termID]struct{}{}
-	}
-
 	if _, ok := opts.visited[id(c)]; ok {
 		_, err := w.Write([]byte("..."))
 		return true, err
@@ -65,121 +118,106 @@ func writeCompoundVisit(w io.Writer, c Compound, opts *WriteOptions) (bool, erro
 	return false, nil
 }
 
-func writeCompoundNumberVars(w io.Writer, n Integer) error {
+func writeCompoundNumberVars(n Integer) string {
 	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	ew := errWriter{w: w}
+	var sb strings.Builder
 	i, j := int(n)%len(letters), int(n)/len(letters)
-	_, _ = fmt.Fprint(&ew, string(letters[i]))
+	sb.WriteByte(letters[i])
 	if j != 0 {
-		_, _ = fmt.Fprint(&ew, strconv.Itoa(j))
+		sb.WriteString(strconv.Itoa(j))
 	}
-	return ew.err
+	return sb.String()
 }
 
-func writeCompoundList(w io.Writer, c Compound, opts *WriteOptions, env *Env) error {
-	ew := errWriter{w: w}
+func writeCompoundList(c Compound, opts *WriteOptions, env *Env) []writeStep {
 	opts = opts.withPriority(999).withLeft(operator{}).withRight(operator{})
-	_, _ = fmt.Fprint(&ew, "[")
-	_ = c.Arg(0).WriteTerm(&ew, opts, env)
+	steps := []writeStep{{s: "["}, {term: c.Arg(0), opts: opts}}
 	iter := ListIterator{List: c.Arg(1), Env: env}
 	for iter.Next() {
-		_, _ = fmt.Fprint(&ew, ",")
-		_ = iter.Current().WriteTerm(&ew, opts, env)
+		steps = append(steps, writeStep{s: ","}, writeStep{term: iter.Current(), opts: opts})
 	}
 	if err := iter.Err(); err != nil {
-		_, _ = fmt.Fprint(&ew, "|")
+		steps = append(steps, writeStep{s: "|"})
 		s := iter.Suffix()
 		if l, ok := iter.Suffix().(Compound); ok && l.Functor() == atomDot && l.Arity() == 2 {
-			_, _ = fmt.Fprint(&ew, "...")
+			steps = append(steps, writeStep{s: "..."})
 		} else {
-			_ = s.WriteTerm(&ew, opts, env)
+			steps = append(steps, writeStep{term: s, opts: opts})
 		}
 	}
-	_, _ = fmt.Fprint(&ew, "]")
-	return ew.err
-}
-
-func writeCompoundCurlyBracketed(w io.Writer, c Compound, opts *WriteOptions, env *Env) error {
-	ew := errWriter{w: w}
-	_, _ = fmt.Fprint(&ew, "{")
-	_ = c.Arg(0).WriteTerm(&ew, opts.withLeft(operator{}), env)
-	_, _ = fmt.Fprint(&ew, "}")
-	return ew.err
+	return append(steps, writeStep{s: "]"})
 }
 
-var writeCompoundOps = [...]func(w io.Writer, c Compound, opts *WriteOptions, env *Env, op *operator) error{
-	operatorSpecifierFX:  nil,
-	operatorSpecifierFY:  nil,
-	operatorSpecifierXF:  nil,
-	operatorSpecifierYF:  nil,
-	operatorSpecifierXFX: nil,
-	operatorSpecifierXFY: nil,
-	operatorSpecifierYFX: nil,
+func writeCompoundCurlyBracketed(c Compound, opts *WriteOptions) []writeStep {
+	return []writeStep{
+		{s: "{"},
+		{term: c.Arg(0), opts: opts.withLeft(operator{})},
+		{s: "}"},
+	}
 }
 
-func init() {
-	writeCompoundOps = [len(writeCompoundOps)]func(w io.Writer, c Compound, opts *WriteOptions, env *Env, op *operator) error{
-		operatorSpecifierFX:  writeCompoundOpPrefix,
-		operatorSpecifierFY:  writeCompoundOpPrefix,
-		operatorSpecifierXF:  writeCompoundOpPostfix,
-		operatorSpecifierYF:  writeCompoundOpPostfix,
-		operatorSpecifierXFX: writeCompoundOpInfix,
-		operatorSpecifierXFY: writeCompoundOpInfix,
-		operatorSpecifierYFX: writeCompoundOpInfix,
-	}
+var writeCompoundOps = [...]func(c Compound, opts *WriteOptions, op *operator) []writeStep{
+	operatorSpecifierFX:  writeCompoundOpPrefix,
+	operatorSpecifierFY:  writeCompoundOpPrefix,
+	operatorSpecifierXF:  writeCompoundOpPostfix,
+	operatorSpecifierYF:  writeCompoundOpPostfix,
+	operatorSpecifierXFX: writeCompoundOpInfix,
+	operatorSpecifierXFY: writeCompoundOpInfix,
+	operatorSpecifierYFX: writeCompoundOpInfix,
 }
 
-func writeCompoundOp(w io.Writer, c Compound, opts *WriteOptions, env *Env, op *operator) error {
-	return writeCompoundOps[op.specifier](w, c, opts, env, op)
+func writeCompoundOp(c Compound, opts *WriteOptions, op *operator) []writeStep {
+	return writeCompoundOps[op.specifier](c, opts, op)
 }
 
-func writeCompoundOpPrefix(w io.Writer, c Compound, opts *WriteOptions, env *Env, op *operator) error {
-	ew := errWriter{w: w}
-	opts = opts.withFreshVisited()
+func writeCompoundOpPrefix(c Compound, opts *WriteOptions, op *operator) []writeStep {
+	var steps []writeStep
 	_, r := op.bindingPriorities()
 	openClose := opts.priority < op.priority || (opts.right != operator{} && r >= opts.right.priority)
 
 	if opts.left != (operator{}) {
-		_, _ = fmt.Fprint(&ew, " ")
+		steps = append(steps, writeStep{s: " "})
 	}
 	if openClose {
-		_, _ = fmt.Fprint(&ew, "(")
+		steps = append(steps, writeStep{s: "("})
 		opts = opts.withLeft(operator{}).withRight(operator{})
 	}
-	_ = c.Functor().WriteTerm(&ew, opts.withLeft(operator{}).withRight(operator{}), env)
-	_ = c.Arg(0).WriteTerm(&ew, opts.withPriority(r).withLeft(*op), env)
+	steps = append(steps,
+		writeStep{term: c.Functor(), opts: opts.withLeft(operator{}).withRight(operator{})},
+		writeStep{term: c.Arg(0), opts: opts.withPriority(r).withLeft(*op)},
+	)
 	if openClose {
-		_, _ = fmt.Fprint(&ew, ")")
+		steps = append(steps, writeStep{s: ")"})
 	}
-	return ew.err
+	return steps
 }
 
-func writeCompoundOpPostfix(w io.Writer, c Compound, opts *WriteOptions, env *Env, op *operator) error {
-	ew := errWriter{w: w}
-	opts = opts.withFreshVisited()
+func writeCompoundOpPostfix(c Compound, opts *WriteOptions, op *operator) []writeStep {
+	var steps []writeStep
 	l, _ := op.bindingPriorities()
 	openClose := opts.priority < op.priority || (opts.left.name == atomMinus && opts.left.specifier.class() == operatorClassPrefix)
 
 	if openClose {
 		if opts.left != (operator{}) {
-			_, _ = fmt.Fprint(&ew, " ")
+			steps = append(steps, writeStep{s: " "})
 		}
-		_, _ = fmt.Fprint(&ew, "(")
+		steps = append(steps, writeStep{s: "("})
 		opts = opts.withLeft(operator{}).withRight(operator{})
 	}
-	_ = c.Arg(0).WriteTerm(&ew, opts.withPriority(l).withRight(*op), env)
-	_ = c.Functor().WriteTerm(&ew, opts.withLeft(operator{}).withRight(operator{}), env)
+	steps = append(steps,
+		writeStep{term: c.Arg(0), opts: opts.withPriority(l).withRight(*op)},
+		writeStep{term: c.Functor(), opts: opts.withLeft(operator{}).withRight(operator{})},
+	)
 	if openClose {
-		_, _ = fmt.Fprint(&ew, ")")
+		steps = append(steps, writeStep{s: ")"})
 	} else if opts.right != (operator{}) {
-		_, _ = fmt.Fprint(&ew, " ")
+		steps = append(steps, writeStep{s: " "})
 	}
-	return ew.err
+	return steps
 }
 
-func writeCompoundOpInfix(w io.Writer, c Compound, opts *WriteOptions, env *Env, op *operator) error {
-	ew := errWriter{w: w}
-	opts = opts.withFreshVisited()
+func writeCompoundOpInfix(c Compound, opts *WriteOptions, op *operator) []writeStep {
+	var steps []writeStep
 	l, r := op.bindingPriorities()
 	openClose := opts.priority < op.priority ||
 		(opts.left.name == atomMinus && opts.left.specifier.class() == operatorClassPrefix) ||
@@ -187,65 +225,83 @@ func writeCompoundOpInfix(w io.Writer, c Compound, opts *WriteOptions, env *Env,
 
 	if openClose {
 		if opts.left.name != 0 && opts.left.specifier.class() == operatorClassPrefix {
-			_, _ = fmt.Fprint(&ew, " ")
+			steps = append(steps, writeStep{s: " "})
 		}
-		_, _ = fmt.Fprint(&ew, "(")
+		steps = append(steps, writeStep{s: "("})
 		opts = opts.withLeft(operator{}).withRight(operator{})
 	}
-	_ = c.Arg(0).WriteTerm(&ew, opts.withPriority(l).withRight(*op), env)
+	steps = append(steps, writeStep{term: c.Arg(0), opts: opts.withPriority(l).withRight(*op)})
 	switch c.Functor() {
 	case atomComma, atomBar:
-		_, _ = fmt.Fprint(&ew, c.Functor().String())
+		steps = append(steps, writeStep{s: c.Functor().String()})
 	default:
-		_ = c.Functor().WriteTerm(&ew, opts.withLeft(operator{}).withRight(operator{}), env)
+		steps = append(steps, writeStep{term: c.Functor(), opts: opts.withLeft(operator{}).withRight(operator{})})
 	}
-	_ = c.Arg(1).WriteTerm(&ew, opts.withPriority(r).withLeft(*op), env)
+	steps = append(steps, writeStep{term: c.Arg(1), opts: opts.withPriority(r).withLeft(*op)})
 	if openClose {
-		_, _ = fmt.Fprint(&ew, ")")
+		steps = append(steps, writeStep{s: ")"})
 	}
-	return ew.err
+	return steps
 }
 
-func writeCompoundFunctionalNotation(w io.Writer, c Compound, opts *WriteOptions, env *Env) error {
-	ew := errWriter{w: w}
+func writeCompoundFunctionalNotation(c Compound, opts *WriteOptions) []writeStep {
 	opts = opts.withRight(operator{})
-	_ = c.Functor().WriteTerm(&ew, opts, env)
-	_, _ = fmt.Fprint(&ew, "(")
+	steps := []writeStep{{term: c.Functor(), opts: opts}, {s: "("}}
 	opts = opts.withLeft(operator{}).withPriority(999)
 	for i := 0; i < c.Arity(); i++ {
 		if i != 0 {
-			_, _ = fmt.Fprint(&ew, ",")
+			steps = append(steps, writeStep{s: ","})
 		}
-		_ = c.Arg(i).WriteTerm(&ew, opts, env)
+		steps = append(steps, writeStep{term: c.Arg(i), opts: opts})
 	}
-	_, _ = fmt.Fprint(&ew, ")")
-	return ew.err
+	return append(steps, writeStep{s: ")"})
 }
 
 // CompareCompound compares the Compound with a Term.
 func CompareCompound(c Compound, t Term, env *Env) int {
-	switch t := env.Resolve(t).(type) {
-	case Compound:
-		switch x, y := c.Arity(), t.Arity(); {
-		case x > y:
+	// The pairs of terms to be compared. We don't recurse so that deep terms don't overflow the call stack.
+	var (
+		stack   = []Term{c, t}
+		visited visitedPairs
+	)
+	for len(stack) > 0 {
+		x, y := env.Resolve(stack[len(stack)-2]), env.Resolve(stack[len(stack)-1])
+		stack = stack[:len(stack)-2]
+
+		p, ok := x.(Compound)
+		if !ok {
+			if o := x.Compare(y, env); o != 0 {
+				return o
+			}
+			continue
+		}
+
+		q, ok := y.(Compound)
+		if !ok {
+			return 1
+		}
+
+		switch m, n := p.Arity(), q.Arity(); {
+		case m > n:
 			return 1
-		case x < y:
+		case m < n:
 			return -1
 		}
 
-		if o := c.Functor().Compare(t.Functor(), env); o != 0 {
+		if o := p.Functor().Compare(q.Functor(), env); o != 0 {
 			return o
 		}
 
-		for i := 0; i < c.Arity(); i++ {
-			if o := c.Arg(i).Compare(t.Arg(i), env); o != 0 {
-				return o
-			}
+		// Rational trees bring us back to the same pair. We consider them equal unless the rest says otherwise.
+		if visited.visit(p, q) {
+			continue
+		}
+
+		for i := p.Arity() - 1; i >= 0; i-- {
+			stack = append(stack, p.Arg(i), q.Arg(i))
 		}
-		return 0
-	default:
-		return 1
 	}
+	return 0
 }
 
 // https://go.dev/blog/errors-are-values
@@ -254,6 +310,14 @@ type errWriter struct {
 	err error
 }
 
+// newErrWriter wraps w unless it's already wrapped so that nested compounds don't stack up writers.
+func newErrWriter(w io.Writer) *errWriter {
+	if ew, ok := w.(*errWriter); ok {
+		return ew
+	}
+	return &errWriter{w: w}
+}
+
 func (ew *errWriter) Write(p []byte) (int, error) {
 	if ew.err != nil {
 		return 0, nil
@@ -263,6 +327,16 @@ func (ew *errWriter) Write(p []byte) (int, error) {
 	return n, nil
 }
 
+// writeTerm writes t and keeps the error if any.
+func (ew *errWriter) writeTerm(t Term, opts *WriteOptions, env *Env) {
+	if ew.err != nil {
+		return
+	}
+	if err := t.WriteTerm(ew, opts, env); err != nil {
+		ew.err = err
+	}
+}
+
 type compound struct {
 	functor Atom
 	args    []Term
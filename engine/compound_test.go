@@ -2,6 +2,7 @@ package engine
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -62,6 +63,45 @@ func TestWriteCompound(t *testing.T) {
 			assert.Equal(t, tt.output, buf.String())
 		})
 	}
+
+	t.Run("deep", func(t *testing.T) {
+		const n = maxTermDepth + 1
+
+		t.Run("functional notation", func(t *testing.T) {
+			var c Term = NewAtom("a")
+			for i := 0; i < n; i++ {
+				c = f.Apply(c)
+			}
+			buf.Reset()
+			assert.NoError(t, WriteCompound(&buf, c.(Compound), &WriteOptions{}, nil))
+			assert.Equal(t, strings.Repeat("f(", n)+"a"+strings.Repeat(")", n), buf.String())
+		})
+
+		t.Run("operators", func(t *testing.T) {
+			var c Term = NewAtom("a")
+			for i := 0; i < n; i++ {
+				c = atomComma.Apply(NewAtom("a"), c)
+			}
+			buf.Reset()
+			assert.NoError(t, WriteCompound(&buf, c.(Compound), &WriteOptions{ops: ops, priority: 1200}, nil))
+			assert.Equal(t, strings.Repeat("a,", n)+"a", buf.String())
+		})
+
+		t.Run("variables", func(t *testing.T) {
+			x := NewVariable()
+			env := NewEnv()
+			var c Term = f.Apply(x)
+			for i := 0; i < n; i++ {
+				y := NewVariable()
+				env = env.bind(x, f.Apply(y))
+				x = y
+			}
+			env = env.bind(x, NewAtom("a"))
+			buf.Reset()
+			assert.NoError(t, WriteCompound(&buf, c.(Compound), &WriteOptions{}, env))
+			assert.Equal(t, strings.Repeat("f(", n+1)+"a"+strings.Repeat(")", n+1), buf.String())
+		})
+	})
 }
 
 func TestCompareCompound(t *testing.T) {
@@ -91,6 +131,28 @@ func TestCompareCompound(t *testing.T) {
 	}
 }
 
+func TestCompareCompound_deep(t *testing.T) {
+	f := NewAtom("f")
+	var x, y Term = NewAtom("a"), NewAtom("b")
+	for i := 0; i < 1_000_000; i++ {
+		x, y = f.Apply(x), f.Apply(y)
+	}
+	assert.Equal(t, -1, CompareCompound(x.(Compound), y, nil))
+	assert.Equal(t, 0, CompareCompound(x.(Compound), x, nil))
+}
+
+func TestCompareCompound_rational(t *testing.T) {
+	f := NewAtom("f")
+	x, y, z := NewVariable(), NewVariable(), NewVariable()
+	env := NewEnv().
+		bind(x, f.Apply(x, NewAtom("a"))).
+		bind(y, f.Apply(y, NewAtom("a"))).
+		bind(z, f.Apply(z, NewAtom("b")))
+	assert.Equal(t, 0, CompareCompound(env.Resolve(x).(Compound), y, env))
+	assert.Equal(t, -1, CompareCompound(env.Resolve(x).(Compound), z, env))
+	assert.Equal(t, 1, CompareCompound(env.Resolve(z).(Compound), x, env))
+}
+
 func TestList(t *testing.T) {
 	tests := []struct {
 		title string
@@ -0,0 +1,446 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var errInvalidEncoding = errors.New("invalid encoding")
+
+// termBinaryVersion is the version of the encoding written by MarshalTerm.
+const termBinaryVersion = 1
+
+// MarshalTerm returns the binary encoding of t.
+// The variables in t are encoded so that UnmarshalTerm restores their sharing with fresh variables.
+func MarshalTerm(t Term, env *Env) ([]byte, error) {
+	var e termEncoder
+	e.byte(termBinaryVersion)
+	if err := e.term(t, env); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// UnmarshalTerm decodes a term encoded by MarshalTerm.
+func UnmarshalTerm(b []byte) (Term, error) {
+	d := termDecoder{buf: b}
+	v, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	if v != termBinaryVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", errInvalidEncoding, v)
+	}
+	t, err := d.term()
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || len(d.buf) > 0 {
+		return nil, errInvalidEncoding
+	}
+	return t, nil
+}
+
+// Tags of the binary encoding of terms.
+const (
+	termTagNil byte = iota
+	termTagAtom
+	termTagAtomRef
+	termTagInteger
+	termTagFloat
+	termTagVariable
+	termTagCompound
+	termTagList
+	termTagPartial
+	termTagCharList
+	termTagCodeList
+	termTagProcedureIndicator
+)
+
+// termEncoder writes terms in a compact binary form.
+// An atom is written as its name for the first time and as a reference for the rest.
+// Variables are numbered in the order of appearance so that the decoder can restore the sharing.
+type termEncoder struct {
+	buf   []byte
+	atoms map[Atom]uint64
+	vars  map[Variable]uint64
+}
+
+func (e *termEncoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *termEncoder) uvarint(n uint64) {
+	e.buf = binary.AppendUvarint(e.buf, n)
+}
+
+func (e *termEncoder) varint(n int64) {
+	e.buf = binary.AppendVarint(e.buf, n)
+}
+
+func (e *termEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *termEncoder) bool(b bool) {
+	if b {
+		e.byte(1)
+		return
+	}
+	e.byte(0)
+}
+
+func (e *termEncoder) atom(a Atom) {
+	if e.atoms == nil {
+		e.atoms = map[Atom]uint64{}
+	}
+	if i, ok := e.atoms[a]; ok {
+		e.byte(termTagAtomRef)
+		e.uvarint(i)
+		return
+	}
+	e.atoms[a] = uint64(len(e.atoms))
+	e.byte(termTagAtom)
+	e.string(a.String())
+}
+
+// resetVariables starts a new scope of variables.
+func (e *termEncoder) resetVariables() {
+	e.vars = nil
+}
+
+func (e *termEncoder) term(t Term, env *Env) error {
+	return e.termDepth(t, env, 0)
+}
+
+func (e *termEncoder) termDepth(t Term, env *Env, depth int) error {
+	if depth > maxTermDepth {
+		return errMaxTermDepth
+	}
+	depth++
+
+	switch t := env.Resolve(t).(type) {
+	case nil:
+		return errInvalidEncoding
+	case Atom:
+		e.atom(t)
+	case Integer:
+		e.byte(termTagInteger)
+		e.varint(int64(t))
+	case Float:
+		e.byte(termTagFloat)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(float64(t)))
+	case Variable:
+		if e.vars == nil {
+			e.vars = map[Variable]uint64{}
+		}
+		i, ok := e.vars[t]
+		if !ok {
+			i = uint64(len(e.vars))
+			e.vars[t] = i
+		}
+		e.byte(termTagVariable)
+		e.uvarint(i)
+	case charList:
+		e.byte(termTagCharList)
+		e.string(string(t))
+	case codeList:
+		e.byte(termTagCodeList)
+		e.string(string(t))
+	case list:
+		e.byte(termTagList)
+		e.uvarint(uint64(len(t)))
+		for _, a := range t {
+			if err := e.termDepth(a, env, depth); err != nil {
+				return err
+			}
+		}
+	case *partial:
+		prefix, ok := t.Compound.(list)
+		if !ok {
+			return e.compound(t, env, depth)
+		}
+		e.byte(termTagPartial)
+		e.uvarint(uint64(len(prefix)))
+		for _, a := range prefix {
+			if err := e.termDepth(a, env, depth); err != nil {
+				return err
+			}
+		}
+		return e.termDepth(*t.tail, env, depth)
+	case Compound:
+		return e.compound(t, env, depth)
+	default:
+		return typeError(validTypeSerializable, t, env)
+	}
+	return nil
+}
+
+// operand writes an operand of an instruction in an image which is either nil, a procedure indicator, or a term.
+func (e *termEncoder) operand(t Term) error {
+	switch t := t.(type) {
+	case nil:
+		e.byte(termTagNil)
+	case procedureIndicator:
+		e.byte(termTagProcedureIndicator)
+		e.atom(t.name)
+		e.varint(int64(t.arity))
+	default:
+		return e.term(t, nil)
+	}
+	return nil
+}
+
+func (e *termEncoder) compound(c Compound, env *Env, depth int) error {
+	e.byte(termTagCompound)
+	e.atom(c.Functor())
+	e.uvarint(uint64(c.Arity()))
+	for i := 0; i < c.Arity(); i++ {
+		if err := e.termDepth(c.Arg(i), env, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// termDecoder reads terms written by termEncoder.
+type termDecoder struct {
+	buf   []byte
+	atoms []Atom
+	vars  []Variable
+}
+
+func (d *termDecoder) byte() (byte, error) {
+	if len(d.buf) == 0 {
+		return 0, errInvalidEncoding
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b, nil
+}
+
+func (d *termDecoder) uvarint() (uint64, error) {
+	n, l := binary.Uvarint(d.buf)
+	if l <= 0 {
+		return 0, errInvalidEncoding
+	}
+	d.buf = d.buf[l:]
+	return n, nil
+}
+
+func (d *termDecoder) varint() (int64, error) {
+	n, l := binary.Varint(d.buf)
+	if l <= 0 {
+		return 0, errInvalidEncoding
+	}
+	d.buf = d.buf[l:]
+	return n, nil
+}
+
+// length reads a number of elements which is at most the remaining bytes so that a broken input can't exhaust memory.
+func (d *termDecoder) length() (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.buf)) {
+		return 0, errInvalidEncoding
+	}
+	return int(n), nil
+}
+
+func (d *termDecoder) string() (string, error) {
+	n, err := d.length()
+	if err != nil {
+		return "", err
+	}
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s, nil
+}
+
+func (d *termDecoder) bool() (bool, error) {
+	b, err := d.byte()
+	return b != 0, err
+}
+
+// atom reads an atom. Unlike term, it accepts nothing but an atom or a reference to an atom.
+func (d *termDecoder) atom() (Atom, error) {
+	tag, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+	return d.atomTag(tag)
+}
+
+func (d *termDecoder) atomTag(tag byte) (Atom, error) {
+	switch tag {
+	case termTagAtom:
+		s, err := d.string()
+		if err != nil {
+			return 0, err
+		}
+		a := NewAtom(s)
+		d.atoms = append(d.atoms, a)
+		return a, nil
+	case termTagAtomRef:
+		i, err := d.uvarint()
+		if err != nil {
+			return 0, err
+		}
+		if i >= uint64(len(d.atoms)) {
+			return 0, errInvalidEncoding
+		}
+		return d.atoms[i], nil
+	default:
+		return 0, errInvalidEncoding
+	}
+}
+
+// resetVariables starts a new scope of variables.
+func (d *termDecoder) resetVariables() {
+	d.vars = nil
+}
+
+func (d *termDecoder) term() (Term, error) {
+	return d.termDepth(0)
+}
+
+func (d *termDecoder) termDepth(depth int) (Term, error) {
+	if depth > maxTermDepth {
+		return nil, errMaxTermDepth
+	}
+	depth++
+
+	tag, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case termTagAtom, termTagAtomRef:
+		a, err := d.atomTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
+	case termTagInteger:
+		n, err := d.varint()
+		return Integer(n), err
+	case termTagFloat:
+		if len(d.buf) < 8 {
+			return nil, errInvalidEncoding
+		}
+		f := math.Float64frombits(binary.BigEndian.Uint64(d.buf))
+		d.buf = d.buf[8:]
+		return Float(f), nil
+	case termTagVariable:
+		i, err := d.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case i < uint64(len(d.vars)):
+			return d.vars[i], nil
+		case i == uint64(len(d.vars)):
+			v := NewVariable()
+			d.vars = append(d.vars, v)
+			return v, nil
+		default:
+			return nil, errInvalidEncoding
+		}
+	case termTagCharList:
+		s, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		if s == "" {
+			return nil, errInvalidEncoding
+		}
+		return charList(s), nil
+	case termTagCodeList:
+		s, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		if s == "" {
+			return nil, errInvalidEncoding
+		}
+		return codeList(s), nil
+	case termTagList:
+		elems, err := d.terms(depth)
+		if err != nil {
+			return nil, err
+		}
+		return list(elems), nil
+	case termTagPartial:
+		elems, err := d.terms(depth)
+		if err != nil {
+			return nil, err
+		}
+		tail, err := d.termDepth(depth)
+		if err != nil {
+			return nil, err
+		}
+		return PartialList(tail, elems...), nil
+	case termTagCompound:
+		functor, err := d.atom()
+		if err != nil {
+			return nil, err
+		}
+		args, err := d.terms(depth)
+		if err != nil {
+			return nil, err
+		}
+		return &compound{functor: functor, args: args}, nil
+	default:
+		return nil, errInvalidEncoding
+	}
+}
+
+// operand reads an operand of an instruction in an image which is either nil, a procedure indicator, or a term.
+// Neither nil nor a procedure indicator is allowed in the other places.
+func (d *termDecoder) operand() (Term, error) {
+	if len(d.buf) == 0 {
+		return nil, errInvalidEncoding
+	}
+	switch d.buf[0] {
+	case termTagNil:
+		d.buf = d.buf[1:]
+		return nil, nil
+	case termTagProcedureIndicator:
+		d.buf = d.buf[1:]
+		name, err := d.atom()
+		if err != nil {
+			return nil, err
+		}
+		arity, err := d.varint()
+		if err != nil {
+			return nil, err
+		}
+		return procedureIndicator{name: name, arity: Integer(arity)}, nil
+	default:
+		return d.term()
+	}
+}
+
+// terms reads the arguments of a compound or the elements of a list. There must be at least one.
+func (d *termDecoder) terms(depth int) ([]Term, error) {
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errInvalidEncoding
+	}
+	ts := make([]Term, n)
+	for i := range ts {
+		ts[i], err = d.termDepth(depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ts, nil
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalTerm(t *testing.T) {
+	tests := []struct {
+		title string
+		term  Term
+		want  Term
+	}{
+		{title: "atom", term: NewAtom("foo")},
+		{title: "empty atom", term: NewAtom("")},
+		{title: "integer", term: Integer(-123456789)},
+		{title: "max integer", term: Integer(math.MaxInt64)},
+		{title: "min integer", term: Integer(math.MinInt64)},
+		{title: "float", term: Float(1.5)},
+		{title: "negative zero", term: Float(math.Copysign(0, -1))},
+		{title: "compound", term: NewAtom("f").Apply(NewAtom("foo"), NewAtom("bar").Apply(NewAtom("foo")), Float(-2.5))},
+		{title: "list", term: List(NewAtom("a"), Integer(1), List(NewAtom("a")))},
+		{title: "charList", term: CharList("héllo")},
+		{title: "codeList", term: CodeList("héllo")},
+		{title: "procedure indicator", term: procedureIndicator{name: NewAtom("foo"), arity: 2}, want: atomSlash.Apply(NewAtom("foo"), Integer(2))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			b, err := MarshalTerm(tt.term, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, byte(termBinaryVersion), b[0])
+
+			u, err := UnmarshalTerm(b)
+			assert.NoError(t, err)
+			if tt.want == nil {
+				tt.want = tt.term
+			}
+			assert.Equal(t, tt.want, u)
+		})
+	}
+
+	t.Run("variables", func(t *testing.T) {
+		x, y, z := NewVariable(), NewVariable(), NewVariable()
+		env := NewEnv().bind(z, NewAtom("a"))
+		b, err := MarshalTerm(NewAtom("f").Apply(x, y, PartialList(x, y, z)), env)
+		assert.NoError(t, err)
+
+		u, err := UnmarshalTerm(b)
+		assert.NoError(t, err)
+		c := u.(Compound)
+		assert.IsType(t, Variable(0), c.Arg(0))
+		assert.IsType(t, Variable(0), c.Arg(1))
+		assert.NotEqual(t, x, c.Arg(0))
+		assert.NotEqual(t, c.Arg(0), c.Arg(1))
+		assert.Equal(t, PartialList(c.Arg(0), c.Arg(1), NewAtom("a")), c.Arg(2))
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		s := &Stream{}
+		_, err := MarshalTerm(NewAtom("f").Apply(s), nil)
+		assert.Equal(t, typeError(validTypeSerializable, s, nil), err)
+	})
+}
+
+func TestUnmarshalTerm(t *testing.T) {
+	tests := []struct {
+		title string
+		b     []byte
+		err   error
+	}{
+		{title: "empty", b: nil, err: errInvalidEncoding},
+		{title: "unsupported version", b: []byte{2, termTagInteger, 0}, err: fmt.Errorf("%w: unsupported version 2", errInvalidEncoding)},
+		{title: "no term", b: []byte{termBinaryVersion}, err: errInvalidEncoding},
+		{title: "nil", b: []byte{termBinaryVersion, termTagNil}, err: errInvalidEncoding},
+		{title: "unknown tag", b: []byte{termBinaryVersion, 0xff}, err: errInvalidEncoding},
+		{title: "trailing bytes", b: []byte{termBinaryVersion, termTagInteger, 0, 0}, err: errInvalidEncoding},
+		{title: "truncated", b: []byte{termBinaryVersion, termTagAtom, 3, 'f'}, err: errInvalidEncoding},
+		{title: "dangling atom reference", b: []byte{termBinaryVersion, termTagAtomRef, 0}, err: errInvalidEncoding},
+		{title: "dangling variable", b: []byte{termBinaryVersion, termTagVariable, 1}, err: errInvalidEncoding},
+		{title: "huge length", b: []byte{termBinaryVersion, termTagList, 0xff, 0xff, 0xff, 0xff, 0x0f}, err: errInvalidEncoding},
+		{title: "compound of arity 0", b: []byte{termBinaryVersion, termTagCompound, termTagAtom, 1, 'f', 0}, err: errInvalidEncoding},
+		{title: "nil argument", b: []byte{termBinaryVersion, termTagCompound, termTagAtom, 1, 'f', 1, termTagNil}, err: errInvalidEncoding},
+		{title: "empty list", b: []byte{termBinaryVersion, termTagList, 0}, err: errInvalidEncoding},
+		{title: "empty partial list", b: []byte{termBinaryVersion, termTagPartial, 0, termTagInteger, 0}, err: errInvalidEncoding},
+		{title: "empty char list", b: []byte{termBinaryVersion, termTagCharList, 0}, err: errInvalidEncoding},
+		{title: "empty code list", b: []byte{termBinaryVersion, termTagCodeList, 0}, err: errInvalidEncoding},
+		{title: "procedure indicator", b: []byte{termBinaryVersion, termTagProcedureIndicator, termTagAtom, 1, 'f', 2}, err: errInvalidEncoding},
+		{title: "non-atom functor", b: []byte{termBinaryVersion, termTagCompound, termTagInteger, 0, 1, termTagInteger, 0}, err: errInvalidEncoding},
+		{title: "nested functors", b: append([]byte{termBinaryVersion}, bytes.Repeat([]byte{termTagCompound}, 8_000_000)...), err: errInvalidEncoding},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			_, err := UnmarshalTerm(tt.b)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
@@ -1,7 +1,16 @@
 package engine
 
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
 var varContext = NewVariable()
 
+// varGoContext is a special variable bound to the context.Context of the execution.
+var varGoContext = NewVariable()
+
 var rootContext = NewAtom("root")
 
 type envKey int64
@@ -49,6 +58,39 @@ func NewEnv() *Env {
 	return nil
 }
 
+// WithContext returns an environment which carries ctx so that predicates can honour its cancellation and deadline
+// and read request-scoped values from it.
+func (e *Env) WithContext(ctx context.Context) *Env {
+	return e.bind(varGoContext, goContext{ctx})
+}
+
+// Context returns the context.Context carried by the environment.
+// If there's none, it returns context.Background().
+func (e *Env) Context() context.Context {
+	if c, ok := e.lookup(varGoContext); ok {
+		return c.(goContext).Context
+	}
+	return context.Background()
+}
+
+// goContext is a context.Context disguised as a Term so that Env can carry it.
+type goContext struct {
+	context.Context
+}
+
+// WriteTerm outputs the context to an io.Writer.
+func (c goContext) WriteTerm(w io.Writer, _ *WriteOptions, _ *Env) error {
+	_, err := fmt.Fprintf(w, "<context>(%v)", c.Context)
+	return err
+}
+
+// Compare compares the context with a Term.
+func (c goContext) Compare(t Term, env *Env) int {
+	return CompareAtomic[goContext](c, t, func(goContext, goContext) int {
+		return 0
+	}, env)
+}
+
 // lookup returns a term that the given variable is bound to.
 func (e *Env) lookup(v Variable) (Term, bool) {
 	k := newEnvKey(v)
@@ -199,39 +241,74 @@ func simplify(t Term, simplified map[termID]Compound, env *Env) Term {
 	if simplified == nil {
 		simplified = map[termID]Compound{}
 	}
-	t = env.Resolve(t)
-	if c, ok := simplified[id(t)]; ok {
-		return c
-	}
-	switch t := t.(type) {
-	case charList, codeList:
-		return t
-	case list:
-		l := make(list, len(t))
-		simplified[id(t)] = l
-		for i, e := range t {
-			l[i] = simplify(e, simplified, env)
+
+	// We keep the work in an explicit stack instead of the call stack so that deep terms don't overflow it.
+	var (
+		ret   Term
+		stack = []rebuildTask{{src: t, dst: &ret}}
+	)
+	for len(stack) > 0 {
+		var task rebuildTask
+		task, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		if task.fix != nil {
+			task.fix()
+			continue
 		}
-		return l
-	case *partial:
-		var p partial
-		simplified[id(t)] = &p
-		p.Compound = simplify(t.Compound, simplified, env).(Compound)
-		tail := simplify(*t.tail, simplified, env)
-		p.tail = &tail
-		return &p
-	case Compound:
-		c := compound{
-			functor: t.Functor(),
-			args:    make([]Term, t.Arity()),
+
+		t := env.Resolve(task.src)
+		if c, ok := simplified[id(t)]; ok {
+			*task.dst = c
+			continue
 		}
-		simplified[id(t)] = &c
-		for i := 0; i < t.Arity(); i++ {
-			c.args[i] = simplify(t.Arg(i), simplified, env)
+		switch t := t.(type) {
+		case charList, codeList:
+			*task.dst = t
+		case list:
+			l := make(list, len(t))
+			simplified[id(t)] = l
+			*task.dst = l
+			for i := len(t) - 1; i >= 0; i-- {
+				stack = append(stack, rebuildTask{src: t[i], dst: &l[i]})
+			}
+		case *partial:
+			p := partial{tail: new(Term)}
+			simplified[id(t)] = &p
+			*task.dst = &p
+			stack = append(stack, rebuildPartial(&p, t)...)
+		case Compound:
+			c := compound{
+				functor: t.Functor(),
+				args:    make([]Term, t.Arity()),
+			}
+			simplified[id(t)] = &c
+			*task.dst = &c
+			for i := t.Arity() - 1; i >= 0; i-- {
+				stack = append(stack, rebuildTask{src: t.Arg(i), dst: &c.args[i]})
+			}
+		default:
+			*task.dst = t
 		}
-		return &c
-	default:
-		return t
+	}
+	return ret
+}
+
+// rebuildTask is a unit of work to build a new term from an existing term.
+// It either copies src into dst or, if fix is not nil, finishes a term whose subterms are already built.
+type rebuildTask struct {
+	src Term
+	dst *Term
+	fix func()
+}
+
+// rebuildPartial returns tasks to build p from the prefix and the tail of src.
+func rebuildPartial(p *partial, src *partial) []rebuildTask {
+	var prefix Term
+	return []rebuildTask{
+		{fix: func() {
+			p.Compound = prefix.(Compound)
+		}},
+		{src: *src.tail, dst: p.tail},
+		{src: src.Compound, dst: &prefix},
 	}
 }
 
@@ -239,21 +316,29 @@ type variables []Variable
 
 // freeVariables extracts variables in the given Term.
 func (e *Env) freeVariables(t Term) []Variable {
-	return e.appendFreeVariables(nil, t)
-}
-
-func (e *Env) appendFreeVariables(fvs variables, t Term) variables {
-	switch t := e.Resolve(t).(type) {
-	case Variable:
-		for _, v := range fvs {
-			if v == t {
-				return fvs
+	var (
+		fvs     variables
+		seen    = map[Variable]struct{}{}
+		stack   = []Term{t}
+		visited visitedTerms
+	)
+	for len(stack) > 0 {
+		var t Term
+		t, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		switch t := e.Resolve(t).(type) {
+		case Variable:
+			if _, ok := seen[t]; ok {
+				continue
+			}
+			seen[t] = struct{}{}
+			fvs = append(fvs, t)
+		case Compound:
+			if visited.visit(t) {
+				continue
+			}
+			for i := t.Arity() - 1; i >= 0; i-- {
+				stack = append(stack, t.Arg(i))
 			}
-		}
-		return append(fvs, t)
-	case Compound:
-		for i := 0; i < t.Arity(); i++ {
-			fvs = e.appendFreeVariables(fvs, t.Arg(i))
 		}
 	}
 	return fvs
@@ -261,79 +346,103 @@ func (e *Env) appendFreeVariables(fvs variables, t Term) variables {
 
 // Unify unifies 2 terms.
 func (e *Env) Unify(x, y Term) (*Env, bool) {
-	return e.unify(x, y, false)
+	env, ok, _ := e.unify(x, y, occursCheckFalse)
+	return env, ok
 }
 
 func (e *Env) unifyWithOccursCheck(x, y Term) (*Env, bool) {
-	return e.unify(x, y, true)
+	env, ok, _ := e.unify(x, y, occursCheckTrue)
+	return env, ok
 }
 
-func (e *Env) unify(x, y Term, occursCheck bool) (*Env, bool) {
-	x, y = e.Resolve(x), e.Resolve(y)
-	switch x := x.(type) {
-	case Variable:
-		switch {
-		case x == y:
-			return e, true
-		case occursCheck && contains(y, x, e):
-			return e, false
-		default:
-			return e.bind(x, y), true
+// unify unifies 2 terms. If check is occursCheckError, it returns an error instead of failing on occurs check.
+func (e *Env) unify(x, y Term, check occursCheck) (*Env, bool, error) {
+	// The pairs of terms to be unified. We don't recurse so that deep terms don't overflow the call stack.
+	var (
+		stack   = []Term{x, y}
+		visited visitedPairs
+	)
+	for len(stack) > 0 {
+		x, y := e.Resolve(stack[len(stack)-2]), e.Resolve(stack[len(stack)-1])
+		stack = stack[:len(stack)-2]
+		if v, ok := y.(Variable); ok {
+			if _, ok := x.(Variable); !ok {
+				x, y = v, x
+			}
 		}
-	case Compound:
-		switch y := y.(type) {
+		switch x := x.(type) {
 		case Variable:
-			return e.unify(y, x, occursCheck)
+			switch {
+			case x == y:
+				continue
+			case check != occursCheckFalse && contains(y, x, e):
+				if check == occursCheckError {
+					return e, false, occursCheckException(x, y, e)
+				}
+				return e, false, nil
+			default:
+				e = e.bind(x, y)
+			}
 		case Compound:
+			y, ok := y.(Compound)
+			if !ok {
+				return e, false, nil
+			}
 			if x.Functor() != y.Functor() {
-				return e, false
+				return e, false, nil
 			}
 			if x.Arity() != y.Arity() {
-				return e, false
+				return e, false, nil
 			}
-			var ok bool
-			for i := 0; i < x.Arity(); i++ {
-				e, ok = e.unify(x.Arg(i), y.Arg(i), occursCheck)
-				if !ok {
-					return e, false
-				}
+			// Rational trees bring us back to the same pair. It's already being unified.
+			if visited.visit(x, y) {
+				continue
+			}
+			for i := x.Arity() - 1; i >= 0; i-- {
+				stack = append(stack, x.Arg(i), y.Arg(i))
+			}
+		default: // atomic
+			if x != y {
+				return e, false, nil
 			}
-			return e, true
-		default:
-			return e, false
-		}
-	default: // atomic
-		switch y := y.(type) {
-		case Variable:
-			return e.unify(y, x, occursCheck)
-		default:
-			return e, x == y
 		}
 	}
+	return e, true, nil
 }
 
 func contains(t, s Term, env *Env) bool {
-	switch t := t.(type) {
-	case Variable:
-		if t == s {
-			return true
-		}
-		ref, ok := env.lookup(t)
-		if !ok {
-			return false
-		}
-		return contains(ref, s, env)
-	case Compound:
-		if s, ok := s.(Atom); ok && t.Functor() == s {
-			return true
-		}
-		for i := 0; i < t.Arity(); i++ {
-			if contains(t.Arg(i), s, env) {
+	var (
+		stack   = []Term{t}
+		visited visitedTerms
+	)
+	for len(stack) > 0 {
+		var t Term
+		t, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		switch t := t.(type) {
+		case Variable:
+			if t == s {
+				return true
+			}
+			ref, ok := env.lookup(t)
+			if !ok {
+				continue
+			}
+			stack = append(stack, ref)
+		case Compound:
+			if s, ok := s.(Atom); ok && t.Functor() == s {
+				return true
+			}
+			if visited.visit(t) {
+				continue
+			}
+			for i := t.Arity() - 1; i >= 0; i-- {
+				stack = append(stack, t.Arg(i))
+			}
+		default:
+			if t == s {
 				return true
 			}
 		}
-		return false
-	default:
-		return t == s
 	}
+	return false
 }
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"testing"
@@ -55,6 +56,19 @@ func TestEnv_Lookup(t *testing.T) {
 	}
 }
 
+func TestEnv_Context(t *testing.T) {
+	t.Run("none", func(t *testing.T) {
+		assert.Equal(t, context.Background(), NewEnv().Context())
+	})
+
+	t.Run("with context", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), testContextKey{}, "alice")
+		env := NewEnv().WithContext(ctx).bind(NewVariable(), NewAtom("a"))
+		assert.Equal(t, ctx, env.Context())
+		assert.Equal(t, rootContext, env.Resolve(varContext))
+	})
+}
+
 func TestEnv_Simplify(t *testing.T) {
 	// L = [a, b|L] ==> [a, b, a, b, ...]
 	l := NewVariable()
@@ -73,6 +87,39 @@ func TestEnv_Simplify(t *testing.T) {
 	assert.Equal(t, 2, suffix.Arity())
 }
 
+func TestEnv_Unify(t *testing.T) {
+	t.Run("deep", func(t *testing.T) {
+		f := NewAtom("f")
+		x, y := NewVariable(), NewVariable()
+		var a, b Term = x, NewAtom("a")
+		for i := 0; i < 1_000_000; i++ {
+			a, b = f.Apply(a), f.Apply(b)
+		}
+
+		env, ok := NewEnv().Unify(a, b)
+		assert.True(t, ok)
+		assert.Equal(t, NewAtom("a"), env.Resolve(x))
+
+		_, ok = env.Unify(f.Apply(y, a), f.Apply(y, f.Apply(a)))
+		assert.False(t, ok)
+	})
+
+	t.Run("rational trees", func(t *testing.T) {
+		f := NewAtom("f")
+		x, y := NewVariable(), NewVariable()
+		env := NewEnv().
+			bind(x, f.Apply(x, NewAtom("a"))).
+			bind(y, f.Apply(y, NewAtom("a")))
+
+		_, ok := env.Unify(x, y)
+		assert.True(t, ok)
+
+		env = env.bind(y, f.Apply(y, NewAtom("b")))
+		_, ok = env.Unify(x, y)
+		assert.False(t, ok)
+	})
+}
+
 func TestContains(t *testing.T) {
 	var env *Env
 	assert.True(t, contains(NewAtom("a"), NewAtom("a"), env))
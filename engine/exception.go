@@ -2,6 +2,7 @@ package engine
 
 import (
 	"bytes"
+	"errors"
 )
 
 // Exception is an error represented by a prolog term.
@@ -29,7 +30,266 @@ func (e Exception) Error() string {
 	return buf.String()
 }
 
+// As finds the first typed error, e.g. *TypeErr, that matches target and, if one is found, sets target to that
+// error value and returns true. It's for errors.As so that Go callers don't have to match the term of the Exception.
+func (e Exception) As(target interface{}) bool {
+	c, ok := e.term.(Compound)
+	if !ok || c.Functor() != atomError || c.Arity() != 2 {
+		return false
+	}
+	formal, ctx := c.Arg(0), c.Arg(1)
+	switch target := target.(type) {
+	case **InstantiationErr:
+		if formal != atomInstantiationError {
+			return false
+		}
+		*target = &InstantiationErr{Context: ctx}
+	case **TypeErr:
+		args, ok := formalArgs(formal, atomTypeError, 2)
+		if !ok {
+			return false
+		}
+		*target = &TypeErr{Type: args[0], Culprit: args[1], Context: ctx}
+	case **DomainErr:
+		args, ok := formalArgs(formal, atomDomainError, 2)
+		if !ok {
+			return false
+		}
+		*target = &DomainErr{Domain: args[0], Culprit: args[1], Context: ctx}
+	case **ExistenceErr:
+		args, ok := formalArgs(formal, atomExistenceError, 2)
+		if !ok {
+			return false
+		}
+		*target = &ExistenceErr{ObjectType: args[0], Culprit: args[1], Context: ctx}
+	case **PermissionErr:
+		args, ok := formalArgs(formal, atomPermissionError, 3)
+		if !ok {
+			return false
+		}
+		*target = &PermissionErr{Operation: args[0], PermissionType: args[1], Culprit: args[2], Context: ctx}
+	case **RepresentationErr:
+		args, ok := formalArgs(formal, atomRepresentationError, 1)
+		if !ok {
+			return false
+		}
+		*target = &RepresentationErr{Flag: args[0], Context: ctx}
+	case **EvaluationErr:
+		args, ok := formalArgs(formal, atomEvaluationError, 1)
+		if !ok {
+			return false
+		}
+		*target = &EvaluationErr{Value: args[0], Context: ctx}
+	case **ResourceErr:
+		args, ok := formalArgs(formal, atomResourceError, 1)
+		if !ok {
+			return false
+		}
+		*target = &ResourceErr{Resource: args[0], Context: ctx}
+	case **SyntaxErr:
+		args, ok := formalArgs(formal, atomSyntaxError, 1)
+		if !ok {
+			return false
+		}
+		var buf bytes.Buffer
+		_ = args[0].WriteTerm(&buf, defaultWriteOptions.withQuoted(false), nil)
+		se := SyntaxErr{Err: errors.New(buf.String())}
+		if p, ok := ctx.(Compound); ok && p.Functor() == atomPosition && p.Arity() == 3 {
+			line, _ := p.Arg(0).(Integer)
+			column, _ := p.Arg(1).(Integer)
+			offset, _ := p.Arg(2).(Integer)
+			se.Position = Position{Offset: int(offset), Line: int(line), Column: int(column)}
+		}
+		*target = &se
+	default:
+		return false
+	}
+	return true
+}
+
+// formalArgs returns the arguments of the formal part of an error term if its principal functor is name/arity.
+func formalArgs(formal Term, name Atom, arity int) ([]Term, bool) {
+	c, ok := formal.(Compound)
+	if !ok || c.Functor() != name || c.Arity() != arity {
+		return nil, false
+	}
+	args := make([]Term, arity)
+	for i := range args {
+		args[i] = c.Arg(i)
+	}
+	return args, true
+}
+
+// termError is an error which is represented by a term error(Formal, Context), e.g. *TypeErr.
+type termError interface {
+	error
+	Term() Term
+}
+
+// exceptionOf returns an Exception for err if err is or wraps a typed error, e.g. *TypeErr, so that Prolog can
+// catch it as error(Formal, Context). A missing context is filled with the one in env.
+// If err is an Exception, it returns err as is. Otherwise, ok is false.
+func exceptionOf(err error, env *Env) (_ Exception, ok bool) {
+	if e, ok := err.(Exception); ok {
+		return e, true
+	}
+	var te termError
+	if errors.As(err, &te) {
+		return NewException(te.Term(), env), true
+	}
+	return Exception{}, false
+}
+
+// errorTerm returns error(formal, context). If context is nil, it's the context in which the error is raised.
+func errorTerm(formal, context Term) Term {
+	if context == nil {
+		context = varContext
+	}
+	return atomError.Apply(formal, context)
+}
+
+// errorString returns the representation of the error term for Error().
+func errorString(e termError) string {
+	return Exception{term: e.Term()}.Error()
+}
+
+// InstantiationErr is an error raised when an argument or one of its components is a variable.
+// It's error(instantiation_error, Context) in Prolog.
+type InstantiationErr struct {
+	Context Term
+}
+
+func (e *InstantiationErr) Error() string {
+	return errorString(e)
+}
+
+// Term returns error(instantiation_error, Context).
+func (e *InstantiationErr) Term() Term {
+	return errorTerm(atomInstantiationError, e.Context)
+}
+
+// TypeErr is an error raised when an argument or one of its components is not of the expected type, e.g. integer.
+// It's error(type_error(Type, Culprit), Context) in Prolog.
+type TypeErr struct {
+	Type    Term
+	Culprit Term
+	Context Term
+}
+
+func (e *TypeErr) Error() string {
+	return errorString(e)
+}
+
+// Term returns error(type_error(Type, Culprit), Context).
+func (e *TypeErr) Term() Term {
+	return errorTerm(atomTypeError.Apply(e.Type, e.Culprit), e.Context)
+}
+
+// DomainErr is an error raised when an argument is of the correct type but its value is not in the domain,
+// e.g. not_less_than_zero.
+// It's error(domain_error(Domain, Culprit), Context) in Prolog.
+type DomainErr struct {
+	Domain  Term
+	Culprit Term
+	Context Term
+}
+
+func (e *DomainErr) Error() string {
+	return errorString(e)
+}
+
+// Term returns error(domain_error(Domain, Culprit), Context).
+func (e *DomainErr) Term() Term {
+	return errorTerm(atomDomainError.Apply(e.Domain, e.Culprit), e.Context)
+}
+
+// ExistenceErr is an error raised when the object, e.g. procedure, on which an operation is to be performed doesn't
+// exist.
+// It's error(existence_error(ObjectType, Culprit), Context) in Prolog.
+type ExistenceErr struct {
+	ObjectType Term
+	Culprit    Term
+	Context    Term
+}
+
+func (e *ExistenceErr) Error() string {
+	return errorString(e)
+}
+
+// Term returns error(existence_error(ObjectType, Culprit), Context).
+func (e *ExistenceErr) Term() Term {
+	return errorTerm(atomExistenceError.Apply(e.ObjectType, e.Culprit), e.Context)
+}
+
+// PermissionErr is an error raised when the operation, e.g. modify, is not permitted to perform on the type of
+// the culprit, e.g. static_procedure.
+// It's error(permission_error(Operation, PermissionType, Culprit), Context) in Prolog.
+type PermissionErr struct {
+	Operation      Term
+	PermissionType Term
+	Culprit        Term
+	Context        Term
+}
+
+func (e *PermissionErr) Error() string {
+	return errorString(e)
+}
+
+// Term returns error(permission_error(Operation, PermissionType, Culprit), Context).
+func (e *PermissionErr) Term() Term {
+	return errorTerm(atomPermissionError.Apply(e.Operation, e.PermissionType, e.Culprit), e.Context)
+}
+
+// RepresentationErr is an error raised when an implementation defined limit, e.g. max_arity, is breached.
+// It's error(representation_error(Flag), Context) in Prolog.
+type RepresentationErr struct {
+	Flag    Term
+	Context Term
+}
+
+func (e *RepresentationErr) Error() string {
+	return errorString(e)
+}
+
+// Term returns error(representation_error(Flag), Context).
+func (e *RepresentationErr) Term() Term {
+	return errorTerm(atomRepresentationError.Apply(e.Flag), e.Context)
+}
+
+// EvaluationErr is an error raised when the value of an arithmetic expression is exceptional, e.g. zero_divisor.
+// It's error(evaluation_error(Value), Context) in Prolog.
+type EvaluationErr struct {
+	Value   Term
+	Context Term
+}
+
+func (e *EvaluationErr) Error() string {
+	return errorString(e)
+}
+
+// Term returns error(evaluation_error(Value), Context).
+func (e *EvaluationErr) Term() Term {
+	return errorTerm(atomEvaluationError.Apply(e.Value), e.Context)
+}
+
+// ResourceErr is an error raised when there are not enough resources, e.g. memory, to complete execution.
+// It's error(resource_error(Resource), Context) in Prolog.
+type ResourceErr struct {
+	Resource Term
+	Context  Term
+}
+
+func (e *ResourceErr) Error() string {
+	return errorString(e)
+}
+
+// Term returns error(resource_error(Resource), Context).
+func (e *ResourceErr) Term() Term {
+	return errorTerm(atomResourceError.Apply(e.Resource), e.Context)
+}
+
 // InstantiationError returns an instantiation error exception.
+// Go callers can extract it as *InstantiationErr with errors.As.
 func InstantiationError(env *Env) Exception {
 	return NewException(atomError.Apply(atomInstantiationError, varContext), env)
 }
@@ -53,6 +313,7 @@ const (
 	validTypePredicateIndicator
 	validTypePair
 	validTypeFloat
+	validTypeSerializable
 )
 
 var validTypeAtoms = [...]Atom{
@@ -71,6 +332,7 @@ var validTypeAtoms = [...]Atom{
 	validTypePredicateIndicator: atomPredicateIndicator,
 	validTypePair:               atomPair,
 	validTypeFloat:              atomFloat,
+	validTypeSerializable:       atomSerializable,
 }
 
 // Term returns an Atom for the validType.
@@ -79,6 +341,7 @@ func (t validType) Term() Term {
 }
 
 // TypeError creates a new type error exception.
+// Go callers can extract it as *TypeErr with errors.As.
 func TypeError(typ, culprit Term, env *Env) Exception {
 	return NewException(atomError.Apply(atomTypeError.Apply(typ, culprit), varContext), env)
 }
@@ -111,27 +374,29 @@ const (
 	validDomainWriteOption
 
 	validDomainOrder
+	validDomainAbsoluteFileNameOption
 )
 
 var validDomainAtoms = [...]Atom{
-	validDomainCharacterCodeList: atomCharacterCodeList,
-	validDomainCloseOption:       atomCloseOption,
-	validDomainFlagValue:         atomFlagValue,
-	validDomainIOMode:            atomIOMode,
-	validDomainNonEmptyList:      atomNonEmptyList,
-	validDomainNotLessThanZero:   atomNotLessThanZero,
-	validDomainOperatorPriority:  atomOperatorPriority,
-	validDomainOperatorSpecifier: atomOperatorSpecifier,
-	validDomainPrologFlag:        atomPrologFlag,
-	validDomainReadOption:        atomReadOption,
-	validDomainSourceSink:        atomSourceSink,
-	validDomainStream:            atomStream,
-	validDomainStreamOption:      atomStreamOption,
-	validDomainStreamOrAlias:     atomStreamOrAlias,
-	validDomainStreamPosition:    atomStreamPosition,
-	validDomainStreamProperty:    atomStreamProperty,
-	validDomainWriteOption:       atomWriteOption,
-	validDomainOrder:             atomOrder,
+	validDomainCharacterCodeList:      atomCharacterCodeList,
+	validDomainCloseOption:            atomCloseOption,
+	validDomainFlagValue:              atomFlagValue,
+	validDomainIOMode:                 atomIOMode,
+	validDomainNonEmptyList:           atomNonEmptyList,
+	validDomainNotLessThanZero:        atomNotLessThanZero,
+	validDomainOperatorPriority:       atomOperatorPriority,
+	validDomainOperatorSpecifier:      atomOperatorSpecifier,
+	validDomainPrologFlag:             atomPrologFlag,
+	validDomainReadOption:             atomReadOption,
+	validDomainSourceSink:             atomSourceSink,
+	validDomainStream:                 atomStream,
+	validDomainStreamOption:           atomStreamOption,
+	validDomainStreamOrAlias:          atomStreamOrAlias,
+	validDomainStreamPosition:         atomStreamPosition,
+	validDomainStreamProperty:         atomStreamProperty,
+	validDomainWriteOption:            atomWriteOption,
+	validDomainOrder:                  atomOrder,
+	validDomainAbsoluteFileNameOption: atomAbsoluteFileNameOption,
 }
 
 // Term returns an Atom for the validDomain.
@@ -140,6 +405,7 @@ func (vd validDomain) Term() Term {
 }
 
 // DomainError creates a new domain error exception.
+// Go callers can extract it as *DomainErr with errors.As.
 func DomainError(domain, culprit Term, env *Env) Exception {
 	return NewException(atomError.Apply(atomDomainError.Apply(domain, culprit), varContext), env)
 }
@@ -278,11 +544,15 @@ const (
 	resourceFiniteMemory resource = iota
 
 	resourceMemory
+	resourceTermDepth
+	resourceGoalExpansionDepth
 )
 
 var resourceAtoms = [...]Atom{
-	resourceFiniteMemory: atomFiniteMemory,
-	resourceMemory:       atomMemory,
+	resourceFiniteMemory:       atomFiniteMemory,
+	resourceMemory:             atomMemory,
+	resourceTermDepth:          atomTermDepth,
+	resourceGoalExpansionDepth: atomGoalExpansionDepth,
 }
 
 // Term returns an Atom for the resource.
@@ -297,10 +567,20 @@ func resourceError(resource resource, env *Env) Exception {
 }
 
 // syntaxError creates a new syntax error exception.
+// If err is a *SyntaxErr, the context is position(Line, Column, Offset).
 func syntaxError(err error, env *Env) Exception {
+	var se *SyntaxErr
+	if errors.As(err, &se) {
+		return NewException(se.Term(), env)
+	}
 	return NewException(atomError.Apply(atomSyntaxError.Apply(NewAtom(err.Error())), varContext), env)
 }
 
+// occursCheckException creates a new occurs check error exception which is raised while current_prolog_flag(occurs_check, error).
+func occursCheckException(v Variable, t Term, env *Env) Exception {
+	return NewException(atomError.Apply(atomOccursCheck.Apply(v, t), varContext), env)
+}
+
 // exceptionalValue is an evaluable functor's result which is not a number.
 type exceptionalValue uint8
 
@@ -1,6 +1,8 @@
 package engine
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -42,6 +44,110 @@ func TestTypeError(t *testing.T) {
 	}, TypeError(atomAtom, Integer(0), nil))
 }
 
+func TestException_As(t *testing.T) {
+	foo := atomSlash.Apply(NewAtom("foo"), Integer(1))
+
+	t.Run("instantiation error", func(t *testing.T) {
+		var e *InstantiationErr
+		assert.True(t, errors.As(NewException(atomError.Apply(atomInstantiationError, foo), nil), &e))
+		assert.Equal(t, &InstantiationErr{Context: foo}, e)
+	})
+
+	t.Run("type error", func(t *testing.T) {
+		var e *TypeErr
+		assert.True(t, errors.As(typeError(validTypeInteger, NewAtom("a"), nil), &e))
+		assert.Equal(t, &TypeErr{Type: atomInteger, Culprit: NewAtom("a"), Context: rootContext}, e)
+	})
+
+	t.Run("domain error", func(t *testing.T) {
+		var e *DomainErr
+		assert.True(t, errors.As(domainError(validDomainNotLessThanZero, Integer(-1), nil), &e))
+		assert.Equal(t, &DomainErr{Domain: atomNotLessThanZero, Culprit: Integer(-1), Context: rootContext}, e)
+	})
+
+	t.Run("existence error", func(t *testing.T) {
+		var e *ExistenceErr
+		assert.True(t, errors.As(existenceError(objectTypeProcedure, foo, nil), &e))
+		assert.Equal(t, &ExistenceErr{ObjectType: atomProcedure, Culprit: foo, Context: rootContext}, e)
+	})
+
+	t.Run("permission error", func(t *testing.T) {
+		var e *PermissionErr
+		assert.True(t, errors.As(permissionError(operationModify, permissionTypeStaticProcedure, foo, nil), &e))
+		assert.Equal(t, &PermissionErr{Operation: atomModify, PermissionType: atomStaticProcedure, Culprit: foo, Context: rootContext}, e)
+	})
+
+	t.Run("representation error", func(t *testing.T) {
+		var e *RepresentationErr
+		assert.True(t, errors.As(representationError(flagMaxArity, nil), &e))
+		assert.Equal(t, &RepresentationErr{Flag: atomMaxArity, Context: rootContext}, e)
+	})
+
+	t.Run("evaluation error", func(t *testing.T) {
+		var e *EvaluationErr
+		assert.True(t, errors.As(evaluationError(exceptionalValueZeroDivisor, nil), &e))
+		assert.Equal(t, &EvaluationErr{Value: atomZeroDivisor, Context: rootContext}, e)
+	})
+
+	t.Run("resource error", func(t *testing.T) {
+		var e *ResourceErr
+		assert.True(t, errors.As(resourceError(resourceMemory, nil), &e))
+		assert.Equal(t, &ResourceErr{Resource: atomMemory, Context: rootContext}, e)
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		var e *SyntaxErr
+		assert.True(t, errors.As(syntaxError(&SyntaxErr{Position: Position{Offset: 4, Line: 1, Column: 5}, Err: errors.New("unexpected token")}, nil), &e))
+		assert.Equal(t, &SyntaxErr{Position: Position{Offset: 4, Line: 1, Column: 5}, Err: errors.New("unexpected token")}, e)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		var e *DomainErr
+		assert.False(t, errors.As(typeError(validTypeInteger, NewAtom("a"), nil), &e))
+		assert.False(t, errors.As(NewException(NewAtom("foo"), nil), &e))
+		assert.False(t, errors.As(NewException(atomError.Apply(NewAtom("foo"), foo), nil), &e))
+	})
+}
+
+func TestExceptionOf(t *testing.T) {
+	foo := atomSlash.Apply(NewAtom("foo"), Integer(1))
+	env := NewEnv().bind(varContext, foo)
+
+	tests := []struct {
+		title string
+		err   error
+		ok    bool
+		want  Exception
+	}{
+		{title: "instantiation error", err: &InstantiationErr{}, ok: true, want: InstantiationError(env)},
+		{title: "type error", err: &TypeErr{Type: atomInteger, Culprit: NewAtom("a")}, ok: true, want: typeError(validTypeInteger, NewAtom("a"), env)},
+		{title: "domain error", err: &DomainErr{Domain: atomNotLessThanZero, Culprit: Integer(-1)}, ok: true, want: domainError(validDomainNotLessThanZero, Integer(-1), env)},
+		{title: "existence error", err: &ExistenceErr{ObjectType: atomProcedure, Culprit: foo}, ok: true, want: existenceError(objectTypeProcedure, foo, env)},
+		{title: "permission error", err: &PermissionErr{Operation: atomModify, PermissionType: atomStaticProcedure, Culprit: foo}, ok: true, want: permissionError(operationModify, permissionTypeStaticProcedure, foo, env)},
+		{title: "representation error", err: &RepresentationErr{Flag: atomMaxArity}, ok: true, want: representationError(flagMaxArity, env)},
+		{title: "evaluation error", err: &EvaluationErr{Value: atomZeroDivisor}, ok: true, want: evaluationError(exceptionalValueZeroDivisor, env)},
+		{title: "resource error", err: &ResourceErr{Resource: atomMemory}, ok: true, want: resourceError(resourceMemory, env)},
+		{title: "syntax error", err: &SyntaxErr{Err: errors.New("unexpected token")}, ok: true, want: NewException(atomError.Apply(atomSyntaxError.Apply(NewAtom("unexpected token")), foo), nil)},
+		{title: "syntax error with position", err: &SyntaxErr{Position: Position{Offset: 4, Line: 1, Column: 5}, Err: errors.New("unexpected token")}, ok: true, want: syntaxError(&SyntaxErr{Position: Position{Offset: 4, Line: 1, Column: 5}, Err: errors.New("unexpected token")}, env)},
+		{title: "explicit context", err: &TypeErr{Type: atomInteger, Culprit: NewAtom("a"), Context: NewAtom("bar")}, ok: true, want: NewException(atomError.Apply(atomTypeError.Apply(atomInteger, NewAtom("a")), NewAtom("bar")), nil)},
+		{title: "wrapped", err: fmt.Errorf("wrapped: %w", &InstantiationErr{}), ok: true, want: InstantiationError(env)},
+		{title: "exception", err: InstantiationError(nil), ok: true, want: InstantiationError(nil)},
+		{title: "other", err: errors.New("failed")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			e, ok := exceptionOf(tt.err, env)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, e)
+		})
+	}
+}
+
+func TestTypeErr_Error(t *testing.T) {
+	assert.Equal(t, "error(type_error(integer,a),foo/1)", (&TypeErr{Type: atomInteger, Culprit: NewAtom("a"), Context: atomSlash.Apply(NewAtom("foo"), Integer(1))}).Error())
+}
+
 func TestExceptionalValue_Error(t *testing.T) {
 	assert.Equal(t, "int_overflow", exceptionalValueIntOverflow.Error())
 }
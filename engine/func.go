@@ -0,0 +1,329 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+var (
+	contextType         = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType           = reflect.TypeOf((*error)(nil)).Elem()
+	termUnmarshalerType = reflect.TypeOf((*TermUnmarshaler)(nil)).Elem()
+)
+
+// validTypes are the types of the errors raised when arguments are not of the built-in term types.
+var validTypes = map[reflect.Type]validType{
+	reflect.TypeOf(Atom(0)):                 validTypeAtom,
+	reflect.TypeOf(Integer(0)):              validTypeInteger,
+	reflect.TypeOf(Float(0)):                validTypeFloat,
+	reflect.TypeOf((*Compound)(nil)).Elem(): validTypeCompound,
+}
+
+// RegisterFunc registers a Go function fn as a predicate.
+// The parameters of fn are the input arguments and the results are the output arguments in this order, e.g.
+// func(url string) (int, error) is a predicate of arity 2 whose first argument is an atom and second argument is
+// unified with an integer.
+// If the first parameter is context.Context, fn receives the context of the execution.
+// If the last result is error, a non-nil error is raised as an exception. A typed error, e.g. *TypeErr, is raised as
+// error(Formal, Context) so that catch/3 can catch it.
+// The arguments and the results are converted in the same way as the arguments for placeholders.
+func (vm *VM) RegisterFunc(name Atom, fn interface{}) error {
+	p, err := newFuncPredicate(fn)
+	if err != nil {
+		return err
+	}
+	if vm.procedures == nil {
+		vm.procedures = map[procedureIndicator]procedure{}
+	}
+	vm.procedures[procedureIndicator{name: name, arity: Integer(len(p.in) + len(p.out))}] = p
+	return nil
+}
+
+// funcPredicate is a predicate backed by a Go function.
+type funcPredicate struct {
+	fn  reflect.Value
+	ctx bool // the first parameter is context.Context
+	in  []reflect.Type
+	out []reflect.Type
+	err bool // the last result is error
+}
+
+func newFuncPredicate(fn interface{}) (*funcPredicate, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("not a function: %T", fn)
+	}
+
+	t := v.Type()
+	if t.IsVariadic() {
+		return nil, fmt.Errorf("variadic function: %s", t)
+	}
+
+	p := funcPredicate{fn: v}
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if i == 0 && in == contextType {
+			p.ctx = true
+			continue
+		}
+		if !convertible(in, map[reflect.Type]struct{}{}) {
+			return nil, fmt.Errorf("unsupported parameter type: %s", in)
+		}
+		p.in = append(p.in, in)
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		out := t.Out(i)
+		if i == t.NumOut()-1 && out == errorType {
+			p.err = true
+			continue
+		}
+		if !convertible(out, map[reflect.Type]struct{}{}) {
+			return nil, fmt.Errorf("unsupported result type: %s", out)
+		}
+		p.out = append(p.out, out)
+	}
+	return &p, nil
+}
+
+func (p *funcPredicate) call(vm *VM, args []Term, k Cont, env *Env) *Promise {
+	if len(args) != len(p.in)+len(p.out) {
+		return Error(&wrongNumberOfArgumentsError{expected: len(p.in) + len(p.out), actual: args})
+	}
+
+	return Delay(func(ctx context.Context) *Promise {
+		in := make([]reflect.Value, 0, len(p.in)+1)
+		if p.ctx {
+			in = append(in, reflect.ValueOf(ctx))
+		}
+		for i, typ := range p.in {
+			v, err := valueOf(args[i], typ, env)
+			if err != nil {
+				return Error(err)
+			}
+			in = append(in, v)
+		}
+
+		out := p.fn.Call(in)
+		if p.err {
+			var e reflect.Value
+			out, e = out[:len(out)-1], out[len(out)-1]
+			if !e.IsNil() {
+				return Error(e.Interface().(error))
+			}
+		}
+
+		for i, o := range out {
+			t, err := termOf(o)
+			if err != nil {
+				return Error(err)
+			}
+			var ok bool
+			env, ok, err = vm.unify(args[len(p.in)+i], t, env)
+			if err != nil {
+				return Error(err)
+			}
+			if !ok {
+				return Bool(false)
+			}
+		}
+		return k(env)
+	})
+}
+
+// convertible checks if the values of the type t can be converted from and to terms.
+func convertible(t reflect.Type, visited map[reflect.Type]struct{}) bool {
+	if _, ok := visited[t]; ok {
+		return true
+	}
+	visited[t] = struct{}{}
+
+	switch {
+	case t == timeType, t.Implements(termType), reflect.PtrTo(t).Implements(termUnmarshalerType):
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return termType.Implements(t)
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice, reflect.Ptr:
+		return convertible(t.Elem(), visited)
+	case reflect.Map:
+		return convertible(t.Key(), visited) && convertible(t.Elem(), visited)
+	case reflect.Struct:
+		_, fields, err := StructFields(t)
+		if err != nil {
+			return false
+		}
+		for _, f := range fields {
+			if !convertible(t.Field(f).Type, visited) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// valueOf converts the term t to a Go value of the type typ.
+// It returns an instantiation error or a type error if t doesn't fit.
+func valueOf(t Term, typ reflect.Type, env *Env) (reflect.Value, error) {
+	switch {
+	case typ.Kind() == reflect.Interface && termType.Implements(typ):
+		return reflect.ValueOf(env.simplify(t)).Convert(typ), nil
+	case reflect.PtrTo(typ).Implements(termUnmarshalerType):
+		v := reflect.New(typ)
+		if err := v.Interface().(TermUnmarshaler).UnmarshalTerm(t, env); err != nil {
+			return reflect.Value{}, err
+		}
+		return v.Elem(), nil
+	case typ.Implements(termType):
+		t := env.Resolve(t)
+		if reflect.TypeOf(t).AssignableTo(typ) {
+			return reflect.ValueOf(t).Convert(typ), nil
+		}
+		if _, ok := t.(Variable); ok {
+			return reflect.Value{}, InstantiationError(env)
+		}
+		vt, ok := validTypes[typ]
+		if !ok {
+			vt = validTypeCallable
+		}
+		return reflect.Value{}, typeError(vt, t, env)
+	}
+
+	t = env.Resolve(t)
+	if _, ok := t.(Variable); ok && typ.Kind() != reflect.Ptr {
+		return reflect.Value{}, InstantiationError(env)
+	}
+
+	if typ == timeType {
+		switch t := t.(type) {
+		case Integer:
+			return reflect.ValueOf(time.Unix(int64(t), 0)), nil
+		case Float:
+			return reflect.ValueOf(time.Unix(0, int64(float64(t)*float64(time.Second)))), nil
+		default:
+			return reflect.Value{}, typeError(validTypeNumber, t, env)
+		}
+	}
+
+	v := reflect.New(typ).Elem()
+	switch typ.Kind() {
+	case reflect.String:
+		a, ok := t.(Atom)
+		if !ok {
+			return reflect.Value{}, typeError(validTypeAtom, t, env)
+		}
+		v.SetString(a.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := t.(Integer)
+		if !ok {
+			return reflect.Value{}, typeError(validTypeInteger, t, env)
+		}
+		if v.OverflowInt(int64(i)) {
+			return reflect.Value{}, representationError(flagMaxInteger, env)
+		}
+		v.SetInt(int64(i))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, ok := t.(Integer)
+		if !ok {
+			return reflect.Value{}, typeError(validTypeInteger, t, env)
+		}
+		if i < 0 || v.OverflowUint(uint64(i)) {
+			return reflect.Value{}, representationError(flagMaxInteger, env)
+		}
+		v.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		switch n := t.(type) {
+		case Float:
+			v.SetFloat(float64(n))
+		case Integer:
+			v.SetFloat(float64(n))
+		default:
+			return reflect.Value{}, typeError(validTypeNumber, t, env)
+		}
+	case reflect.Slice:
+		iter := ListIterator{List: t, Env: env}
+		for iter.Next() {
+			e, err := valueOf(iter.Current(), typ.Elem(), env)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v = reflect.Append(v, e)
+		}
+		if err := iter.Err(); err != nil {
+			return reflect.Value{}, err
+		}
+		if v.IsNil() {
+			v = reflect.MakeSlice(typ, 0, 0)
+		}
+	case reflect.Map:
+		v = reflect.MakeMap(typ)
+		iter := ListIterator{List: t, Env: env}
+		for iter.Next() {
+			p, ok := env.Resolve(iter.Current()).(Compound)
+			if !ok || p.Functor() != atomMinus || p.Arity() != 2 {
+				return reflect.Value{}, typeError(validTypePair, iter.Current(), env)
+			}
+			key, err := valueOf(p.Arg(0), typ.Key(), env)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			val, err := valueOf(p.Arg(1), typ.Elem(), env)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.SetMapIndex(key, val)
+		}
+		if err := iter.Err(); err != nil {
+			return reflect.Value{}, err
+		}
+	case reflect.Ptr:
+		if _, ok := t.(Variable); ok {
+			break
+		}
+		e, err := valueOf(t, typ.Elem(), env)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v = reflect.New(typ.Elem())
+		v.Elem().Set(e)
+	case reflect.Struct:
+		name, fields, err := StructFields(typ)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		// A compound of a different shape is a type error of which the type is the principal functor, e.g. person/2.
+		pi := procedureIndicator{name: NewAtom(name), arity: Integer(len(fields))}
+		switch t := t.(type) {
+		case Atom:
+			if len(fields) != 0 || t != pi.name {
+				return reflect.Value{}, TypeError(pi.Term(), t, env)
+			}
+		case Compound:
+			if t.Functor() != pi.name || t.Arity() != len(fields) {
+				return reflect.Value{}, TypeError(pi.Term(), t, env)
+			}
+			for i, f := range fields {
+				e, err := valueOf(t.Arg(i), typ.Field(f).Type, env)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				v.Field(f).Set(e)
+			}
+		default:
+			return reflect.Value{}, TypeError(pi.Term(), t, env)
+		}
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported type: %s", typ)
+	}
+	return v, nil
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVM_RegisterFunc(t *testing.T) {
+	type point struct {
+		_    struct{} `prolog:"point(x,y)"`
+		X, Y int
+	}
+
+	var vm VM
+	assert.NoError(t, vm.RegisterFunc(NewAtom("upper"), strings.ToUpper))
+	assert.NoError(t, vm.RegisterFunc(NewAtom("div"), func(x, y int) (int, error) {
+		if y == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return x / y, nil
+	}))
+	assert.NoError(t, vm.RegisterFunc(NewAtom("user"), func(ctx context.Context) string {
+		return ctx.Value(testContextKey{}).(string)
+	}))
+	assert.NoError(t, vm.RegisterFunc(NewAtom("move"), func(p point, dx, dy int) *point {
+		return &point{X: p.X + dx, Y: p.Y + dy}
+	}))
+	assert.NoError(t, vm.RegisterFunc(NewAtom("sum"), func(ns []float64) float64 {
+		var s float64
+		for _, n := range ns {
+			s += n
+		}
+		return s
+	}))
+	assert.NoError(t, vm.RegisterFunc(NewAtom("keys"), func(m map[string]int) int {
+		return len(m)
+	}))
+	assert.NoError(t, vm.RegisterFunc(NewAtom("functor_name"), func(c Compound) Atom {
+		return c.Functor()
+	}))
+	assert.NoError(t, vm.RegisterFunc(NewAtom("big"), func() uint64 {
+		return math.MaxInt64 + 1
+	}))
+	assert.NoError(t, vm.RegisterFunc(NewAtom("nine"), func(a, b, c, d, e, f, g, h, i int) int {
+		return a + b + c + d + e + f + g + h + i
+	}))
+
+	// The environment in which an error is raised by name/arity.
+	in := func(name string, arity Integer) *Env {
+		return NewEnv().bind(varContext, procedureIndicator{name: NewAtom(name), arity: arity}.Term())
+	}
+
+	x := NewVariable()
+	tests := []struct {
+		title string
+		goal  Term
+		ok    bool
+		err   error
+		want  Term
+	}{
+		{title: "atom", goal: NewAtom("upper").Apply(NewAtom("foo"), x), ok: true, want: NewAtom("FOO")},
+		{title: "output mismatch", goal: NewAtom("upper").Apply(NewAtom("foo"), NewAtom("bar")), ok: false},
+		{title: "instantiation error", goal: NewAtom("upper").Apply(NewVariable(), x), err: InstantiationError(in("upper", 2))},
+		{title: "type error", goal: NewAtom("upper").Apply(Integer(1), x), err: typeError(validTypeAtom, Integer(1), in("upper", 2))},
+		{title: "context", goal: NewAtom("user").Apply(x), ok: true, want: NewAtom("alice")},
+		{title: "integer", goal: NewAtom("div").Apply(Integer(7), Integer(2), x), ok: true, want: Integer(3)},
+		{title: "error", goal: NewAtom("div").Apply(Integer(7), Integer(0), x), err: errors.New("division by zero")},
+		{title: "struct", goal: NewAtom("move").Apply(NewAtom("point").Apply(Integer(1), Integer(2)), Integer(1), Integer(1), x), ok: true, want: NewAtom("point").Apply(Integer(2), Integer(3))},
+		{title: "struct: type error", goal: NewAtom("move").Apply(NewAtom("foo"), Integer(1), Integer(1), x), err: TypeError(procedureIndicator{name: NewAtom("point"), arity: 2}.Term(), NewAtom("foo"), in("move", 4))},
+		{title: "list", goal: NewAtom("sum").Apply(List(Float(1.5), Integer(2)), x), ok: true, want: Float(3.5)},
+		{title: "list: partial", goal: NewAtom("sum").Apply(PartialList(NewVariable(), Float(1.5)), x), err: InstantiationError(in("sum", 2))},
+		{title: "pairs", goal: NewAtom("keys").Apply(List(atomMinus.Apply(NewAtom("a"), Integer(1)), atomMinus.Apply(NewAtom("b"), Integer(2))), x), ok: true, want: Integer(2)},
+		{title: "pairs: not a pair", goal: NewAtom("keys").Apply(List(NewAtom("a")), x), err: typeError(validTypePair, NewAtom("a"), in("keys", 2))},
+		{title: "term", goal: NewAtom("functor_name").Apply(NewAtom("f").Apply(NewAtom("a")), x), ok: true, want: NewAtom("f")},
+		{title: "term: type error", goal: NewAtom("functor_name").Apply(NewAtom("a"), x), err: typeError(validTypeCompound, NewAtom("a"), in("functor_name", 2))},
+		{title: "result: representation error", goal: NewAtom("big").Apply(x), err: representationError(flagMaxInteger, in("big", 1))},
+		{title: "arity 10", goal: NewAtom("nine").Apply(Integer(1), Integer(1), Integer(1), Integer(1), Integer(1), Integer(1), Integer(1), Integer(1), Integer(1), x), ok: true, want: Integer(9)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			ok, err := Call(&vm, tt.goal, func(env *Env) *Promise {
+				assert.Equal(t, tt.want, env.Resolve(x))
+				return Bool(true)
+			}, nil).Force(context.WithValue(context.Background(), testContextKey{}, "alice"))
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+
+	t.Run("occurs check", func(t *testing.T) {
+		vm := VM{occursCheck: occursCheckTrue}
+		assert.NoError(t, vm.RegisterFunc(NewAtom("wrap"), func(c Compound) Compound {
+			return NewAtom("f").Apply(c).(Compound)
+		}))
+
+		y := NewVariable()
+		ok, err := Call(&vm, NewAtom("wrap").Apply(NewAtom("g").Apply(y), y), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		vm.occursCheck = occursCheckError
+		ok, err = Call(&vm, NewAtom("wrap").Apply(NewAtom("g").Apply(y), y), Success, nil).Force(context.Background())
+		assert.Error(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		for _, fn := range []interface{}{
+			nil,
+			1,
+			fmt.Sprintf,
+			func(bool) {},
+			func() chan int { return nil },
+		} {
+			assert.Error(t, vm.RegisterFunc(NewAtom("foo"), fn))
+		}
+	})
+}
+
+type testContextKey struct{}
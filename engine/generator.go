@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// Generator is a Go iterator which yields the values of the output arguments of a predicate one solution at a time.
+// yield returns false if no more solutions are needed, e.g. the choice point is cut, and then the generator should
+// return promptly after releasing its resources.
+type Generator func(yield func(values ...Term) bool) error
+
+// Generate unifies args with the values yielded by g and continues with k for each of them on backtracking.
+// g runs lazily, i.e. it computes the next solution only when Prolog backtracks into it.
+// An error returned by g is raised as an exception.
+func Generate(vm *VM, g Generator, args []Term, k Cont, env *Env) *Promise {
+	gen := generator{g: g}
+	var next func(context.Context) *Promise
+	next = func(ctx context.Context) *Promise {
+		values, ok, err := gen.next(ctx)
+		if err != nil {
+			return Error(err)
+		}
+		if !ok {
+			return Bool(false)
+		}
+		if len(values) != len(args) {
+			gen.stop()
+			return Error(fmt.Errorf("wrong number of values: expected %d, got %d", len(args), len(values)))
+		}
+		p := Delay(func(context.Context) *Promise {
+			return Unify(vm, List(args...), List(values...), k, env)
+		}, next)
+		p.discard = gen.stop
+		return p
+	}
+	p := Delay(next)
+	p.discard = gen.stop
+	return p
+}
+
+// generator runs a Generator on its own goroutine so that it can be suspended at each solution.
+type generator struct {
+	g Generator
+
+	started, stopped bool
+	values           chan []Term
+	resume, quit     chan struct{}
+	done             chan struct{} // closed when g returns
+	err              error
+}
+
+func (g *generator) next(ctx context.Context) ([]Term, bool, error) {
+	if g.stopped {
+		return nil, false, nil
+	}
+
+	if !g.started {
+		g.started = true
+		g.values = make(chan []Term)
+		g.resume = make(chan struct{})
+		g.quit = make(chan struct{})
+		g.done = make(chan struct{})
+		go g.run()
+	} else {
+		select {
+		case g.resume <- struct{}{}:
+		case <-g.done:
+		}
+	}
+
+	select {
+	case values := <-g.values:
+		return values, true, nil
+	case <-g.done:
+		g.stopped = true
+		return nil, false, g.err
+	case <-ctx.Done():
+		// g may be in the middle of a slow computation. We don't wait for it and let it return in the background.
+		g.abandon()
+		return nil, false, ctx.Err()
+	}
+}
+
+func (g *generator) run() {
+	defer close(g.done)
+	defer func() {
+		// A panic on this goroutine would crash the process. Instead, we raise it as an exception.
+		if r := recover(); r != nil {
+			g.err = fmt.Errorf("generator panicked: %v", r)
+		}
+	}()
+	g.err = g.g(func(values ...Term) bool {
+		select {
+		case g.values <- values:
+		case <-g.quit:
+			return false
+		}
+		select {
+		case <-g.resume:
+			return true
+		case <-g.quit:
+			return false
+		}
+	})
+}
+
+// stop tells the generator that no more solutions are needed and waits for it to return.
+func (g *generator) stop() {
+	if !g.started || g.stopped {
+		return
+	}
+	g.abandon()
+	<-g.done
+}
+
+// abandon tells the generator that no more solutions are needed without waiting for it to return.
+func (g *generator) abandon() {
+	g.stopped = true
+	close(g.quit)
+}
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	// nat/1 enumerates natural numbers and records how many it has produced and whether it has been cleaned up.
+	var produced int
+	var closed bool
+	nat := func(vm *VM, n Term, k Cont, env *Env) *Promise {
+		produced, closed = 0, false
+		return Generate(vm, func(yield func(...Term) bool) error {
+			defer func() {
+				closed = true
+			}()
+			for i := Integer(0); ; i++ {
+				produced++
+				if !yield(i) {
+					return nil
+				}
+			}
+		}, []Term{n}, k, env)
+	}
+
+	var vm VM
+	vm.Register1(NewAtom("nat"), nat)
+
+	t.Run("lazy", func(t *testing.T) {
+		x := NewVariable()
+		ok, err := Call(&vm, NewAtom("nat").Apply(x), func(env *Env) *Promise {
+			return Bool(env.Resolve(x) == Integer(3))
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 4, produced)
+		assert.True(t, closed)
+	})
+
+	t.Run("cut", func(t *testing.T) {
+		x := NewVariable()
+		ok, err := Call(&vm, atomComma.Apply(NewAtom("nat").Apply(x), atomCut), func(env *Env) *Promise {
+			assert.Equal(t, Integer(0), env.Resolve(x))
+			assert.True(t, closed)
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, 1, produced)
+	})
+
+	t.Run("bound argument", func(t *testing.T) {
+		ok, err := Call(&vm, NewAtom("nat").Apply(Integer(2)), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 3, produced)
+		assert.True(t, closed)
+	})
+
+	t.Run("context cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ok, err := Call(&vm, NewAtom("nat").Apply(NewVariable()), func(env *Env) *Promise {
+			if produced == 2 {
+				cancel()
+			}
+			return Bool(false)
+		}, nil).Force(ctx)
+		assert.Equal(t, context.Canceled, err)
+		assert.False(t, ok)
+		assert.True(t, closed)
+	})
+
+	t.Run("finite", func(t *testing.T) {
+		var ns []Term
+		ok, err := Generate(&vm, func(yield func(...Term) bool) error {
+			for _, n := range []Term{Integer(1), Integer(2)} {
+				if !yield(n, NewAtom("a")) {
+					break
+				}
+			}
+			return nil
+		}, []Term{NewVariable(), NewAtom("a")}, func(env *Env) *Promise {
+			ns = append(ns, NewAtom("x"))
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Len(t, ns, 2)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		ok, err := Generate(&vm, func(yield func(...Term) bool) error {
+			if !yield(Integer(1)) {
+				return nil
+			}
+			return errors.New("failed")
+		}, []Term{NewVariable()}, Failure, nil).Force(context.Background())
+		assert.Equal(t, errors.New("failed"), err)
+		assert.False(t, ok)
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		ok, err := Generate(&vm, func(yield func(...Term) bool) error {
+			panic("boom")
+		}, []Term{NewVariable()}, Success, nil).Force(context.Background())
+		assert.Equal(t, errors.New("generator panicked: boom"), err)
+		assert.False(t, ok)
+	})
+
+	t.Run("cancellation during a slow computation", func(t *testing.T) {
+		slow := make(chan struct{})
+		defer close(slow)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		ok, err := Generate(&vm, func(yield func(...Term) bool) error {
+			<-slow
+			return nil
+		}, []Term{NewVariable()}, Success, nil).Force(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("wrong number of values", func(t *testing.T) {
+		ok, err := Generate(&vm, func(yield func(...Term) bool) error {
+			yield(Integer(1), Integer(2))
+			return nil
+		}, []Term{NewVariable()}, Success, nil).Force(context.Background())
+		assert.Equal(t, fmt.Errorf("wrong number of values: expected 1, got 2"), err)
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,537 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	imageMagic   = "\x00plimg"
+	imageVersion = 2
+	imageExt     = ".qlf"
+)
+
+var errInvalidImage = errors.New("invalid image")
+
+// SaveImage writes the user-defined procedures, operators, flags, and character conversions of the VM to w so that
+// another VM can restore them by LoadImage without parsing and compiling Prolog texts.
+// It also writes the initialization goals of the loaded files so that LoadImage runs them.
+// If files are given, it writes only the procedures, the clauses, and the initialization goals defined by the loaded
+// files. A file loaded by one of them, e.g. by ensure_loaded/1, is not included unless it's given as well.
+func (vm *VM) SaveImage(w io.Writer, files ...string) error {
+	return vm.saveImage(w, files)
+}
+
+func (vm *VM) saveImage(w io.Writer, files []string) error {
+	var (
+		pis   []procedureIndicator
+		goals []Term
+	)
+	fileSet := map[string]struct{}{}
+	if len(files) == 0 {
+		for _, f := range vm.LoadedFiles() {
+			goals = append(goals, vm.loaded[f].goals...)
+		}
+		for pi, p := range vm.procedures {
+			if _, ok := p.(*userDefined); ok {
+				pis = append(pis, pi)
+			}
+		}
+	} else {
+		seen := map[procedureIndicator]struct{}{}
+		for _, f := range files {
+			lf, ok := vm.loaded[f]
+			if !ok {
+				return fmt.Errorf("%s is not loaded", f)
+			}
+			fileSet[f] = struct{}{}
+			goals = append(goals, lf.goals...)
+			for pi := range lf.procedures {
+				if _, ok := seen[pi]; ok {
+					continue
+				}
+				if _, ok := vm.procedures[pi].(*userDefined); !ok {
+					continue
+				}
+				seen[pi] = struct{}{}
+				pis = append(pis, pi)
+			}
+		}
+	}
+
+	// Make the output deterministic.
+	sort.Slice(pis, func(i, j int) bool {
+		return pis[i].Compare(pis[j], nil) < 0
+	})
+
+	var e termEncoder
+	e.buf = append(e.buf, imageMagic...)
+	e.uvarint(imageVersion)
+
+	// Flags
+	e.byte(byte(vm.unknown))
+	e.byte(byte(vm.doubleQuotes))
+	e.byte(byte(vm.occursCheck))
+	e.bool(vm.charConvEnabled)
+	e.bool(vm.debug)
+
+	// Character conversions
+	rs := make([]rune, 0, len(vm.charConversions))
+	for r := range vm.charConversions {
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i] < rs[j]
+	})
+	e.uvarint(uint64(len(rs)))
+	for _, r := range rs {
+		e.varint(int64(r))
+		e.varint(int64(vm.charConversions[r]))
+	}
+
+	// Operators
+	var ops []operator
+	for _, os := range vm.operators {
+		for _, op := range os {
+			if op != (operator{}) {
+				ops = append(ops, op)
+			}
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].name != ops[j].name {
+			return ops[i].name.String() < ops[j].name.String()
+		}
+		return ops[i].specifier < ops[j].specifier
+	})
+	e.uvarint(uint64(len(ops)))
+	for _, op := range ops {
+		e.atom(op.name)
+		e.varint(int64(op.priority))
+		e.byte(byte(op.specifier))
+	}
+
+	// Procedures
+	e.uvarint(uint64(len(pis)))
+	for _, pi := range pis {
+		u := vm.procedures[pi].(*userDefined)
+		e.atom(pi.name)
+		e.varint(int64(pi.arity))
+		e.bool(u.public)
+		e.bool(u.dynamic)
+		e.bool(u.multifile)
+		e.bool(u.discontiguous)
+		e.string(u.file)
+		cs := u.clauses
+		if len(files) > 0 {
+			cs = make(clauses, 0, len(u.clauses))
+			for _, c := range u.clauses {
+				if _, ok := fileSet[c.file]; ok {
+					cs = append(cs, c)
+				}
+			}
+		}
+		e.uvarint(uint64(len(cs)))
+		for _, c := range cs {
+			if err := e.clause(c); err != nil {
+				return fmt.Errorf("%s: %w", pi, err)
+			}
+		}
+	}
+
+	// Initialization goals
+	e.uvarint(uint64(len(goals)))
+	for _, g := range goals {
+		e.resetVariables()
+		if err := e.term(g, nil); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(e.buf)
+	return err
+}
+
+func (e *termEncoder) clause(c clause) error {
+	// The variables are local to the clause.
+	e.resetVariables()
+	if err := e.term(c.raw, nil); err != nil {
+		return err
+	}
+	e.uvarint(uint64(len(c.vars)))
+	for _, v := range c.vars {
+		if err := e.term(v, nil); err != nil {
+			return err
+		}
+	}
+	e.uvarint(uint64(len(c.bytecode)))
+	for _, i := range c.bytecode {
+		e.byte(byte(i.opcode))
+		if err := e.operand(i.operand); err != nil {
+			return err
+		}
+	}
+	e.string(c.file)
+	return nil
+}
+
+// LoadImage restores the user-defined procedures, operators, flags, and character conversions written by SaveImage.
+// The procedures in the image replace the ones with the same predicate indicators unless both of them are multifile.
+// Then, it runs the initialization goals in the image.
+func (vm *VM) LoadImage(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s, t, err := vm.readImage(b, "")
+	if err != nil {
+		return err
+	}
+	vm.applyState(s, true)
+	vm.install(t)
+	return vm.initialize(context.Background(), t, nil)
+}
+
+// loadImageFile loads the image file f with the content b replacing the procedures f previously defined.
+// Unlike LoadImage, it keeps the flags of the VM since consulting a file doesn't restore the whole state.
+func (vm *VM) loadImageFile(ctx context.Context, f string, b []byte) error {
+	s, t, err := vm.readImage(b, f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f, err)
+	}
+
+	vm.applyState(s, false)
+	vm.replace(f, b, t)
+	return vm.initialize(ctx, t, nil)
+}
+
+// imageState is the part of an image other than procedures.
+type imageState struct {
+	unknown         unknownAction
+	doubleQuotes    doubleQuotes
+	occursCheck     occursCheck
+	charConvEnabled bool
+	debug           bool
+	charConversions map[rune]rune
+	operators       []operator
+}
+
+func (vm *VM) readImage(b []byte, source string) (*imageState, *text, error) {
+	if !bytes.HasPrefix(b, []byte(imageMagic)) {
+		return nil, nil, errInvalidImage
+	}
+	d := termDecoder{buf: b[len(imageMagic):]}
+	v, err := d.uvarint()
+	if err != nil {
+		return nil, nil, errInvalidImage
+	}
+	if v != imageVersion {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", errInvalidImage, v)
+	}
+
+	var s imageState
+	if err := d.imageState(&s); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	t := text{vm: vm, source: source, file: source}
+	if err := d.procedures(&t); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	if err := d.goals(&t); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	if len(d.buf) > 0 {
+		return nil, nil, errInvalidImage
+	}
+	return &s, &t, nil
+}
+
+// applyState updates the char conversions and the operators of the VM with the ones in the image.
+// If flags is true, it also updates the flags.
+// It's called only after the image is successfully read.
+func (vm *VM) applyState(s *imageState, flags bool) {
+	if flags {
+		vm.unknown, vm.doubleQuotes, vm.occursCheck = s.unknown, s.doubleQuotes, s.occursCheck
+		vm.charConvEnabled, vm.debug = s.charConvEnabled, s.debug
+	}
+	for from, to := range s.charConversions {
+		if vm.charConversions == nil {
+			vm.charConversions = map[rune]rune{}
+		}
+		vm.charConversions[from] = to
+	}
+	for _, op := range s.operators {
+		vm.operators.define(op.priority, op.specifier, op.name)
+	}
+}
+
+func (d *termDecoder) imageState(s *imageState) error {
+	var err error
+	var flags [3]byte
+	for i := range flags {
+		if flags[i], err = d.byte(); err != nil {
+			return err
+		}
+	}
+	if flags[0] > byte(unknownWarning) || flags[1] > byte(doubleQuotesAtom) || flags[2] > byte(occursCheckError) {
+		return errInvalidImage
+	}
+	s.unknown, s.doubleQuotes, s.occursCheck = unknownAction(flags[0]), doubleQuotes(flags[1]), occursCheck(flags[2])
+	if s.charConvEnabled, err = d.bool(); err != nil {
+		return err
+	}
+	if s.debug, err = d.bool(); err != nil {
+		return err
+	}
+
+	n, err := d.length()
+	if err != nil {
+		return err
+	}
+	s.charConversions = make(map[rune]rune, n)
+	for i := 0; i < n; i++ {
+		from, err := d.varint()
+		if err != nil {
+			return err
+		}
+		to, err := d.varint()
+		if err != nil {
+			return err
+		}
+		if !utf8.ValidRune(rune(from)) || !utf8.ValidRune(rune(to)) {
+			return errInvalidImage
+		}
+		s.charConversions[rune(from)] = rune(to)
+	}
+
+	n, err = d.length()
+	if err != nil {
+		return err
+	}
+	s.operators = make([]operator, n)
+	for i := range s.operators {
+		name, err := d.atom()
+		if err != nil {
+			return err
+		}
+		p, err := d.varint()
+		if err != nil {
+			return err
+		}
+		spec, err := d.byte()
+		if err != nil {
+			return err
+		}
+		if p < 0 || p > 1200 {
+			return errInvalidImage
+		}
+		switch operatorSpecifier(spec) {
+		case operatorSpecifierFX, operatorSpecifierFY, operatorSpecifierXF, operatorSpecifierYF, operatorSpecifierXFX, operatorSpecifierXFY, operatorSpecifierYFX:
+		default:
+			return errInvalidImage
+		}
+		s.operators[i] = operator{priority: Integer(p), specifier: operatorSpecifier(spec), name: name}
+	}
+	return nil
+}
+
+func (d *termDecoder) procedures(t *text) error {
+	n, err := d.length()
+	if err != nil {
+		return err
+	}
+	t.clauses = make(map[procedureIndicator]*userDefined, n)
+	for i := 0; i < n; i++ {
+		name, err := d.atom()
+		if err != nil {
+			return err
+		}
+		arity, err := d.varint()
+		if err != nil {
+			return err
+		}
+		pi := procedureIndicator{name: name, arity: Integer(arity)}
+		var u userDefined
+		for _, b := range []*bool{&u.public, &u.dynamic, &u.multifile, &u.discontiguous} {
+			if *b, err = d.bool(); err != nil {
+				return err
+			}
+		}
+		if u.file, err = d.string(); err != nil {
+			return err
+		}
+		m, err := d.length()
+		if err != nil {
+			return err
+		}
+		u.clauses = make(clauses, m)
+		for j := range u.clauses {
+			if u.clauses[j], err = d.clause(); err != nil {
+				return err
+			}
+			u.clauses[j].pi = pi
+		}
+		t.clauses[pi] = &u
+	}
+	return nil
+}
+
+func (d *termDecoder) goals(t *text) error {
+	n, err := d.length()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		d.resetVariables()
+		g, err := d.term()
+		if err != nil {
+			return err
+		}
+		t.goals = append(t.goals, textGoal{goal: g})
+	}
+	return nil
+}
+
+func (d *termDecoder) clause() (clause, error) {
+	var c clause
+	d.resetVariables()
+	raw, err := d.term()
+	if err != nil {
+		return c, err
+	}
+	c.raw = raw
+	n, err := d.length()
+	if err != nil {
+		return c, err
+	}
+	if n > 0 {
+		c.vars = make([]Variable, n)
+	}
+	for i := range c.vars {
+		t, err := d.term()
+		if err != nil {
+			return c, err
+		}
+		v, ok := t.(Variable)
+		if !ok {
+			return c, errInvalidEncoding
+		}
+		c.vars[i] = v
+	}
+	n, err = d.length()
+	if err != nil {
+		return c, err
+	}
+	c.bytecode = make(bytecode, n)
+	for i := range c.bytecode {
+		op, err := d.byte()
+		if err != nil {
+			return c, err
+		}
+		operand, err := d.operand()
+		if err != nil {
+			return c, err
+		}
+		c.bytecode[i] = instruction{opcode: opcode(op), operand: operand}
+		if !c.bytecode[i].valid(len(c.vars)) {
+			return c, errInvalidImage
+		}
+	}
+	c.file, err = d.string()
+	return c, err
+}
+
+// valid checks if the opcode is known and the operand is of the kind the opcode expects.
+// vars is the number of the variables in the clause.
+func (i instruction) valid(vars int) bool {
+	switch i.opcode {
+	case opEnter, opExit, opPop, opCut:
+		return i.operand == nil
+	case opCall:
+		pi, ok := i.operand.(procedureIndicator)
+		return ok && pi.arity >= 0
+	case opGetFunctor, opPutFunctor:
+		pi, ok := i.operand.(procedureIndicator)
+		return ok && pi.arity > 0
+	case opGetVar, opPutVar:
+		n, ok := i.operand.(Integer)
+		return ok && n >= 0 && n < Integer(vars)
+	case opGetConst, opPutConst:
+		switch i.operand.(type) {
+		case Atom, Integer, Float, charList, codeList:
+			return true
+		default:
+			return false
+		}
+	case opGetList, opPutList, opGetPartial, opPutPartial:
+		n, ok := i.operand.(Integer)
+		return ok && n > 0
+	default:
+		return false
+	}
+}
+
+// QCompile loads the Prolog text in file and writes the image of the procedures it defines to the file with the
+// extension .qlf next to it. consult/1 and ensure_loaded/1 prefer the image to the text unless the text is newer.
+// The initialization goals in the text are part of the image and run when it's loaded.
+// Like open/4, it writes the image to the actual file system even if the text is read through vm.FS, so the image is
+// found by the following consults only if vm.FS is backed by the actual file system, e.g. os.DirFS.
+func QCompile(vm *VM, file Term, k Cont, env *Env) *Promise {
+	return Delay(func(ctx context.Context) *Promise {
+		f, b, err := vm.openText(ctx, file, []string{"", ".pl"}, env)
+		if err != nil {
+			return Error(err)
+		}
+		if err := vm.reload(ctx, f, b); err != nil {
+			return Error(err)
+		}
+
+		var buf bytes.Buffer
+		if err := vm.saveImage(&buf, []string{f}); err != nil {
+			return Error(err)
+		}
+
+		name := strings.TrimSuffix(f, ".pl") + imageExt
+		o, err := openFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		switch {
+		case err == nil:
+		case os.IsPermission(err):
+			return Error(permissionError(operationOpen, permissionTypeSourceSink, NewAtom(name), env))
+		default:
+			return Error(err)
+		}
+		if _, err := o.Write(buf.Bytes()); err != nil {
+			_ = o.Close()
+			return Error(err)
+		}
+		if err := o.Close(); err != nil {
+			return Error(err)
+		}
+		return k(env)
+	})
+}
+
+// staleImage checks if p is an image file older than its Prolog text.
+func (vm *VM) staleImage(p string) bool {
+	if path.Ext(p) != imageExt {
+		return false
+	}
+	img, err := fs.Stat(vm.FS, p)
+	if err != nil {
+		return false
+	}
+	src, err := fs.Stat(vm.FS, strings.TrimSuffix(p, imageExt)+".pl")
+	if err != nil {
+		return false
+	}
+	return src.ModTime().After(img.ModTime())
+}
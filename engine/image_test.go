@@ -0,0 +1,224 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVM_SaveImage(t *testing.T) {
+	var vm VM
+	vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+	vm.operators.define(1200, operatorSpecifierFX, atomIf)
+	vm.operators.define(1000, operatorSpecifierXFY, atomComma)
+	vm.operators.define(400, operatorSpecifierYFX, atomSlash)
+	vm.Register3(NewAtom("op"), Op)
+	vm.Register2(NewAtom("set_prolog_flag"), SetPrologFlag)
+	vm.Register2(NewAtom("char_conversion"), CharConversion)
+	assert.NoError(t, vm.Compile(context.Background(), `
+:- op(700, xfx, ===>).
+:- set_prolog_flag(double_quotes, codes).
+:- char_conversion(a, b).
+:- dynamic(baz/0).
+foo(a ===> "b", 1.5, -3).
+bar(X, [X|T], T) :- foo(X, _, _), !.
+`))
+
+	var buf bytes.Buffer
+	assert.Error(t, vm.SaveImage(&buf, "foo.pl"))
+	assert.NoError(t, vm.SaveImage(&buf))
+
+	var restored VM
+	restored.operators.define(1200, operatorSpecifierXFX, atomIf)
+	assert.NoError(t, restored.LoadImage(&buf))
+
+	assert.Equal(t, vm.operators, restored.operators)
+	assert.Equal(t, doubleQuotesCodes, restored.doubleQuotes)
+	assert.Equal(t, map[rune]rune{'a': 'b'}, restored.charConversions)
+
+	foo := procedureIndicator{name: NewAtom("foo"), arity: 3}
+	assert.Equal(t, vm.procedures[foo], restored.procedures[foo])
+	baz := procedureIndicator{name: NewAtom("baz"), arity: 0}
+	assert.True(t, restored.procedures[baz].(*userDefined).dynamic)
+
+	bar := procedureIndicator{name: NewAtom("bar"), arity: 3}
+	c := restored.procedures[bar].(*userDefined).clauses[0]
+	assert.Equal(t, vm.procedures[bar].(*userDefined).clauses[0].bytecode, c.bytecode)
+	assert.Len(t, c.vars, 4)
+	head := c.raw.(Compound).Arg(0).(Compound)
+	assert.Equal(t, head.Arg(0), head.Arg(1).(Compound).Arg(0))
+	assert.Equal(t, head.Arg(2), head.Arg(1).(Compound).Arg(1))
+
+	x, l := NewVariable(), NewVariable()
+	ok, err := Call(&restored, NewAtom("bar").Apply(x, l, atomEmptyList), func(env *Env) *Promise {
+		assert.Equal(t, vm.procedures[foo].(*userDefined).clauses[0].raw.(Compound).Arg(0), env.simplify(x))
+		assert.Equal(t, atomDot.Apply(env.simplify(x), atomEmptyList), env.simplify(l))
+		return Bool(true)
+	}, nil).Force(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestVM_LoadImage(t *testing.T) {
+	t.Run("not an image", func(t *testing.T) {
+		var vm VM
+		assert.Equal(t, errInvalidImage, vm.LoadImage(bytes.NewBufferString("foo.\n")))
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		var vm VM
+		assert.ErrorIs(t, vm.LoadImage(bytes.NewBufferString(imageMagic+"\x7f")), errInvalidImage)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		var vm VM
+		assert.NoError(t, vm.Compile(context.Background(), "foo(a).\n"))
+		var buf bytes.Buffer
+		assert.NoError(t, vm.SaveImage(&buf))
+
+		var restored VM
+		b := buf.Bytes()
+		assert.ErrorIs(t, restored.LoadImage(bytes.NewReader(b[:len(b)-1])), errInvalidImage)
+		assert.Empty(t, restored.procedures)
+	})
+
+	t.Run("invalid state", func(t *testing.T) {
+		tests := []struct {
+			title string
+			vm    func(*VM)
+			flag  int
+		}{
+			{title: "unknown", flag: 0},
+			{title: "double_quotes", flag: 1},
+			{title: "occurs_check", flag: 2},
+			{title: "operator priority", flag: -1, vm: func(vm *VM) {
+				vm.operators.define(1201, operatorSpecifierXFX, NewAtom("+++"))
+			}},
+			{title: "operator specifier", flag: -1, vm: func(vm *VM) {
+				vm.operators.define(500, operatorSpecifier(operatorClassPrefix<<2+3), NewAtom("+++"))
+			}},
+			{title: "char conversion", flag: -1, vm: func(vm *VM) {
+				vm.charConversions = map[rune]rune{'a': utf8.MaxRune + 1}
+			}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.title, func(t *testing.T) {
+				var vm VM
+				if tt.vm != nil {
+					tt.vm(&vm)
+				}
+				assert.NoError(t, vm.Compile(context.Background(), "foo(a).\n"))
+				var buf bytes.Buffer
+				assert.NoError(t, vm.SaveImage(&buf))
+				b := buf.Bytes()
+				if tt.flag >= 0 {
+					b[len(imageMagic)+1+tt.flag] = 200
+				}
+
+				var restored VM
+				assert.ErrorIs(t, restored.LoadImage(bytes.NewReader(b)), errInvalidImage)
+				assert.Empty(t, restored.procedures)
+				assert.Equal(t, unknownError, restored.unknown)
+			})
+		}
+	})
+
+	t.Run("invalid instruction", func(t *testing.T) {
+		tests := []struct {
+			title string
+			inst  instruction
+		}{
+			{title: "unknown opcode", inst: instruction{opcode: 0xff}},
+			{title: "unexpected operand", inst: instruction{opcode: opExit, operand: NewAtom("a")}},
+			{title: "procedure indicator for constant", inst: instruction{opcode: opGetConst, operand: procedureIndicator{name: NewAtom("a"), arity: 1}}},
+			{title: "atom for procedure indicator", inst: instruction{opcode: opCall, operand: NewAtom("a")}},
+			{title: "zero arity functor", inst: instruction{opcode: opPutFunctor, operand: procedureIndicator{name: NewAtom("a"), arity: 0}}},
+			{title: "negative arity", inst: instruction{opcode: opCall, operand: procedureIndicator{name: NewAtom("a"), arity: -1}}},
+			{title: "variable out of range", inst: instruction{opcode: opGetVar, operand: Integer(1)}},
+			{title: "empty list", inst: instruction{opcode: opGetList, operand: Integer(0)}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.title, func(t *testing.T) {
+				var vm VM
+				assert.NoError(t, vm.Compile(context.Background(), "foo(X).\n"))
+				u := vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined)
+				u.clauses[0].bytecode[0] = tt.inst
+				var buf bytes.Buffer
+				assert.NoError(t, vm.SaveImage(&buf))
+
+				var restored VM
+				assert.ErrorIs(t, restored.LoadImage(&buf), errInvalidImage)
+				assert.Empty(t, restored.procedures)
+			})
+		}
+	})
+}
+
+func TestQCompile(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.pl"), []byte(":- multifile(m/1).\nfoo(1).\nm(a).\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.pl"), []byte(":- multifile(m/1).\nm(b).\n"), 0644))
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	defer func() {
+		assert.NoError(t, os.Chdir(wd))
+	}()
+
+	var vm VM
+	vm.operators.define(1200, operatorSpecifierFX, atomIf)
+	vm.operators.define(400, operatorSpecifierYFX, atomSlash)
+	vm.FS = os.DirFS(".")
+
+	ok, err := Consult(&vm, NewAtom("b"), Success, nil).Force(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = QCompile(&vm, NewAtom("a"), Success, nil).Force(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, err = os.Stat("a.qlf")
+	assert.NoError(t, err)
+
+	t.Run("load image", func(t *testing.T) {
+		var vm VM
+		vm.FS = os.DirFS(".")
+		vm.doubleQuotes = doubleQuotesAtom
+		ok, err := Consult(&vm, NewAtom("a"), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Contains(t, vm.loaded, "a.qlf")
+
+		// Loading a file image keeps the flags of the VM.
+		assert.Equal(t, doubleQuotesAtom, vm.doubleQuotes)
+		assert.Len(t, vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined).clauses, 1)
+
+		// The image contains only the clauses of the multifile procedure from a.pl.
+		m := vm.procedures[procedureIndicator{name: NewAtom("m"), arity: 1}].(*userDefined)
+		assert.Len(t, m.clauses, 1)
+		assert.Equal(t, NewAtom("m").Apply(NewAtom("a")), m.clauses[0].raw)
+	})
+
+	t.Run("stale image", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile("a.pl", []byte("foo(2).\n"), 0644))
+		assert.NoError(t, os.Chtimes("a.pl", time.Now().Add(time.Hour), time.Now().Add(time.Hour)))
+
+		var vm VM
+		vm.operators.define(1200, operatorSpecifierFX, atomIf)
+		vm.FS = os.DirFS(".")
+		ok, err := Consult(&vm, NewAtom("a"), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Contains(t, vm.loaded, "a.pl")
+		assert.Equal(t, NewAtom("foo").Apply(Integer(2)), vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined).clauses[0].raw)
+	})
+}
@@ -17,6 +17,16 @@ type Lexer struct {
 
 	buf    bytes.Buffer
 	offset int
+
+	// pos is where the next rune is. start is where the last token began.
+	pos, start textPosition
+	prev       [4]textPosition // for backup
+	nprev      int
+
+	// If keepComments is true, comments are recorded in comments.
+	keepComments bool
+	comments     []comment
+	comment      strings.Builder
 }
 
 // Token returns the next token.
@@ -25,6 +35,48 @@ func (l *Lexer) Token() (Token, error) {
 	return l.layoutTextSequence(false)
 }
 
+// Position is a location in a Prolog text.
+type Position struct {
+	Offset int // Offset in characters, starting at 0.
+	Line   int // Line number, starting at 1.
+	Column int // Column number in characters, starting at 1.
+}
+
+// Term returns a term position(Line, Column, Offset).
+func (p Position) Term() Term {
+	return atomPosition.Apply(Integer(p.Line), Integer(p.Column), Integer(p.Offset))
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// textPosition is a 0-based location in a Prolog text so that the zero value is the beginning of the text.
+type textPosition struct {
+	offset, line, column int
+}
+
+func (p *textPosition) advance(r rune) {
+	p.offset++
+	if r == '\n' {
+		p.line++
+		p.column = 0
+		return
+	}
+	p.column++
+}
+
+// Position returns the position with 1-based line and column numbers.
+func (p textPosition) Position() Position {
+	return Position{Offset: p.offset, Line: p.line + 1, Column: p.column + 1}
+}
+
+// comment is a comment in a Prolog text.
+type comment struct {
+	pos  Position
+	text string
+}
+
 func (l *Lexer) next() (rune, error) {
 	r, err := l.rawNext()
 	if err != nil {
@@ -35,7 +87,13 @@ func (l *Lexer) next() (rune, error) {
 
 func (l *Lexer) rawNext() (rune, error) {
 	r, _, err := l.input.ReadRune()
-	return r, err
+	if err != nil {
+		return r, err
+	}
+	l.prev[l.nprev%len(l.prev)] = l.pos
+	l.nprev++
+	l.pos.advance(r)
+	return r, nil
 }
 
 func (l *Lexer) conv(r rune) rune {
@@ -47,6 +105,8 @@ func (l *Lexer) conv(r rune) rune {
 
 func (l *Lexer) backup() {
 	_ = l.input.UnreadRune()
+	l.nprev--
+	l.pos = l.prev[l.nprev%len(l.prev)]
 }
 
 func (l *Lexer) accept(r rune) {
@@ -233,6 +293,7 @@ func (l *Lexer) wasEndChar() bool {
 
 func (l *Lexer) layoutTextSequence(afterLayout bool) (Token, error) {
 	for {
+		l.start = l.pos
 		switch r, err := l.next(); {
 		case err == io.EOF:
 			return l.token(afterLayout)
@@ -242,6 +303,7 @@ func (l *Lexer) layoutTextSequence(afterLayout bool) (Token, error) {
 			afterLayout = true
 			continue
 		case r == '%':
+			l.beginComment("%")
 			return l.commentText(false)
 		case r == '/':
 			return l.commentOpen()
@@ -259,16 +321,25 @@ func (l *Lexer) commentText(bracketed bool) (Token, error) {
 			case err != nil:
 				return Token{}, err
 			case r == '*':
+				l.acceptComment(r)
 				return l.commentClose()
+			default:
+				l.acceptComment(r)
 			}
 		}
 	} else {
 		for {
 			switch r, err := l.next(); {
+			case err == io.EOF:
+				l.endComment()
+				return Token{}, err
 			case err != nil:
 				return Token{}, err
 			case r == '\n':
+				l.endComment()
 				return l.layoutTextSequence(true)
+			default:
+				l.acceptComment(r)
 			}
 		}
 	}
@@ -282,6 +353,7 @@ func (l *Lexer) commentOpen() (Token, error) {
 	case err != nil:
 		return Token{}, err
 	case r == '*':
+		l.beginComment("/*")
 		return l.commentText(true)
 	default:
 		l.backup()
@@ -295,12 +367,37 @@ func (l *Lexer) commentClose() (Token, error) {
 	case err != nil:
 		return Token{}, err
 	case r == '/':
+		l.acceptComment(r)
+		l.endComment()
 		return l.layoutTextSequence(true)
 	default:
+		l.backup()
 		return l.commentText(true)
 	}
 }
 
+func (l *Lexer) beginComment(s string) {
+	if !l.keepComments {
+		return
+	}
+	l.comment.Reset()
+	_, _ = l.comment.WriteString(s)
+}
+
+func (l *Lexer) acceptComment(r rune) {
+	if !l.keepComments {
+		return
+	}
+	_, _ = l.comment.WriteRune(r)
+}
+
+func (l *Lexer) endComment() {
+	if !l.keepComments {
+		return
+	}
+	l.comments = append(l.comments, comment{pos: l.start.Position(), text: l.comment.String()})
+}
+
 //// Names
 
 func (l *Lexer) letterDigitToken() (Token, error) {
@@ -217,6 +217,35 @@ func (n noMonkeyReader) ReadRune() (rune, int, error) {
 	return r, size, err
 }
 
+func TestLexer_position(t *testing.T) {
+	l := Lexer{
+		input:        newRuneRingBuffer(strings.NewReader("foo\n  bar % c\n/** x **/ baz.")),
+		keepComments: true,
+	}
+
+	tests := []struct {
+		token    Token
+		from, to Position
+	}{
+		{token: Token{kind: tokenLetterDigit, val: "foo"}, from: Position{Offset: 0, Line: 1, Column: 1}, to: Position{Offset: 3, Line: 1, Column: 4}},
+		{token: Token{kind: tokenLetterDigit, val: "bar"}, from: Position{Offset: 6, Line: 2, Column: 3}, to: Position{Offset: 9, Line: 2, Column: 6}},
+		{token: Token{kind: tokenLetterDigit, val: "baz"}, from: Position{Offset: 24, Line: 3, Column: 11}, to: Position{Offset: 27, Line: 3, Column: 14}},
+		{token: Token{kind: tokenEnd, val: "."}, from: Position{Offset: 27, Line: 3, Column: 14}, to: Position{Offset: 28, Line: 3, Column: 15}},
+	}
+	for _, tt := range tests {
+		token, err := l.Token()
+		assert.NoError(t, err)
+		assert.Equal(t, tt.token, token)
+		assert.Equal(t, tt.from, l.start.Position())
+		assert.Equal(t, tt.to, l.pos.Position())
+	}
+
+	assert.Equal(t, []comment{
+		{pos: Position{Offset: 10, Line: 2, Column: 7}, text: "% c"},
+		{pos: Position{Offset: 14, Line: 3, Column: 1}, text: "/** x **/"},
+	}, l.comments)
+}
+
 func TestTokenKind_GoString(t *testing.T) {
 	assert.Equal(t, "invalid", tokenInvalid.GoString())
 }
@@ -4,11 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -26,10 +29,26 @@ type Parser struct {
 
 	Vars []ParsedVariable
 
-	placeholder Atom
-	args        []Term
-
-	buf tokenRingBuffer
+	placeholder    Atom
+	args           []Term
+	argsErr        error      // raised when a positional placeholder needs args which failed to convert
+	named          *namedArgs // the arguments for named placeholders
+	namedErr       error      // raised when a named placeholder needs args which failed to convert
+	deferred       bool       // placeholders are replaced by fresh variables
+	usedPositional bool
+	usedNamed      bool
+
+	// Placeholders are the variables which replaced the placeholders. See SetPlaceholderVariables.
+	Placeholders Placeholders
+
+	buf      tokenRingBuffer
+	spans    []tokenSpan // where the tokens of the current term are
+	consumed int         // the number of the tokens consumed in the current term
+	depth    int
+
+	// If keepPositions is true, the parser records where the subterms are.
+	keepPositions bool
+	positions     []subtermPosition
 }
 
 // ParsedVariable is a set of information regarding a variable in a parsed term.
@@ -44,36 +63,116 @@ func NewParser(vm *VM, r io.RuneReader) *Parser {
 	if vm.operators == nil {
 		vm.operators = operators{}
 	}
-	return &Parser{
+	p := Parser{
 		lexer: Lexer{
 			input: newRuneRingBuffer(r),
 		},
 		operators:    vm.operators,
 		doubleQuotes: vm.doubleQuotes,
 	}
+	if s, ok := r.(*Stream); ok {
+		p.lexer.pos = s.textPos
+	}
+	return &p
 }
 
 // SetPlaceholder registers placeholder and its arguments. Every occurrence of placeholder will be replaced by arguments.
 // Mismatch of the number of occurrences of placeholder and the number of arguments raises an error.
+// If the only argument is a map with string keys or a struct, named placeholders such as :user or ?{user} are
+// replaced by its values or fields instead. A name without a value or a value without a name raises an error.
 func (p *Parser) SetPlaceholder(placeholder Atom, args ...interface{}) error {
 	p.placeholder = placeholder
 	p.args = make([]Term, len(args))
+	p.argsErr, p.named, p.namedErr = nil, nil, nil
 	for i, a := range args {
-		var err error
-		p.args[i], err = termOf(reflect.ValueOf(a))
-		if err != nil {
-			return err
+		p.args[i], p.argsErr = termOf(reflect.ValueOf(a))
+		if p.argsErr != nil {
+			break
+		}
+	}
+	if len(args) == 1 && isNamedArgs(args[0]) {
+		p.named, p.namedErr = newNamedArgs(args[0])
+		if p.namedErr == nil {
+			return nil
 		}
 	}
-	return nil
+	return p.argsErr
 }
 
+// SetPlaceholderVariables registers placeholder. Every occurrence of placeholder will be replaced by a fresh variable
+// which is recorded in Placeholders so that its argument can be bound later.
+func (p *Parser) SetPlaceholderVariables(placeholder Atom) {
+	p.placeholder = placeholder
+	p.args = nil
+	p.deferred = true
+}
+
+// TermOf converts a Go value into a term in the same way as the arguments for placeholders.
+func TermOf(v interface{}) (Term, error) {
+	return termOf(reflect.ValueOf(v))
+}
+
+// TermMarshaler is the interface implemented by types that can convert themselves into terms.
+type TermMarshaler interface {
+	MarshalTerm() (Term, error)
+}
+
+// TermUnmarshaler is the interface implemented by types that can convert terms into themselves.
+type TermUnmarshaler interface {
+	UnmarshalTerm(t Term, env *Env) error
+}
+
+var (
+	termType          = reflect.TypeOf((*Term)(nil)).Elem()
+	termMarshalerType = reflect.TypeOf((*TermMarshaler)(nil)).Elem()
+	timeType          = reflect.TypeOf(time.Time{})
+)
+
 func termOf(o reflect.Value) (Term, error) {
+	return termOfDepth(o, 0)
+}
+
+// termOfDepth converts a Go value nested at depth to a term.
+// The limit of the depth stops a self-referential value, e.g. a pointer to a struct which points back to itself.
+func termOfDepth(o reflect.Value, depth int) (Term, error) {
+	if depth > maxTermDepth {
+		return nil, &ResourceErr{Resource: atomTermDepth}
+	}
+	depth++
+
+	if !o.IsValid() {
+		return nil, fmt.Errorf("can't convert to term: %v", o)
+	}
+
+	switch t := o.Type(); {
+	case t.Kind() == reflect.Interface:
+		break
+	case t.Implements(termMarshalerType):
+		if o.Kind() == reflect.Ptr && o.IsNil() {
+			return NewVariable(), nil
+		}
+		return o.Interface().(TermMarshaler).MarshalTerm()
+	case o.CanAddr() && reflect.PtrTo(t).Implements(termMarshalerType):
+		return o.Addr().Interface().(TermMarshaler).MarshalTerm()
+	case t.Implements(termType):
+		return o.Interface().(Term), nil
+	case t == timeType:
+		// A time is a float number of seconds since the epoch as get_time/1 of other Prolog processors.
+		// Around the current date, a float keeps only microsecond precision.
+		tm := o.Interface().(time.Time)
+		return Float(float64(tm.UnixNano()) / float64(time.Second)), nil
+	}
+
 	switch o.Kind() {
 	case reflect.Float32, reflect.Float64:
 		return Float(o.Float()), nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return Integer(o.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if o.Uint() > math.MaxInt64 {
+			return nil, &RepresentationErr{Flag: atomMaxInteger}
+		}
+		return Integer(o.Uint()), nil
 	case reflect.String:
 		return NewAtom(o.String()), nil
 	case reflect.Array, reflect.Slice:
@@ -81,17 +180,135 @@ func termOf(o reflect.Value) (Term, error) {
 		es := make([]Term, l)
 		for i := 0; i < l; i++ {
 			var err error
-			es[i], err = termOf(o.Index(i))
+			if o.Type().Elem().Kind() == reflect.Uint8 {
+				// A []byte is a list of bytes.
+				es[i] = Integer(o.Index(i).Uint())
+				continue
+			}
+			es[i], err = termOfDepth(o.Index(i), depth)
 			if err != nil {
 				return nil, err
 			}
 		}
 		return List(es...), nil
+	case reflect.Map:
+		return pairsOf(o, depth)
+	case reflect.Ptr, reflect.Interface:
+		if o.IsNil() {
+			return NewVariable(), nil
+		}
+		return termOfDepth(o.Elem(), depth)
+	case reflect.Struct:
+		return compoundOf(o, depth)
 	default:
 		return nil, fmt.Errorf("can't convert to term: %v", o)
 	}
 }
 
+// pairsOf converts a map to a list of pairs Key-Value in the standard order of the keys.
+func pairsOf(o reflect.Value, depth int) (Term, error) {
+	ps := make([]Term, 0, o.Len())
+	iter := o.MapRange()
+	for iter.Next() {
+		k, err := termOfDepth(iter.Key(), depth)
+		if err != nil {
+			return nil, err
+		}
+		v, err := termOfDepth(iter.Value(), depth)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, atomMinus.Apply(k, v))
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].(Compound).Arg(0).Compare(ps[j].(Compound).Arg(0), nil) < 0
+	})
+	return List(ps...), nil
+}
+
+// compoundOf converts a struct to a compound as specified by the tag of its blank field, e.g.
+//
+//	type Person struct {
+//		_    struct{} `prolog:"person(name,age)"`
+//		Name string
+//		Age  int
+//	}
+//
+// The arguments refer to the fields by their prolog tags or their names in a case-insensitive manner.
+func compoundOf(o reflect.Value, depth int) (Term, error) {
+	name, fields, err := StructFields(o.Type())
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return NewAtom(name), nil
+	}
+	args := make([]Term, len(fields))
+	for i, f := range fields {
+		args[i], err = termOfDepth(o.Field(f), depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewAtom(name).Apply(args...), nil
+}
+
+// StructFields returns the functor name and the indices of the fields for the arguments of the compound which
+// corresponds to the struct type t. The compound is specified by the tag of its blank field, e.g. `prolog:"point(x,y)"`,
+// in which an argument names a field by its prolog tag or, case-insensitively, by its name.
+func StructFields(t reflect.Type) (string, []int, error) {
+	var tag string
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Name == "_" {
+			tag = f.Tag.Get("prolog")
+			break
+		}
+	}
+	if tag == "" {
+		return "", nil, fmt.Errorf("no compound tag: %v", t)
+	}
+
+	name, args, ok := strings.Cut(tag, "(")
+	if !ok {
+		return name, nil, nil
+	}
+	if !strings.HasSuffix(args, ")") {
+		return "", nil, fmt.Errorf("invalid compound tag: %s", tag)
+	}
+	args = strings.TrimSuffix(args, ")")
+
+	var fields []int
+	for _, a := range strings.Split(args, ",") {
+		a = strings.TrimSpace(a)
+		i := fieldIndex(t, a)
+		if i < 0 {
+			return "", nil, fmt.Errorf("unknown field %s in compound tag: %s", a, tag)
+		}
+		fields = append(fields, i)
+	}
+	return name, fields, nil
+}
+
+// fieldIndex returns the index of the exported field of the struct type t named by its prolog tag or its name, or -1.
+func fieldIndex(t reflect.Type, name string) int {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Name == "_" || f.PkgPath != "" {
+			continue
+		}
+		if alias, ok := f.Tag.Lookup("prolog"); ok {
+			if alias == name {
+				return i
+			}
+			continue
+		}
+		if strings.EqualFold(f.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
 func (p *Parser) next() (Token, error) {
 	if p.buf.empty() {
 		t, err := p.lexer.Token()
@@ -99,12 +316,15 @@ func (p *Parser) next() (Token, error) {
 			return Token{}, err
 		}
 		p.buf.put(t)
+		p.spans = append(p.spans, tokenSpan{from: p.lexer.start, to: p.lexer.pos})
 	}
+	p.consumed++
 	return p.buf.get(), nil
 }
 
 func (p *Parser) backup() {
 	p.buf.backup()
+	p.consumed--
 }
 
 func (p *Parser) current() Token {
@@ -113,12 +333,18 @@ func (p *Parser) current() Token {
 
 // Term parses a term followed by a full stop.
 func (p *Parser) Term() (Term, error) {
+	// Forget the tokens of the previous term but keep the ones we've peeked.
+	if p.consumed > 0 {
+		p.spans, p.consumed = p.spans[p.consumed:], 0
+	}
+	p.positions = p.positions[:0]
+
 	t, err := p.term(1201)
 	switch err {
 	case nil:
 		break
 	case errExpectation:
-		return nil, unexpectedTokenError{actual: p.current()}
+		return nil, p.syntaxError(unexpectedTokenError{actual: p.current()})
 	default:
 		return nil, err
 	}
@@ -128,16 +354,64 @@ func (p *Parser) Term() (Term, error) {
 		break
 	default:
 		p.backup()
-		return nil, unexpectedTokenError{actual: p.current()}
+		return nil, p.syntaxError(unexpectedTokenError{actual: p.current()})
 	}
 
-	if len(p.args) != 0 {
+	if len(p.args) != 0 && !p.usedNamed {
+		if p.argsErr != nil {
+			return nil, p.argsErr
+		}
 		return nil, fmt.Errorf("too many arguments for placeholders: %s", p.args)
 	}
 
+	// The arguments for named placeholders are shared among the terms. So we check them after the last one.
+	if p.usedNamed && !p.deferred && !p.More() {
+		if err := p.named.unused(); err != nil {
+			return nil, err
+		}
+	}
+
 	return t, nil
 }
 
+// resync skips the tokens until the end token so that it can read the next term after an error.
+func (p *Parser) resync() error {
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		if t.kind == tokenEnd {
+			return nil
+		}
+	}
+}
+
+// syntaxError returns err with the position of the token to be read next.
+func (p *Parser) syntaxError(err error) error {
+	pos := p.lexer.pos
+	if p.consumed >= 0 && p.consumed < len(p.spans) {
+		pos = p.spans[p.consumed].from
+	}
+	return &SyntaxErr{Position: pos.Position(), Err: err}
+}
+
+// termPosition returns where the last term began.
+func (p *Parser) termPosition() Position {
+	if len(p.spans) == 0 {
+		return p.lexer.pos.Position()
+	}
+	return p.spans[0].from.Position()
+}
+
+// subtermPositions returns the layout of the last term.
+func (p *Parser) subtermPositions() Term {
+	if len(p.positions) == 0 {
+		return nil
+	}
+	return p.positions[0].layout
+}
+
 // Number parses a number term.
 func (p *Parser) number() (Number, error) {
 	var (
@@ -338,44 +612,107 @@ func (d doubleQuotes) String() string {
 }
 
 // Loosely based on Pratt parser explained in this article: https://matklad.github.io/2020/04/13/simple-but-powerful-pratt-parsing.html
+// The operands of operators are parsed with an explicit stack so that long operator chains, e.g. a, b, c, ..., don't
+// overflow the call stack. Only parentheses, arguments, and the like nest the calls.
 func (p *Parser) term(maxPriority Integer) (Term, error) {
-	var lhs Term
-	switch op, err := p.prefix(maxPriority); err {
-	case nil:
-		_, rbp := op.bindingPriorities()
-		t, err := p.term(rbp)
-		if err != nil {
-			p.backup()
-			return p.term0(maxPriority)
-		}
-		lhs = op.name.Apply(t)
-	case errNoOp:
-		lhs, err = p.term0(maxPriority)
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, err
+	if p.depth >= maxTermDepth {
+		return nil, errMaxTermDepth
 	}
+	p.depth++
+	defer func() {
+		p.depth--
+	}()
 
+	var (
+		stack = []termFrame{{maxPriority: maxPriority}}
+		t     Term  // the result of the last finished frame
+		err   error // the error of the last finished frame
+	)
 	for {
-		op, err := p.infix(maxPriority)
-		if err != nil {
-			break
+		f := &stack[len(stack)-1]
+		switch f.state {
+		case termFrameStart:
+			f.m, f.saved = p.consumed, len(p.positions)
+			var op operator
+			switch op, err = p.prefix(f.maxPriority); err {
+			case nil:
+				_, rbp := op.bindingPriorities()
+				f.op, f.state = op, termFramePrefixOperand
+				stack = append(stack, termFrame{maxPriority: rbp})
+				continue
+			case errNoOp:
+				if f.lhs, err = p.term0(f.maxPriority); err == nil {
+					f.state = termFrameInfix
+					continue
+				}
+			}
+		case termFramePrefixOperand:
+			switch err {
+			case nil:
+				f.lhs = f.op.name.Apply(t)
+				p.operatorPosition(f.m, 1)
+				f.state = termFrameInfix
+				continue
+			case errMaxTermDepth:
+			default:
+				// The prefix operator is an atom.
+				p.positions = p.positions[:f.saved]
+				p.backup()
+				t, err = p.term0(f.maxPriority)
+			}
+		case termFrameInfix:
+			var op operator
+			if op, err = p.infix(f.maxPriority); err != nil {
+				t, err = f.lhs, nil
+				break
+			}
+			i := p.consumed - 1
+			switch _, rbp := op.bindingPriorities(); {
+			case rbp > 1200:
+				f.lhs = op.name.Apply(f.lhs)
+				p.operatorPosition(i, 1)
+			default:
+				f.op, f.i, f.state = op, i, termFrameInfixOperand
+				stack = append(stack, termFrame{maxPriority: rbp})
+			}
+			continue
+		case termFrameInfixOperand:
+			if err == nil {
+				f.lhs = f.op.name.Apply(f.lhs, t)
+				p.operatorPosition(f.i, 2)
+				f.state = termFrameInfix
+				continue
+			}
 		}
-		switch _, rbp := op.bindingPriorities(); {
-		case rbp > 1200:
-			lhs = op.name.Apply(lhs)
-		default:
-			rhs, err := p.term(rbp)
+
+		// The frame is finished with either t or err.
+		stack = stack[:len(stack)-1]
+		if len(stack) == 0 {
 			if err != nil {
 				return nil, err
 			}
-			lhs = op.name.Apply(lhs, rhs)
+			return t, nil
 		}
 	}
+}
 
-	return lhs, nil
+type termFrameState int8
+
+const (
+	termFrameStart termFrameState = iota
+	termFramePrefixOperand
+	termFrameInfix
+	termFrameInfixOperand
+)
+
+// termFrame is a frame of the explicit stack of Parser.term which parses a term of maxPriority.
+type termFrame struct {
+	maxPriority Integer
+	state       termFrameState
+	m, saved    int      // where the term begins in the tokens and the positions.
+	i           int      // where the infix operator is in the tokens.
+	op          operator // the prefix or infix operator waiting for its operand.
+	lhs         Term
 }
 
 func (p *Parser) prefix(maxPriority Integer) (operator, error) {
@@ -485,14 +822,18 @@ func (p *Parser) term0(maxPriority Integer) (Term, error) {
 	if err != nil {
 		return nil, err
 	}
+	m := p.consumed - 1
 	switch t.kind {
 	case tokenOpen, tokenOpenCT:
-		return p.openClose()
+		return p.openClose(m)
 	case tokenInteger:
+		p.leafPosition(m)
 		return integer(1, t.val)
 	case tokenFloatNumber:
+		p.leafPosition(m)
 		return float(1, t.val)
 	case tokenVariable:
+		p.leafPosition(m)
 		return p.variable(t.val)
 	case tokenOpenList:
 		if t, _ := p.next(); t.kind == tokenCloseList {
@@ -501,7 +842,7 @@ func (p *Parser) term0(maxPriority Integer) (Term, error) {
 			break
 		}
 		p.backup()
-		return p.list()
+		return p.list(m)
 	case tokenOpenCurly:
 		if t, _ := p.next(); t.kind == tokenCloseCurly {
 			p.backup()
@@ -509,12 +850,14 @@ func (p *Parser) term0(maxPriority Integer) (Term, error) {
 			break
 		}
 		p.backup()
-		return p.curlyBracketedTerm()
+		return p.curlyBracketedTerm(m)
 	case tokenDoubleQuotedList:
 		switch p.doubleQuotes {
 		case doubleQuotesChars:
+			p.wrapPosition(m, 0, atomStringPosition)
 			return CharList(unDoubleQuote(t.val)), nil
 		case doubleQuotesCodes:
+			p.wrapPosition(m, 0, atomStringPosition)
 			return CodeList(unDoubleQuote(t.val)), nil
 		default:
 			p.backup()
@@ -528,11 +871,18 @@ func (p *Parser) term0(maxPriority Integer) (Term, error) {
 }
 
 func (p *Parser) term0Atom(maxPriority Integer) (Term, error) {
+	m := p.consumed
 	a, err := p.atom()
 	if err != nil {
 		return nil, err
 	}
 
+	if p.placeholder != 0 {
+		if name, ok := p.namedPlaceholder(a); ok {
+			return p.namedPlaceholderArg(name)
+		}
+	}
+
 	if a == atomMinus {
 		t, err := p.next()
 		if err != nil {
@@ -540,15 +890,17 @@ func (p *Parser) term0Atom(maxPriority Integer) (Term, error) {
 		}
 		switch t.kind {
 		case tokenInteger:
+			p.leafPosition(m)
 			return integer(-1, t.val)
 		case tokenFloatNumber:
+			p.leafPosition(m)
 			return float(-1, t.val)
 		default:
 			p.backup()
 		}
 	}
 
-	t, err := p.functionalNotation(a)
+	t, err := p.functionalNotation(a, m)
 	if err != nil {
 		return nil, err
 	}
@@ -560,6 +912,18 @@ func (p *Parser) term0Atom(maxPriority Integer) (Term, error) {
 	}
 
 	if p.placeholder != 0 && t == p.placeholder {
+		if p.usedNamed {
+			return nil, errMixedPlaceholders
+		}
+		p.usedPositional = true
+		if p.deferred {
+			v := NewVariable()
+			p.Placeholders.Positional = append(p.Placeholders.Positional, v)
+			return v, nil
+		}
+		if p.argsErr != nil {
+			return nil, p.argsErr
+		}
 		if len(p.args) == 0 {
 			return nil, errPlaceholder
 		}
@@ -569,6 +933,68 @@ func (p *Parser) term0Atom(maxPriority Integer) (Term, error) {
 	return t, nil
 }
 
+// namedPlaceholder reads the name of a named placeholder which begins with a, i.e. :name or ?{name} if the
+// placeholder is ?.
+func (p *Parser) namedPlaceholder(a Atom) (string, bool) {
+	var kinds []tokenKind
+	switch a {
+	case atomColon:
+		kinds = []tokenKind{tokenLetterDigit}
+	case p.placeholder:
+		kinds = []tokenKind{tokenOpenCurly, tokenLetterDigit, tokenCloseCurly}
+	default:
+		return "", false
+	}
+
+	var name string
+	for i, k := range kinds {
+		t, err := p.next()
+		if err == nil && (t.kind == k || (k == tokenLetterDigit && t.kind == tokenVariable)) {
+			if k == tokenLetterDigit {
+				name = t.val
+			}
+			continue
+		}
+		if err == nil {
+			i++
+		}
+		for ; i > 0; i-- {
+			p.backup()
+		}
+		return "", false
+	}
+	return name, true
+}
+
+// namedPlaceholderArg returns the argument for the named placeholder.
+func (p *Parser) namedPlaceholderArg(name string) (Term, error) {
+	if p.usedPositional {
+		return nil, errMixedPlaceholders
+	}
+	p.usedNamed = true
+
+	if p.deferred {
+		n := NewAtom(name)
+		for i, pv := range p.Placeholders.Named {
+			if pv.Name == n {
+				p.Placeholders.Named[i].Count++
+				return pv.Variable, nil
+			}
+		}
+		v := NewVariable()
+		p.Placeholders.Named = append(p.Placeholders.Named, ParsedVariable{Name: n, Variable: v, Count: 1})
+		return v, nil
+	}
+
+	if p.namedErr != nil {
+		return nil, p.namedErr
+	}
+	if p.named == nil {
+		return nil, fmt.Errorf("no argument for named placeholder: %s", name)
+	}
+	return p.named.lookup(name)
+}
+
 func (p *Parser) variable(s string) (Term, error) {
 	if s == "_" {
 		return NewVariable(), nil
@@ -585,7 +1011,7 @@ func (p *Parser) variable(s string) (Term, error) {
 	return v, nil
 }
 
-func (p *Parser) openClose() (Term, error) {
+func (p *Parser) openClose(m int) (Term, error) {
 	t, err := p.term(1201)
 	if err != nil {
 		return nil, err
@@ -594,6 +1020,7 @@ func (p *Parser) openClose() (Term, error) {
 		p.backup()
 		return nil, errExpectation
 	}
+	p.wrapPosition(m, 1, atomParenthesesTermPosition)
 	return t, nil
 }
 
@@ -663,7 +1090,7 @@ func (p *Parser) name() (Atom, error) {
 	}
 }
 
-func (p *Parser) list() (Term, error) {
+func (p *Parser) list(m int) (Term, error) {
 	arg, err := p.arg()
 	if err != nil {
 		return nil, err
@@ -685,6 +1112,7 @@ func (p *Parser) list() (Term, error) {
 
 			switch t, _ := p.next(); t.kind {
 			case tokenCloseList:
+				p.listPosition(m, len(args), true)
 				if len(args) == 1 {
 					return Cons(args[0], rest), nil
 				}
@@ -694,6 +1122,7 @@ func (p *Parser) list() (Term, error) {
 				return nil, errExpectation
 			}
 		case tokenCloseList:
+			p.listPosition(m, len(args), false)
 			return List(args...), nil
 		default:
 			p.backup()
@@ -702,7 +1131,7 @@ func (p *Parser) list() (Term, error) {
 	}
 }
 
-func (p *Parser) curlyBracketedTerm() (Term, error) {
+func (p *Parser) curlyBracketedTerm(m int) (Term, error) {
 	t, err := p.term(1201)
 	if err != nil {
 		return nil, err
@@ -712,11 +1141,13 @@ func (p *Parser) curlyBracketedTerm() (Term, error) {
 		p.backup()
 		return nil, errExpectation
 	}
+	p.wrapPosition(m, 1, atomBraceTermPosition)
 
 	return atomEmptyBlock.Apply(t), nil
 }
 
-func (p *Parser) functionalNotation(functor Atom) (Term, error) {
+func (p *Parser) functionalNotation(functor Atom, m int) (Term, error) {
+	f := p.consumed
 	switch t, _ := p.next(); t.kind {
 	case tokenOpenCT:
 		arg, err := p.arg()
@@ -733,6 +1164,7 @@ func (p *Parser) functionalNotation(functor Atom) (Term, error) {
 				}
 				args = append(args, arg)
 			case tokenClose:
+				p.compoundPosition(m, f, len(args))
 				return functor.Apply(args...), nil
 			default:
 				p.backup()
@@ -741,17 +1173,20 @@ func (p *Parser) functionalNotation(functor Atom) (Term, error) {
 		}
 	default:
 		p.backup()
+		p.leafPosition(m)
 		return functor, nil
 	}
 }
 
 func (p *Parser) arg() (Term, error) {
+	m := p.consumed
 	if arg, err := p.atom(); err == nil {
 		if p.operators.defined(arg) {
 			// Check if this atom is not followed by its own arguments.
 			switch t, _ := p.next(); t.kind {
 			case tokenComma, tokenClose, tokenBar, tokenCloseList:
 				p.backup()
+				p.leafPosition(m)
 				return arg, nil
 			default:
 				p.backup()
@@ -936,6 +1371,124 @@ func (b *tokenRingBuffer) backup() {
 	}
 }
 
+// tokenSpan is where a token is in the text.
+type tokenSpan struct {
+	from, to textPosition
+}
+
+// subtermPosition is where a subterm is in the text.
+type subtermPosition struct {
+	from, to int
+	layout   Term // e.g. From-To, term_position(From, To, FFrom, FTo, ArgsPos)
+}
+
+func (p *Parser) pushPosition(from, to int, layout Term) {
+	p.positions = append(p.positions, subtermPosition{from: from, to: to, layout: layout})
+}
+
+// popPositions removes the positions of the last n subterms and returns their layouts.
+func (p *Parser) popPositions(n int) []Term {
+	ps := p.positions[len(p.positions)-n:]
+	ls := make([]Term, n)
+	for i, s := range ps {
+		ls[i] = s.layout
+	}
+	p.positions = p.positions[:len(p.positions)-n]
+	return ls
+}
+
+// span returns the character offsets from the token at m to the last consumed token.
+func (p *Parser) span(m int) (int, int) {
+	return p.spans[m].from.offset, p.spans[p.consumed-1].to.offset
+}
+
+// leafPosition records From-To of a primitive term which begins with the token at m.
+func (p *Parser) leafPosition(m int) {
+	if !p.keepPositions {
+		return
+	}
+	from, to := p.span(m)
+	p.pushPosition(from, to, pair(Integer(from), Integer(to)))
+}
+
+// wrapPosition records name(From, To, Args...) of a term which begins with the token at m and has n subterms.
+func (p *Parser) wrapPosition(m, n int, name Atom) {
+	if !p.keepPositions {
+		return
+	}
+	from, to := p.span(m)
+	args := append([]Term{Integer(from), Integer(to)}, p.popPositions(n)...)
+	p.pushPosition(from, to, name.Apply(args...))
+}
+
+// compoundPosition records term_position(From, To, FFrom, FTo, ArgsPos) of a compound in functional notation.
+// The functor is from the token at m to the one before f.
+func (p *Parser) compoundPosition(m, f, n int) {
+	if !p.keepPositions {
+		return
+	}
+	from, to := p.span(m)
+	fFrom, fTo := p.spans[m].from.offset, p.spans[f-1].to.offset
+	p.pushPosition(from, to, atomTermPosition.Apply(Integer(from), Integer(to), Integer(fFrom), Integer(fTo), List(p.popPositions(n)...)))
+}
+
+// operatorPosition records term_position(From, To, OpFrom, OpTo, ArgsPos) of an operator term.
+// The operator is the token at i.
+func (p *Parser) operatorPosition(i, n int) {
+	if !p.keepPositions {
+		return
+	}
+	from, to := p.spans[i].from.offset, p.spans[i].to.offset
+	opFrom, opTo := from, to
+	for _, s := range p.positions[len(p.positions)-n:] {
+		if s.from < from {
+			from = s.from
+		}
+		if s.to > to {
+			to = s.to
+		}
+	}
+	p.pushPosition(from, to, atomTermPosition.Apply(Integer(from), Integer(to), Integer(opFrom), Integer(opTo), List(p.popPositions(n)...)))
+}
+
+// listPosition records list_position(From, To, Elems, Tail) of a list which begins with the token at m.
+// Tail is none if the list doesn't have a tail.
+func (p *Parser) listPosition(m, n int, tail bool) {
+	if !p.keepPositions {
+		return
+	}
+	from, to := p.span(m)
+	var t Term = atomNone
+	if tail {
+		t = p.popPositions(1)[0]
+	}
+	p.pushPosition(from, to, atomListPosition.Apply(Integer(from), Integer(to), List(p.popPositions(n)...), t))
+}
+
+// SyntaxErr is an error in a Prolog text with where it's found.
+type SyntaxErr struct {
+	Position Position
+	Err      error
+}
+
+func (e *SyntaxErr) Error() string {
+	return fmt.Sprintf("%s: %v", e.Position, e.Err)
+}
+
+func (e *SyntaxErr) Unwrap() error {
+	return e.Err
+}
+
+// Term returns error(syntax_error(Description), position(Line, Column, Offset)).
+// If the position is unknown, the context is the one in which the error is raised.
+func (e *SyntaxErr) Term() Term {
+	var ctx Term
+	if e.Position != (Position{}) {
+		ctx = e.Position.Term()
+	}
+	return errorTerm(atomSyntaxError.Apply(NewAtom(e.Err.Error())), ctx)
+}
+
 type unexpectedTokenError struct {
 	actual Token
 }
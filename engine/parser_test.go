@@ -1,9 +1,13 @@
 package engine
 
 import (
+	"errors"
 	"io"
+	"math"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -26,10 +30,10 @@ func TestParser_Term(t *testing.T) {
 	}{
 		{input: ``, err: io.EOF},
 		{input: `foo`, err: io.EOF},
-		{input: `.`, err: unexpectedTokenError{actual: Token{kind: tokenEnd, val: "."}}},
+		{input: `.`, err: &SyntaxErr{Position: Position{Offset: 0, Line: 1, Column: 1}, Err: unexpectedTokenError{actual: Token{kind: tokenEnd, val: "."}}}},
 
 		{input: `(foo).`, term: NewAtom("foo")},
-		{input: `(a b).`, err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "b"}}},
+		{input: `(a b).`, err: &SyntaxErr{Position: Position{Offset: 3, Line: 1, Column: 4}, Err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "b"}}}},
 
 		{input: `foo.`, term: NewAtom("foo")},
 		{input: `[].`, term: atomEmptyList},
@@ -87,16 +91,16 @@ func TestParser_Term(t *testing.T) {
 		{input: `foo(a, b).`, term: &compound{functor: NewAtom("foo"), args: []Term{NewAtom("a"), NewAtom("b")}}},
 		{input: `foo(-(a)).`, term: &compound{functor: NewAtom("foo"), args: []Term{&compound{functor: atomMinus, args: []Term{NewAtom("a")}}}}},
 		{input: `foo(-).`, term: &compound{functor: NewAtom("foo"), args: []Term{atomMinus}}},
-		{input: `foo((), b).`, err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}},
+		{input: `foo((), b).`, err: &SyntaxErr{Position: Position{Offset: 5, Line: 1, Column: 6}, Err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}}},
 		{input: `foo([]).`, term: &compound{functor: NewAtom("foo"), args: []Term{atomEmptyList}}},
-		{input: `foo(a, ()).`, err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}},
-		{input: `foo(a b).`, err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "b"}}},
+		{input: `foo(a, ()).`, err: &SyntaxErr{Position: Position{Offset: 8, Line: 1, Column: 9}, Err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}}},
+		{input: `foo(a b).`, err: &SyntaxErr{Position: Position{Offset: 6, Line: 1, Column: 7}, Err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "b"}}}},
 		{input: `foo(a, b`, err: io.EOF},
 
 		{input: `[a, b].`, term: List(NewAtom("a"), NewAtom("b"))},
-		{input: `[(), b].`, err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}},
-		{input: `[a, ()].`, err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}},
-		{input: `[a b].`, err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "b"}}},
+		{input: `[(), b].`, err: &SyntaxErr{Position: Position{Offset: 2, Line: 1, Column: 3}, Err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}}},
+		{input: `[a, ()].`, err: &SyntaxErr{Position: Position{Offset: 5, Line: 1, Column: 6}, Err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}}},
+		{input: `[a b].`, err: &SyntaxErr{Position: Position{Offset: 3, Line: 1, Column: 4}, Err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "b"}}}},
 		{input: `[a|X].`, termLazy: func() Term {
 			return Cons(NewAtom("a"), lastVariable())
 		}, vars: func() []ParsedVariable {
@@ -111,13 +115,13 @@ func TestParser_Term(t *testing.T) {
 				{Name: NewAtom("X"), Variable: lastVariable(), Count: 1},
 			}
 		}},
-		{input: `[a, b|()].`, err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}},
-		{input: `[a, b|c d].`, err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "d"}}},
+		{input: `[a, b|()].`, err: &SyntaxErr{Position: Position{Offset: 7, Line: 1, Column: 8}, Err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}}},
+		{input: `[a, b|c d].`, err: &SyntaxErr{Position: Position{Offset: 8, Line: 1, Column: 9}, Err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "d"}}}},
 		{input: `[a `, err: io.EOF},
 
 		{input: `{a}.`, term: &compound{functor: atomEmptyBlock, args: []Term{NewAtom("a")}}},
-		{input: `{()}.`, err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}},
-		{input: `{a b}.`, err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "b"}}},
+		{input: `{()}.`, err: &SyntaxErr{Position: Position{Offset: 2, Line: 1, Column: 3}, Err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}}},
+		{input: `{a b}.`, err: &SyntaxErr{Position: Position{Offset: 3, Line: 1, Column: 4}, Err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "b"}}}},
 
 		{input: `-a.`, term: &compound{functor: atomMinus, args: []Term{NewAtom("a")}}},
 		{input: `- .`, term: atomMinus},
@@ -125,12 +129,12 @@ func TestParser_Term(t *testing.T) {
 		{input: `a-- .`, term: &compound{functor: NewAtom(`--`), args: []Term{NewAtom(`a`)}}},
 
 		{input: `a + b.`, term: &compound{functor: atomPlus, args: []Term{NewAtom("a"), NewAtom("b")}}},
-		{input: `a + ().`, err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}},
+		{input: `a + ().`, err: &SyntaxErr{Position: Position{Offset: 5, Line: 1, Column: 6}, Err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}}},
 		{input: `a * b + c.`, term: &compound{functor: atomPlus, args: []Term{&compound{functor: NewAtom("*"), args: []Term{NewAtom("a"), NewAtom("b")}}, NewAtom("c")}}},
-		{input: `a [] b.`, err: unexpectedTokenError{actual: Token{kind: tokenOpenList, val: "["}}},
-		{input: `a {} b.`, err: unexpectedTokenError{actual: Token{kind: tokenOpenCurly, val: "{"}}},
+		{input: `a [] b.`, err: &SyntaxErr{Position: Position{Offset: 2, Line: 1, Column: 3}, Err: unexpectedTokenError{actual: Token{kind: tokenOpenList, val: "["}}}},
+		{input: `a {} b.`, err: &SyntaxErr{Position: Position{Offset: 2, Line: 1, Column: 3}, Err: unexpectedTokenError{actual: Token{kind: tokenOpenCurly, val: "{"}}}},
 		{input: `a, b.`, term: &compound{functor: atomComma, args: []Term{NewAtom("a"), NewAtom("b")}}},
-		{input: `+ * + .`, err: unexpectedTokenError{actual: Token{kind: tokenGraphic, val: "+"}}},
+		{input: `+ * + .`, err: &SyntaxErr{Position: Position{Offset: 4, Line: 1, Column: 5}, Err: unexpectedTokenError{actual: Token{kind: tokenGraphic, val: "+"}}}},
 
 		{input: `"abc".`, doubleQuotes: doubleQuotesChars, term: charList("abc")},
 		{input: `"abc".`, doubleQuotes: doubleQuotesCodes, term: codeList("abc")},
@@ -181,6 +185,176 @@ func TestParser_Term(t *testing.T) {
 	}
 }
 
+func TestParser_Term_deep(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		n := maxTermDepth - 2
+		p := NewParser(&VM{}, strings.NewReader(strings.Repeat("f(", n)+"a"+strings.Repeat(")", n)+"."))
+		_, err := p.Term()
+		assert.NoError(t, err)
+	})
+
+	t.Run("too deep", func(t *testing.T) {
+		n := maxTermDepth + 1
+		p := NewParser(&VM{}, strings.NewReader(strings.Repeat("[", n)+strings.Repeat("]", n)+"."))
+		_, err := p.Term()
+		assert.Equal(t, errMaxTermDepth, err)
+	})
+
+	t.Run("long operator chains", func(t *testing.T) {
+		n := maxTermDepth + 1
+		var vm VM
+		vm.operators.define(1000, operatorSpecifierXFY, atomComma)
+		vm.operators.define(900, operatorSpecifierFY, atomNegation)
+
+		p := NewParser(&vm, strings.NewReader(strings.Repeat("a, ", n)+"a."))
+		c, err := p.Term()
+		assert.NoError(t, err)
+		for i := 0; i < n; i++ {
+			assert.Equal(t, atomComma, c.(Compound).Functor())
+			c = c.(Compound).Arg(1)
+		}
+		assert.Equal(t, NewAtom("a"), c)
+
+		p = NewParser(&vm, strings.NewReader(strings.Repeat(`\+ `, n)+"a."))
+		c, err = p.Term()
+		assert.NoError(t, err)
+		for i := 0; i < n; i++ {
+			assert.Equal(t, atomNegation, c.(Compound).Functor())
+			c = c.(Compound).Arg(0)
+		}
+		assert.Equal(t, NewAtom("a"), c)
+	})
+}
+
+func TestParser_Term_positions(t *testing.T) {
+	ops := operators{}
+	ops.define(1200, operatorSpecifierXFX, atomIf)
+	ops.define(500, operatorSpecifierYFX, atomPlus)
+	ops.define(200, operatorSpecifierFY, atomMinus)
+
+	tests := []struct {
+		input        string
+		doubleQuotes doubleQuotes
+		pos          Position
+		layout       Term
+	}{
+		{input: `f(a, [b|T]) :- g({c}, (d)).`, pos: Position{Offset: 0, Line: 1, Column: 1}, layout: atomTermPosition.Apply(Integer(0), Integer(26), Integer(12), Integer(14), List(
+			atomTermPosition.Apply(Integer(0), Integer(11), Integer(0), Integer(1), List(
+				pair(Integer(2), Integer(3)),
+				atomListPosition.Apply(Integer(5), Integer(10), List(pair(Integer(6), Integer(7))), pair(Integer(8), Integer(9))),
+			)),
+			atomTermPosition.Apply(Integer(15), Integer(26), Integer(15), Integer(16), List(
+				atomBraceTermPosition.Apply(Integer(17), Integer(20), pair(Integer(18), Integer(19))),
+				atomParenthesesTermPosition.Apply(Integer(22), Integer(25), pair(Integer(23), Integer(24))),
+			)),
+		))},
+		{input: "\n  - a + \"s\".", doubleQuotes: doubleQuotesCodes, pos: Position{Offset: 3, Line: 2, Column: 3}, layout: atomTermPosition.Apply(Integer(3), Integer(12), Integer(7), Integer(8), List(
+			atomTermPosition.Apply(Integer(3), Integer(6), Integer(3), Integer(4), List(pair(Integer(5), Integer(6)))),
+			atomStringPosition.Apply(Integer(9), Integer(12)),
+		))},
+		{input: `[:-, a].`, pos: Position{Offset: 0, Line: 1, Column: 1}, layout: atomListPosition.Apply(Integer(0), Integer(7), List(
+			pair(Integer(1), Integer(3)),
+			pair(Integer(5), Integer(6)),
+		), atomNone)},
+		{input: `[a, - 1].`, pos: Position{Offset: 0, Line: 1, Column: 1}, layout: atomListPosition.Apply(Integer(0), Integer(8), List(
+			pair(Integer(1), Integer(2)),
+			pair(Integer(4), Integer(7)),
+		), atomNone)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := Parser{
+				lexer: Lexer{
+					input: newRuneRingBuffer(strings.NewReader(tt.input)),
+				},
+				operators:     ops,
+				doubleQuotes:  tt.doubleQuotes,
+				keepPositions: true,
+			}
+			_, err := p.Term()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.pos, p.termPosition())
+			assert.Equal(t, tt.layout, p.subtermPositions())
+		})
+	}
+}
+
+func TestTermOf(t *testing.T) {
+	type address struct {
+		_    struct{} `prolog:"address(city)"`
+		City string
+	}
+	type person struct {
+		_       struct{} `prolog:"person(name, age, address)"`
+		Name    string   `prolog:"name"`
+		Age     int
+		Address *address
+	}
+	type nobody struct {
+		_ struct{} `prolog:"nobody"`
+	}
+	type node struct {
+		_    struct{} `prolog:"node(next)"`
+		Next *node
+	}
+	loop := &node{}
+	loop.Next = loop
+
+	tests := []struct {
+		title string
+		value interface{}
+		term  Term
+		err   error
+	}{
+		{title: "term", value: NewAtom("foo"), term: NewAtom("foo")},
+		{title: "struct", value: person{Name: "alice", Age: 30, Address: &address{City: "tokyo"}}, term: NewAtom("person").Apply(NewAtom("alice"), Integer(30), NewAtom("address").Apply(NewAtom("tokyo")))},
+		{title: "struct: pointer", value: &nobody{}, term: NewAtom("nobody")},
+		{title: "struct: no compound tag", value: struct{ A int }{}, err: errors.New("no compound tag: struct { A int }")},
+		{title: "struct: unknown field", value: struct {
+			_ struct{} `prolog:"foo(bar)"`
+		}{}, err: errors.New("unknown field bar in compound tag: foo(bar)")},
+		{title: "struct: invalid compound tag", value: struct {
+			_ struct{} `prolog:"foo(bar"`
+		}{}, err: errors.New("invalid compound tag: foo(bar")},
+		{title: "map", value: map[string]int{"b": 2, "a": 1}, term: List(atomMinus.Apply(NewAtom("a"), Integer(1)), atomMinus.Apply(NewAtom("b"), Integer(2)))},
+		{title: "bytes", value: []byte{0, 255}, term: List(Integer(0), Integer(255))},
+		{title: "time", value: time.Unix(1, 500_000_000), term: Float(1.5)},
+		{title: "interface", value: []interface{}{1, "a"}, term: List(Integer(1), NewAtom("a"))},
+		{title: "term marshaler", value: testMarshaler{}, term: NewAtom("marshaled")},
+		{title: "term marshaler: pointer receiver", value: []testPtrMarshaler{{}}, term: List(NewAtom("marshaled"))},
+		{title: "unsupported", value: true, err: errors.New("can't convert to term: true")},
+		{title: "integer overflow", value: uint64(math.MaxInt64 + 1), err: &RepresentationErr{Flag: atomMaxInteger}},
+		{title: "self-referential", value: loop, err: &ResourceErr{Resource: atomTermDepth}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			term, err := termOf(reflect.ValueOf(tt.value))
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.term, term)
+		})
+	}
+
+	t.Run("nil pointer", func(t *testing.T) {
+		term, err := termOf(reflect.ValueOf((*person)(nil)))
+		assert.NoError(t, err)
+		assert.IsType(t, Variable(0), term)
+	})
+}
+
+type testMarshaler struct{}
+
+func (testMarshaler) MarshalTerm() (Term, error) {
+	return NewAtom("marshaled"), nil
+}
+
+type testPtrMarshaler struct{}
+
+func (*testPtrMarshaler) MarshalTerm() (Term, error) {
+	return NewAtom("marshaled"), nil
+}
+
 func TestParser_Replace(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		p := Parser{
@@ -227,6 +401,108 @@ func TestParser_Replace(t *testing.T) {
 		_, err := p.Term()
 		assert.Error(t, err)
 	})
+
+	t.Run("variables", func(t *testing.T) {
+		p := Parser{
+			lexer: Lexer{
+				input: newRuneRingBuffer(strings.NewReader(`[?, X, ?].`)),
+			},
+		}
+		p.SetPlaceholderVariables(NewAtom("?"))
+
+		list, err := p.Term()
+		assert.NoError(t, err)
+		ps := p.Placeholders.Positional
+		assert.Len(t, ps, 2)
+		assert.NotEqual(t, ps[0], ps[1])
+		assert.Equal(t, List(ps[0], p.Vars[0].Variable, ps[1]), list)
+	})
+
+	t.Run("named variables", func(t *testing.T) {
+		p := Parser{
+			lexer: Lexer{
+				input: newRuneRingBuffer(strings.NewReader(`[:foo, ?{bar}, :foo].`)),
+			},
+		}
+		p.SetPlaceholderVariables(NewAtom("?"))
+
+		list, err := p.Term()
+		assert.NoError(t, err)
+		ps := p.Placeholders.Named
+		assert.Len(t, ps, 2)
+		assert.Equal(t, NewAtom("foo"), ps[0].Name)
+		assert.Equal(t, 2, ps[0].Count)
+		assert.Equal(t, NewAtom("bar"), ps[1].Name)
+		assert.Equal(t, List(ps[0].Variable, ps[1].Variable, ps[0].Variable), list)
+	})
+}
+
+func TestParser_namedPlaceholders(t *testing.T) {
+	type user struct {
+		Name  string
+		Age   int
+		Admin string `prolog:"role"`
+	}
+
+	tests := []struct {
+		title string
+		input string
+		arg   interface{}
+		term  Term
+		err   error
+	}{
+		{title: "colon", input: `f(:a, :b).`, arg: map[string]interface{}{"a": 1, "b": "foo"}, term: NewAtom("f").Apply(Integer(1), NewAtom("foo"))},
+		{title: "brace", input: `f(?{a}, ?{b}).`, arg: map[string]int{"a": 1, "b": 2}, term: NewAtom("f").Apply(Integer(1), Integer(2))},
+		{title: "repeated", input: `f(:a, ?{a}).`, arg: map[string]int{"a": 1}, term: NewAtom("f").Apply(Integer(1), Integer(1))},
+		{title: "operand", input: `X = :a.`, arg: map[string]int{"a": 1}, term: atomEqual.Apply(NewVariable(), Integer(1))},
+		{title: "struct", input: `f(:name, :age, :role).`, arg: user{Name: "alice", Age: 20, Admin: "yes"}, term: NewAtom("f").Apply(NewAtom("alice"), Integer(20), NewAtom("yes"))},
+		{title: "struct pointer", input: `f(:name, :Age, :role).`, arg: &user{Name: "alice", Age: 20, Admin: "yes"}, term: NewAtom("f").Apply(NewAtom("alice"), Integer(20), NewAtom("yes"))},
+		{title: "module qualification", input: `m:f(:a).`, arg: map[string]int{"a": 1}, term: atomColon.Apply(NewAtom("m"), NewAtom("f").Apply(Integer(1)))},
+		{title: "missing", input: `f(:a, :c).`, arg: map[string]int{"a": 1}, err: errors.New("no argument for named placeholder: c")},
+		{title: "unused", input: `f(:a).`, arg: map[string]int{"a": 1, "b": 2, "c": 3}, err: errors.New("unused arguments for named placeholders: b, c")},
+		{title: "unused field", input: `f(:name).`, arg: user{Name: "alice"}, err: errors.New("unused arguments for named placeholders: Age, role")},
+		{title: "not a map or a struct", input: `f(:a).`, arg: 1, err: errors.New("no argument for named placeholder: a")},
+		{title: "mixed", input: `f(:a, ?).`, arg: map[string]int{"a": 1}, err: errMixedPlaceholders},
+		{title: "positional", input: `f(?).`, arg: map[string]int{"a": 1}, term: NewAtom("f").Apply(List(atomMinus.Apply(NewAtom("a"), Integer(1))))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			p := Parser{
+				lexer: Lexer{
+					input: newRuneRingBuffer(strings.NewReader(tt.input)),
+				},
+				operators: operators{},
+			}
+			p.operators.define(700, operatorSpecifierXFX, atomEqual)
+			p.operators.define(200, operatorSpecifierXFY, atomColon)
+			assert.NoError(t, p.SetPlaceholder(NewAtom("?"), tt.arg))
+
+			term, err := p.Term()
+			assert.Equal(t, tt.err, err)
+			if c, ok := term.(Compound); ok && c.Functor() == atomEqual {
+				term = atomEqual.Apply(tt.term.(Compound).Arg(0), c.Arg(1))
+			}
+			assert.Equal(t, tt.term, term)
+		})
+	}
+
+	t.Run("multiple terms", func(t *testing.T) {
+		p := Parser{
+			lexer: Lexer{
+				input: newRuneRingBuffer(strings.NewReader(`f(:a). g(:b).`)),
+			},
+		}
+		assert.NoError(t, p.SetPlaceholder(NewAtom("?"), map[string]int{"a": 1, "b": 2}))
+
+		term, err := p.Term()
+		assert.NoError(t, err)
+		assert.Equal(t, NewAtom("f").Apply(Integer(1)), term)
+
+		term, err = p.Term()
+		assert.NoError(t, err)
+		assert.Equal(t, NewAtom("g").Apply(Integer(2)), term)
+	})
 }
 
 func TestParser_Number(t *testing.T) {
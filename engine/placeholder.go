@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	errMixedPlaceholders = errors.New("mixed positional and named placeholders")
+	errNamedPlaceholders = errors.New("named placeholders require a map or a struct")
+)
+
+// Placeholders are the variables which replaced the placeholders in a parsed term so that their arguments can be
+// bound later. See SetPlaceholderVariables.
+type Placeholders struct {
+	// Positional are the variables for the positional placeholders, e.g. ?, in order of occurrence.
+	Positional []Variable
+
+	// Named are the variables for the named placeholders, e.g. :user or ?{user}.
+	Named []ParsedVariable
+}
+
+// Bind returns an environment in which the variables for the placeholders are bound to args.
+// The positional placeholders take args in order while the named placeholders take the values of the map or the fields
+// of the struct which is the only argument.
+func (ps *Placeholders) Bind(env *Env, args ...interface{}) (*Env, error) {
+	if len(ps.Named) > 0 {
+		if len(args) != 1 {
+			return nil, errNamedPlaceholders
+		}
+		a, err := newNamedArgs(args[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, pv := range ps.Named {
+			t, err := a.lookup(pv.Name.String())
+			if err != nil {
+				return nil, err
+			}
+			env = env.bind(pv.Variable, t)
+		}
+		return env, a.unused()
+	}
+
+	ts := make([]Term, len(args))
+	for i, a := range args {
+		var err error
+		ts[i], err = termOf(reflect.ValueOf(a))
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case len(ts) < len(ps.Positional):
+		return nil, errPlaceholder
+	case len(ts) > len(ps.Positional):
+		return nil, fmt.Errorf("too many arguments for placeholders: %v", ts[len(ps.Positional):])
+	}
+	for i, v := range ps.Positional {
+		env = env.bind(v, ts[i])
+	}
+	return env, nil
+}
+
+// namedArgs are the arguments for named placeholders which are taken from a map with string keys or a struct.
+type namedArgs struct {
+	names []string
+	terms []Term
+	used  []bool
+	index func(name string) int
+}
+
+// isNamedArgs checks if v can be the arguments for named placeholders.
+func isNamedArgs(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String
+	case reflect.Struct:
+		return true
+	default:
+		return false
+	}
+}
+
+func newNamedArgs(v interface{}) (*namedArgs, error) {
+	o := reflect.ValueOf(v)
+	if !isNamedArgs(v) || (o.Kind() == reflect.Ptr && o.IsNil()) {
+		return nil, fmt.Errorf("%w: %T", errNamedPlaceholders, v)
+	}
+	if o.Kind() == reflect.Ptr {
+		o = o.Elem()
+	}
+
+	var a namedArgs
+	switch o.Kind() {
+	case reflect.Map:
+		keys := o.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		indices := make(map[string]int, len(keys))
+		for i, k := range keys {
+			t, err := termOf(o.MapIndex(k))
+			if err != nil {
+				return nil, err
+			}
+			indices[k.String()] = i
+			a.names = append(a.names, k.String())
+			a.terms = append(a.terms, t)
+		}
+		a.index = func(name string) int {
+			if i, ok := indices[name]; ok {
+				return i
+			}
+			return -1
+		}
+	default:
+		typ := o.Type()
+		fields := map[int]int{} // from the field index to the argument index
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.Name == "_" || f.PkgPath != "" {
+				continue
+			}
+			t, err := termOf(o.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			name := f.Name
+			if alias, ok := f.Tag.Lookup("prolog"); ok {
+				name = alias
+			}
+			fields[i] = len(a.names)
+			a.names = append(a.names, name)
+			a.terms = append(a.terms, t)
+		}
+		a.index = func(name string) int {
+			if i := fieldIndex(typ, name); i >= 0 {
+				return fields[i]
+			}
+			return -1
+		}
+	}
+	a.used = make([]bool, len(a.names))
+	return &a, nil
+}
+
+// lookup returns the argument for the named placeholder.
+func (a *namedArgs) lookup(name string) (Term, error) {
+	i := a.index(name)
+	if i < 0 {
+		return nil, fmt.Errorf("no argument for named placeholder: %s", name)
+	}
+	a.used[i] = true
+	return a.terms[i], nil
+}
+
+// unused returns an error if some of the arguments are not used by any named placeholders.
+func (a *namedArgs) unused() error {
+	var names []string
+	for i, u := range a.used {
+		if !u {
+			names = append(names, a.names[i])
+		}
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("unused arguments for named placeholders: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceholders_Bind(t *testing.T) {
+	x, y := NewVariable(), NewVariable()
+
+	tests := []struct {
+		title string
+		ps    Placeholders
+		args  []interface{}
+		want  []Term
+		err   error
+	}{
+		{title: "none", ps: Placeholders{}},
+		{title: "positional", ps: Placeholders{Positional: []Variable{x, y}}, args: []interface{}{"a", 1}, want: []Term{NewAtom("a"), Integer(1)}},
+		{title: "positional: not enough", ps: Placeholders{Positional: []Variable{x, y}}, args: []interface{}{"a"}, err: errPlaceholder},
+		{title: "positional: too many", ps: Placeholders{Positional: []Variable{x}}, args: []interface{}{"a", 1}, err: errors.New("too many arguments for placeholders: [1]")},
+		{title: "named", ps: Placeholders{Named: []ParsedVariable{{Name: NewAtom("a"), Variable: x}, {Name: NewAtom("b"), Variable: y}}}, args: []interface{}{map[string]string{"a": "foo", "b": "bar"}}, want: []Term{NewAtom("foo"), NewAtom("bar")}},
+		{title: "named: no arguments", ps: Placeholders{Named: []ParsedVariable{{Name: NewAtom("a"), Variable: x}}}, err: errNamedPlaceholders},
+		{title: "named: missing", ps: Placeholders{Named: []ParsedVariable{{Name: NewAtom("a"), Variable: x}, {Name: NewAtom("b"), Variable: y}}}, args: []interface{}{map[string]string{"a": "foo"}}, err: errors.New("no argument for named placeholder: b")},
+		{title: "named: unused", ps: Placeholders{Named: []ParsedVariable{{Name: NewAtom("a"), Variable: x}}}, args: []interface{}{map[string]string{"a": "foo", "b": "bar"}}, err: errors.New("unused arguments for named placeholders: b")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			env, err := tt.ps.Bind(nil, tt.args...)
+			assert.Equal(t, tt.err, err)
+			if err != nil {
+				return
+			}
+			for i, w := range tt.want {
+				assert.Equal(t, w, env.Resolve([]Term{x, y}[i]))
+			}
+		})
+	}
+}
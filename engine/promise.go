@@ -22,6 +22,8 @@ type Promise struct {
 	cutParent *Promise
 	repeat    bool
 	recover   func(error) *Promise
+	discard   func() // called when the remaining choices are discarded, e.g. by cut
+	env       *Env   // the context in which a typed error from the delayed execution is raised as an exception
 }
 
 // Delay delays an execution of k.
@@ -75,8 +77,28 @@ func catch(recover func(error) *Promise, k func(context.Context) *Promise) *Prom
 
 // Force enforces the delayed execution and returns the result. (i.e. trampoline)
 func (p *Promise) Force(ctx context.Context) (bool, error) {
-	stack := promiseStack{p}
-	for len(stack) > 0 {
+	t := NewTrampoline(p)
+	defer t.Close()
+	return t.Next(ctx)
+}
+
+// Trampoline is a resumable execution of a promise.
+// Each call of Next runs the delayed execution on the caller's goroutine until it reaches the next success.
+// The subsequent call resumes the execution from there, i.e. it backtracks to the remaining choices.
+type Trampoline struct {
+	stack promiseStack
+}
+
+// NewTrampoline returns a trampoline which executes p.
+func NewTrampoline(p *Promise) *Trampoline {
+	return &Trampoline{stack: promiseStack{p}}
+}
+
+// Next runs the execution until it results in success, failure, or error.
+// It returns false without an error once the execution has exhausted all the choices.
+func (t *Trampoline) Next(ctx context.Context) (bool, error) {
+	stack := &t.stack
+	for len(*stack) > 0 {
 		select {
 		case <-ctx.Done():
 			return false, ctx.Err()
@@ -105,12 +127,31 @@ func (p *Promise) Force(ctx context.Context) (bool, error) {
 
 			// Try the child promises from left to right.
 			q := p.child(ctx)
-			stack = append(stack, p, q)
+			*stack = append(*stack, p, q)
 		}
 	}
 	return false, nil
 }
 
+// Deterministic reports whether there's no choice left to try, i.e. the subsequent call of Next results in false.
+// It's not exhaustive. Even if it returns false, the remaining choices may result in no more successes.
+func (t *Trampoline) Deterministic() bool {
+	for _, p := range t.stack {
+		if len(p.delayed) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Close discards the remaining choices so that the subsequent calls of Next result in false.
+func (t *Trampoline) Close() {
+	for len(t.stack) > 0 {
+		t.stack.pop().discarded()
+	}
+	t.stack = nil
+}
+
 func (p *Promise) child(ctx context.Context) *Promise {
 	q := p.delayed[0](ctx)
 	if !p.repeat {
@@ -119,6 +160,13 @@ func (p *Promise) child(ctx context.Context) *Promise {
 	return q
 }
 
+// discarded notifies the promise that its remaining choices won't be tried.
+func (p *Promise) discarded() {
+	if p.discard != nil && len(p.delayed) > 0 {
+		p.discard()
+	}
+}
+
 type promiseStack []*Promise
 
 func (s *promiseStack) pop() *Promise {
@@ -129,7 +177,9 @@ func (s *promiseStack) pop() *Promise {
 
 func (s *promiseStack) popUntil(p *Promise) {
 	for len(*s) > 0 {
-		if pop := s.pop(); pop == p {
+		pop := s.pop()
+		pop.discarded()
+		if pop == p {
 			break
 		}
 	}
@@ -139,6 +189,12 @@ func (s *promiseStack) recover(err error) error {
 	// look for an ancestor promise with a recovering function that is applicable to the error.
 	for len(*s) > 0 {
 		pop := s.pop()
+		pop.discarded()
+		if pop.env != nil {
+			if e, ok := exceptionOf(err, pop.env); ok {
+				err = e
+			}
+		}
 		if pop.recover == nil {
 			continue
 		}
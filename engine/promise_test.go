@@ -2,6 +2,7 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -73,3 +74,124 @@ func TestPromise_Force(t *testing.T) {
 		assert.Equal(t, 10, count)
 	})
 }
+
+func TestTrampoline_Next(t *testing.T) {
+	var res []int
+	tr := NewTrampoline(Delay(func(context.Context) *Promise {
+		res = append(res, 1)
+		return Bool(true)
+	}, func(context.Context) *Promise {
+		res = append(res, 2)
+		return Bool(false)
+	}, func(context.Context) *Promise {
+		res = append(res, 3)
+		return Delay(func(context.Context) *Promise {
+			res = append(res, 4)
+			return Bool(true)
+		}, func(context.Context) *Promise {
+			res = append(res, 5)
+			return Error(errors.New("failed"))
+		})
+	}, func(context.Context) *Promise {
+		res = append(res, 6)
+		return Bool(true)
+	}))
+
+	ok, err := tr.Next(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []int{1}, res)
+
+	ok, err = tr.Next(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []int{1, 2, 3, 4}, res)
+
+	ok, err = tr.Next(context.Background())
+	assert.Error(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, res)
+
+	t.Run("closed", func(t *testing.T) {
+		tr := NewTrampoline(Delay(func(context.Context) *Promise {
+			return Bool(true)
+		}, func(context.Context) *Promise {
+			assert.Fail(t, "unreachable")
+			return Bool(true)
+		}))
+
+		ok, err := tr.Next(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		tr.Close()
+
+		ok, err = tr.Next(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestTrampoline_Close(t *testing.T) {
+	var discarded bool
+	p := Delay(func(context.Context) *Promise {
+		return Bool(true)
+	}, func(context.Context) *Promise {
+		return Bool(true)
+	})
+	p.discard = func() {
+		discarded = true
+	}
+
+	tr := NewTrampoline(p)
+	ok, err := tr.Next(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, discarded)
+
+	tr.Close()
+	assert.True(t, discarded)
+
+	ok, err = tr.Next(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestTrampoline_Deterministic(t *testing.T) {
+	k := func(context.Context) *Promise {
+		return Bool(true)
+	}
+
+	t.Run("choices", func(t *testing.T) {
+		tr := NewTrampoline(Delay(k, k))
+		ok, err := tr.Next(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.False(t, tr.Deterministic())
+
+		ok, err = tr.Next(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, tr.Deterministic())
+	})
+
+	t.Run("cut", func(t *testing.T) {
+		var p *Promise
+		p = Delay(func(context.Context) *Promise {
+			return cut(p, k)
+		}, k)
+		tr := NewTrampoline(p)
+		ok, err := tr.Next(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, tr.Deterministic())
+	})
+
+	t.Run("repeat", func(t *testing.T) {
+		tr := NewTrampoline(repeat(k))
+		ok, err := tr.Next(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.False(t, tr.Deterministic())
+	})
+}
@@ -0,0 +1,622 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// Backend is an abstract machine that executes user-defined procedures.
+type Backend uint8
+
+const (
+	// BackendZIP executes the ZIP-inspired bytecode as it is.
+	BackendZIP Backend = iota
+	// BackendRegister translates the bytecode into register-based instructions with the first argument indexing and
+	// executes them. The choices among clauses are still promises, not choicepoint instructions.
+	BackendRegister
+)
+
+func (b Backend) String() string {
+	switch b {
+	case BackendZIP:
+		return "zip"
+	case BackendRegister:
+		return "register"
+	default:
+		return fmt.Sprintf("Backend(%d)", b)
+	}
+}
+
+type regOpcode byte
+
+const (
+	regGetVariable regOpcode = iota
+	regGetValue
+	regGetConstant
+	regGetStructure
+	regUnifyVariable
+	regUnifyValue
+	regUnifyConstant
+	regUnifyVoid
+	regPutVariable
+	regPutValue
+	regPutConstant
+	regPutStructure
+	regSetVariable
+	regSetValue
+	regSetConstant
+	regCall
+	regExecute
+	regProceed
+	regCut
+)
+
+// regInstruction is an instruction for the register-based machine.
+// reg is an index of the clause's registers and arg is an index of the incoming arguments.
+// For regGetStructure, a negative arg means the structure is in reg instead of the incoming arguments.
+type regInstruction struct {
+	opcode  regOpcode
+	operand Term
+	reg     int
+	arg     int
+}
+
+type regClause struct {
+	code []regInstruction
+	regs int
+	key  termID // the first argument's index key. nil if it's a variable or there's no arguments.
+}
+
+// regProcedure is a set of clauses translated into register-based instructions with the first argument indexing.
+type regProcedure struct {
+	clauses []regClause
+	all     []int
+	vars    []int
+	index   map[termID][]int
+}
+
+// translate returns the register-based translation of the clauses. The result is cached until the clauses are modified.
+func (u *userDefined) translate() *regProcedure {
+	if p := u.compiled.Load(); p != nil {
+		return p
+	}
+	p := compileRegister(u.clauses)
+	u.compiled.Store(p)
+	return p
+}
+
+// invalidate discards the cached translation of the clauses.
+func (u *userDefined) invalidate() {
+	u.compiled.Store(nil)
+}
+
+func (u *userDefined) call(vm *VM, args []Term, k Cont, env *Env) *Promise {
+	if vm != nil && vm.Backend == BackendRegister {
+		return u.translate().call(vm, args, k, env)
+	}
+	return u.clauses.call(vm, args, k, env)
+}
+
+func (p *regProcedure) call(vm *VM, args []Term, k Cont, env *Env) *Promise {
+	cs := p.candidates(args, env)
+	if len(cs) == 0 {
+		return Bool(false)
+	}
+
+	// The promise holds the choices among the candidate clauses and works as the cut barrier.
+	var d *Promise
+	ks := make([]func(context.Context) *Promise, len(cs))
+	for i := range cs {
+		c := &p.clauses[cs[i]]
+		ks[i] = func(context.Context) *Promise {
+			return vm.execRegister(c.code, make([]Term, c.regs), args, k, env, d)
+		}
+	}
+	d = Delay(ks...)
+	return d
+}
+
+// candidates returns indices of the clauses which might match with args in terms of the first argument.
+func (p *regProcedure) candidates(args []Term, env *Env) []int {
+	if len(args) == 0 {
+		return p.all
+	}
+	key := indexKey(env.Resolve(args[0]))
+	if key == nil {
+		return p.all
+	}
+	if cs, ok := p.index[key]; ok {
+		return cs
+	}
+	return p.vars
+}
+
+func indexKey(t Term) termID {
+	switch t := t.(type) {
+	case Variable:
+		return nil
+	case Compound:
+		return procedureIndicator{name: t.Functor(), arity: Integer(t.Arity())}
+	default:
+		return id(t)
+	}
+}
+
+func (vm *VM) execRegister(code []regInstruction, regs, args []Term, k Cont, env *Env, cutParent *Promise) *Promise {
+	var (
+		ok    = true
+		err   error
+		write bool
+		s     Compound
+		w     *compound
+		n     int
+		out   []Term
+	)
+	for pc := 0; ok; pc++ {
+		in := &code[pc]
+		switch in.opcode {
+		case regGetVariable:
+			regs[in.reg] = args[in.arg]
+		case regGetValue:
+			env, ok, err = vm.unifyRegister(regs[in.reg], args[in.arg], env)
+		case regGetConstant:
+			env, ok, err = vm.unifyRegister(args[in.arg], in.operand, env)
+		case regGetStructure:
+			pi := in.operand.(procedureIndicator)
+			var t Term
+			if in.arg < 0 {
+				t = regs[in.reg]
+			} else {
+				t = args[in.arg]
+			}
+			switch t := env.Resolve(t).(type) {
+			case Variable:
+				w = &compound{functor: pi.name, args: make([]Term, pi.arity)}
+				if vm != nil && vm.occursCheck != occursCheckFalse {
+					// Instead of writing the arguments directly, we unify them one by one so that they're checked.
+					for i := range w.args {
+						w.args[i] = NewVariable()
+					}
+					env, s, write, n = env.bind(t, w), w, false, 0
+					break
+				}
+				env, write, n = env.bind(t, w), true, 0
+			case Compound:
+				ok = t.Functor() == pi.name && t.Arity() == int(pi.arity)
+				s, write, n = t, false, 0
+			default:
+				ok = false
+			}
+		case regUnifyVariable:
+			if write {
+				v := NewVariable()
+				regs[in.reg], w.args[n] = v, v
+			} else {
+				regs[in.reg] = s.Arg(n)
+			}
+			n++
+		case regUnifyValue:
+			if write {
+				w.args[n] = regs[in.reg]
+			} else {
+				env, ok, err = vm.unifyRegister(regs[in.reg], s.Arg(n), env)
+			}
+			n++
+		case regUnifyConstant:
+			if write {
+				w.args[n] = in.operand
+			} else {
+				env, ok, err = vm.unifyRegister(s.Arg(n), in.operand, env)
+			}
+			n++
+		case regUnifyVoid:
+			if write {
+				w.args[n] = NewVariable()
+			}
+			n++
+		case regPutVariable:
+			v := NewVariable()
+			regs[in.reg] = v
+			out = append(out, v)
+		case regPutValue:
+			out = append(out, regs[in.reg])
+		case regPutConstant:
+			out = append(out, in.operand)
+		case regPutStructure:
+			pi := in.operand.(procedureIndicator)
+			w, n = &compound{functor: pi.name, args: make([]Term, pi.arity)}, 0
+			regs[in.reg] = w
+		case regSetVariable:
+			v := NewVariable()
+			regs[in.reg], w.args[n] = v, v
+			n++
+		case regSetValue:
+			w.args[n] = regs[in.reg]
+			n++
+		case regSetConstant:
+			w.args[n] = in.operand
+			n++
+		case regCall:
+			pi, rest := in.operand.(procedureIndicator), code[pc+1:]
+			return vm.Arrive(pi.name, out, func(env *Env) *Promise {
+				return vm.execRegister(rest, regs, args, k, env, cutParent)
+			}, env)
+		case regExecute:
+			pi := in.operand.(procedureIndicator)
+			return vm.Arrive(pi.name, out, k, env)
+		case regProceed:
+			return k(env)
+		case regCut:
+			rest := code[pc+1:]
+			return cut(cutParent, func(context.Context) *Promise {
+				return vm.execRegister(rest, regs, args, k, env, cutParent)
+			})
+		}
+	}
+	if err != nil {
+		return Error(err)
+	}
+	return Bool(false)
+}
+
+// unifyRegister unifies x and y. It binds a variable or compares atomic terms by itself.
+// It resorts to the general unification only for 2 compounds or a binding which needs the occurs check.
+func (vm *VM) unifyRegister(x, y Term, env *Env) (*Env, bool, error) {
+	x, y = env.Resolve(x), env.Resolve(y)
+	if v, ok := y.(Variable); ok {
+		if _, ok := x.(Variable); !ok {
+			x, y = v, x
+		}
+	}
+	switch x := x.(type) {
+	case Variable:
+		if x == y {
+			return env, true, nil
+		}
+		if _, ok := y.(Compound); ok && vm != nil && vm.occursCheck != occursCheckFalse {
+			return vm.unify(x, y, env)
+		}
+		return env.bind(x, y), true, nil
+	case Compound:
+		return vm.unify(x, y, env)
+	default:
+		return env, x == y, nil
+	}
+}
+
+func compileRegister(cs clauses) *regProcedure {
+	p := regProcedure{
+		clauses: make([]regClause, len(cs)),
+		all:     make([]int, len(cs)),
+		index:   map[termID][]int{},
+	}
+	for i, c := range cs {
+		p.clauses[i] = compileRegisterClause(c)
+		p.all[i] = i
+	}
+
+	// The first argument indexing keeps the clauses in the original order.
+	for _, c := range p.clauses {
+		if c.key != nil {
+			p.index[c.key] = nil
+		}
+	}
+	for i, c := range p.clauses {
+		if c.key == nil {
+			p.vars = append(p.vars, i)
+			for k := range p.index {
+				p.index[k] = append(p.index[k], i)
+			}
+			continue
+		}
+		p.index[c.key] = append(p.index[c.key], i)
+	}
+	return &p
+}
+
+type regNodeKind uint8
+
+const (
+	regNodeConst regNodeKind = iota
+	regNodeVar
+	regNodeStruct
+)
+
+// regNode is a head/body argument recovered from the bytecode.
+type regNode struct {
+	kind regNodeKind
+	term Term               // for regNodeConst
+	v    int                // for regNodeVar
+	pi   procedureIndicator // for regNodeStruct
+	args []regNode          // for regNodeStruct
+}
+
+type regGoal struct {
+	cut  bool
+	pi   procedureIndicator
+	args []regNode
+}
+
+// regDecompiler recovers the structure of a clause from the bytecode.
+type regDecompiler struct {
+	bytecode bytecode
+	pc       int
+}
+
+func (d *regDecompiler) clause() ([]regNode, []regGoal) {
+	var head []regNode
+	for {
+		switch d.bytecode[d.pc].opcode {
+		case opExit:
+			return head, nil
+		case opEnter:
+			d.pc++
+			return head, d.body()
+		default:
+			head = append(head, d.arg())
+		}
+	}
+}
+
+func (d *regDecompiler) body() []regGoal {
+	var (
+		goals []regGoal
+		args  []regNode
+	)
+	for {
+		switch op := d.bytecode[d.pc]; op.opcode {
+		case opExit:
+			return goals
+		case opCut:
+			d.pc++
+			goals = append(goals, regGoal{cut: true})
+		case opCall:
+			d.pc++
+			goals = append(goals, regGoal{pi: op.operand.(procedureIndicator), args: args})
+			args = nil
+		default:
+			args = append(args, d.arg())
+		}
+	}
+}
+
+func (d *regDecompiler) arg() regNode {
+	op := d.bytecode[d.pc]
+	d.pc++
+	switch op.opcode {
+	case opGetVar, opPutVar:
+		return regNode{kind: regNodeVar, v: int(op.operand.(Integer))}
+	case opGetFunctor, opPutFunctor:
+		pi := op.operand.(procedureIndicator)
+		args := make([]regNode, pi.arity)
+		for i := range args {
+			args[i] = d.arg()
+		}
+		d.pc++ // opPop
+		return regNode{kind: regNodeStruct, pi: pi, args: args}
+	case opGetList, opPutList:
+		elems := make([]regNode, op.operand.(Integer))
+		for i := range elems {
+			elems[i] = d.arg()
+		}
+		d.pc++ // opPop
+		return cons(elems, regNode{kind: regNodeConst, term: atomEmptyList})
+	case opGetPartial, opPutPartial:
+		tail := d.arg()
+		elems := make([]regNode, op.operand.(Integer))
+		for i := range elems {
+			elems[i] = d.arg()
+		}
+		d.pc++ // opPop
+		return cons(elems, tail)
+	default: // opGetConst, opPutConst
+		return regNode{kind: regNodeConst, term: op.operand}
+	}
+}
+
+func cons(elems []regNode, tail regNode) regNode {
+	l := tail
+	for i := len(elems) - 1; i >= 0; i-- {
+		l = regNode{
+			kind: regNodeStruct,
+			pi:   procedureIndicator{name: atomDot, arity: 2},
+			args: []regNode{elems[i], l},
+		}
+	}
+	return l
+}
+
+// regCompiler translates a clause into register-based instructions.
+type regCompiler struct {
+	code  []regInstruction
+	regs  int
+	seen  []bool
+	count []int
+}
+
+func compileRegisterClause(c clause) regClause {
+	d := regDecompiler{bytecode: c.bytecode}
+	head, body := d.clause()
+
+	wc := regCompiler{
+		regs:  len(c.vars),
+		seen:  make([]bool, len(c.vars)),
+		count: make([]int, len(c.vars)),
+	}
+	for _, a := range head {
+		wc.countVars(a)
+	}
+	for _, g := range body {
+		for _, a := range g.args {
+			wc.countVars(a)
+		}
+	}
+	wc.compileHead(head)
+	wc.compileBody(body)
+
+	ret := regClause{
+		code: wc.code,
+		regs: wc.regs,
+	}
+	if len(head) > 0 {
+		switch a := head[0]; a.kind {
+		case regNodeConst:
+			ret.key = indexKey(a.term)
+		case regNodeStruct:
+			ret.key = a.pi
+		}
+	}
+	return ret
+}
+
+func (c *regCompiler) countVars(a regNode) {
+	switch a.kind {
+	case regNodeVar:
+		c.count[a.v]++
+	case regNodeStruct:
+		for _, b := range a.args {
+			c.countVars(b)
+		}
+	}
+}
+
+func (c *regCompiler) emit(in regInstruction) {
+	c.code = append(c.code, in)
+}
+
+func (c *regCompiler) temp() int {
+	c.regs++
+	return c.regs - 1
+}
+
+// first returns true if it's the first occurrence of the variable and marks it as seen.
+func (c *regCompiler) first(v int) bool {
+	if c.seen[v] {
+		return false
+	}
+	c.seen[v] = true
+	return true
+}
+
+func (c *regCompiler) compileHead(head []regNode) {
+	type pending struct {
+		reg  int
+		node regNode
+	}
+	var queue []pending
+	unify := func(a regNode) {
+		switch a.kind {
+		case regNodeVar:
+			if c.count[a.v] == 1 {
+				c.emit(regInstruction{opcode: regUnifyVoid})
+				return
+			}
+			if c.first(a.v) {
+				c.emit(regInstruction{opcode: regUnifyVariable, reg: a.v})
+			} else {
+				c.emit(regInstruction{opcode: regUnifyValue, reg: a.v})
+			}
+		case regNodeStruct:
+			t := c.temp()
+			c.emit(regInstruction{opcode: regUnifyVariable, reg: t})
+			queue = append(queue, pending{reg: t, node: a})
+		default:
+			c.emit(regInstruction{opcode: regUnifyConstant, operand: a.term})
+		}
+	}
+
+	for i, a := range head {
+		switch a.kind {
+		case regNodeVar:
+			if c.count[a.v] == 1 {
+				break // Anonymous variables in the head match anything.
+			}
+			if c.first(a.v) {
+				c.emit(regInstruction{opcode: regGetVariable, reg: a.v, arg: i})
+			} else {
+				c.emit(regInstruction{opcode: regGetValue, reg: a.v, arg: i})
+			}
+		case regNodeStruct:
+			c.emit(regInstruction{opcode: regGetStructure, operand: a.pi, arg: i})
+			for _, b := range a.args {
+				unify(b)
+			}
+		default:
+			c.emit(regInstruction{opcode: regGetConstant, operand: a.term, arg: i})
+		}
+	}
+
+	// Nested structures are flattened.
+	for len(queue) > 0 {
+		var p pending
+		p, queue = queue[0], queue[1:]
+		c.emit(regInstruction{opcode: regGetStructure, operand: p.node.pi, reg: p.reg, arg: -1})
+		for _, b := range p.node.args {
+			unify(b)
+		}
+	}
+}
+
+func (c *regCompiler) compileBody(body []regGoal) {
+	for i, g := range body {
+		if g.cut {
+			c.emit(regInstruction{opcode: regCut})
+			continue
+		}
+
+		for _, a := range g.args {
+			c.compilePut(a)
+		}
+
+		// The last call passes the continuation as it is.
+		if i == len(body)-1 {
+			c.emit(regInstruction{opcode: regExecute, operand: g.pi})
+			return
+		}
+		c.emit(regInstruction{opcode: regCall, operand: g.pi})
+	}
+	c.emit(regInstruction{opcode: regProceed})
+}
+
+func (c *regCompiler) compilePut(a regNode) {
+	switch a.kind {
+	case regNodeVar:
+		if c.first(a.v) {
+			c.emit(regInstruction{opcode: regPutVariable, reg: a.v})
+		} else {
+			c.emit(regInstruction{opcode: regPutValue, reg: a.v})
+		}
+	case regNodeStruct:
+		c.emit(regInstruction{opcode: regPutValue, reg: c.compileStructure(a)})
+	default:
+		c.emit(regInstruction{opcode: regPutConstant, operand: a.term})
+	}
+}
+
+// compileStructure builds the structure bottom-up and returns the register which holds it.
+func (c *regCompiler) compileStructure(a regNode) int {
+	regs := make([]int, len(a.args))
+	for i, b := range a.args {
+		if b.kind == regNodeStruct {
+			regs[i] = c.compileStructure(b)
+		}
+	}
+
+	t := c.temp()
+	c.emit(regInstruction{opcode: regPutStructure, operand: a.pi, reg: t})
+	for i, b := range a.args {
+		switch b.kind {
+		case regNodeVar:
+			if c.first(b.v) {
+				c.emit(regInstruction{opcode: regSetVariable, reg: b.v})
+			} else {
+				c.emit(regInstruction{opcode: regSetValue, reg: b.v})
+			}
+		case regNodeStruct:
+			c.emit(regInstruction{opcode: regSetValue, reg: regs[i]})
+		default:
+			c.emit(regInstruction{opcode: regSetConstant, operand: b.term})
+		}
+	}
+	return t
+}
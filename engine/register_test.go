@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_String(t *testing.T) {
+	assert.Equal(t, "zip", BackendZIP.String())
+	assert.Equal(t, "register", BackendRegister.String())
+	assert.Equal(t, "Backend(2)", Backend(2).String())
+}
+
+func TestCompileRegister(t *testing.T) {
+	var (
+		foo = NewAtom("foo")
+		bar = NewAtom("bar")
+		baz = NewAtom("baz")
+		f   = NewAtom("f")
+		g   = NewAtom("g")
+		a   = NewAtom("a")
+		b   = NewAtom("b")
+	)
+
+	x, y, z, w := NewVariable(), NewVariable(), NewVariable(), NewVariable()
+	cs, err := compile(atomIf.Apply(
+		foo.Apply(x, f.Apply(y, a, w), x),
+		seq(atomComma, bar.Apply(y, g.Apply(z)), atomCut, baz.Apply(z, List(b))),
+	), nil)
+	assert.NoError(t, err)
+
+	p := compileRegister(cs)
+	assert.Equal(t, []regInstruction{
+		{opcode: regGetVariable, reg: 0, arg: 0},
+		{opcode: regGetStructure, operand: procedureIndicator{name: f, arity: 3}, arg: 1},
+		{opcode: regUnifyVariable, reg: 1},
+		{opcode: regUnifyConstant, operand: a},
+		{opcode: regUnifyVoid},
+		{opcode: regGetValue, reg: 0, arg: 2},
+		{opcode: regPutValue, reg: 1},
+		{opcode: regPutStructure, operand: procedureIndicator{name: g, arity: 1}, reg: 4},
+		{opcode: regSetVariable, reg: 3},
+		{opcode: regPutValue, reg: 4},
+		{opcode: regCall, operand: procedureIndicator{name: bar, arity: 2}},
+		{opcode: regCut},
+		{opcode: regPutValue, reg: 3},
+		{opcode: regPutStructure, operand: procedureIndicator{name: atomDot, arity: 2}, reg: 5},
+		{opcode: regSetConstant, operand: b},
+		{opcode: regSetConstant, operand: atomEmptyList},
+		{opcode: regPutValue, reg: 5},
+		{opcode: regExecute, operand: procedureIndicator{name: baz, arity: 2}},
+	}, p.clauses[0].code)
+	assert.Equal(t, 6, p.clauses[0].regs)
+}
+
+func TestRegProcedure_call(t *testing.T) {
+	vm := VM{Backend: BackendRegister}
+	vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+	vm.operators.define(1200, operatorSpecifierFX, atomIf)
+	vm.operators.define(1000, operatorSpecifierXFY, atomComma)
+	vm.operators.define(700, operatorSpecifierXFX, atomEqual)
+	vm.operators.define(400, operatorSpecifierYFX, atomSlash)
+	vm.Register0(atomTrue, func(_ *VM, k Cont, env *Env) *Promise {
+		return k(env)
+	})
+	vm.Register2(atomEqual, Unify)
+	vm.Register1(NewAtom("assertz"), Assertz)
+	assert.NoError(t, vm.Compile(context.Background(), `
+:- dynamic(color/2).
+color(apple, red).
+color(X, unknown) :- X = stone.
+color(banana, yellow).
+color(f(X), Y) :- X = apple, color(X, Y).
+
+pick([X|_], X) :- !.
+pick([_|_], never).
+
+first(X, [X|_]).
+first(X, [_|T]) :- first(X, T).
+
+pair(X, Y, p(X, f(Y))).
+`))
+
+	solutions := func(goal Term, v Variable) []Term {
+		var ret []Term
+		_, err := Call(&vm, goal, func(env *Env) *Promise {
+			ret = append(ret, env.simplify(v))
+			return Bool(false)
+		}, nil).Force(context.Background())
+		assert.NoError(t, err)
+		return ret
+	}
+
+	var (
+		color  = NewAtom("color")
+		first  = NewAtom("first")
+		apple  = NewAtom("apple")
+		banana = NewAtom("banana")
+		stone  = NewAtom("stone")
+	)
+
+	t.Run("first argument indexing", func(t *testing.T) {
+		c := NewVariable()
+		assert.Equal(t, []Term{NewAtom("red")}, solutions(color.Apply(apple, c), c))
+		assert.Equal(t, []Term{NewAtom("unknown")}, solutions(color.Apply(stone, c), c))
+		assert.Equal(t, []Term{NewAtom("yellow")}, solutions(color.Apply(banana, c), c))
+		assert.Equal(t, []Term{NewAtom("red")}, solutions(color.Apply(NewAtom("f").Apply(apple), c), c))
+		assert.Empty(t, solutions(color.Apply(NewAtom("g").Apply(apple), c), c))
+	})
+
+	t.Run("variable first argument", func(t *testing.T) {
+		x, c := NewVariable(), NewVariable()
+		assert.Equal(t, []Term{NewAtom("red"), NewAtom("unknown"), NewAtom("yellow"), NewAtom("red")}, solutions(color.Apply(x, c), c))
+	})
+
+	t.Run("cut", func(t *testing.T) {
+		x := NewVariable()
+		assert.Equal(t, []Term{banana}, solutions(NewAtom("pick").Apply(List(banana, apple), x), x))
+	})
+
+	t.Run("write mode", func(t *testing.T) {
+		p := NewVariable()
+		assert.Equal(t, []Term{
+			NewAtom("p").Apply(Integer(1), NewAtom("f").Apply(Integer(2))),
+		}, solutions(NewAtom("pair").Apply(Integer(1), Integer(2), p), p))
+	})
+
+	t.Run("backtracking", func(t *testing.T) {
+		x := NewVariable()
+		assert.Equal(t, []Term{NewAtom("a"), NewAtom("b"), NewAtom("c")}, solutions(first.Apply(x, List(NewAtom("a"), NewAtom("b"), NewAtom("c"))), x))
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		c := NewVariable()
+		assert.Equal(t, []Term{NewAtom("red")}, solutions(color.Apply(apple, c), c))
+		assert.Equal(t, []Term{NewAtom("red"), NewAtom("green")}, solutions(seq(atomComma, NewAtom("assertz").Apply(color.Apply(apple, NewAtom("green"))), color.Apply(apple, c)), c))
+	})
+}
+
+func TestVM_unifyRegister(t *testing.T) {
+	f, a, b := NewAtom("f"), NewAtom("a"), NewAtom("b")
+	x, y := NewVariable(), NewVariable()
+
+	tests := []struct {
+		title string
+		check occursCheck
+		x, y  Term
+		env   *Env
+		ok    bool
+		err   bool
+		bound map[Variable]Term
+	}{
+		{title: "same atoms", x: a, y: a, ok: true},
+		{title: "different atoms", x: a, y: b, ok: false},
+		{title: "atom and integer", x: a, y: Integer(1), ok: false},
+		{title: "atom and compound", x: a, y: f.Apply(a), ok: false},
+		{title: "variable and atom", x: x, y: a, ok: true, bound: map[Variable]Term{x: a}},
+		{title: "atom and variable", x: a, y: x, ok: true, bound: map[Variable]Term{x: a}},
+		{title: "bound variable", x: x, y: b, env: NewEnv().bind(x, a), ok: false},
+		{title: "same variable", x: x, y: x, ok: true},
+		{title: "variables", x: x, y: y, ok: true, bound: map[Variable]Term{x: y}},
+		{title: "compounds", x: f.Apply(x), y: f.Apply(a), ok: true, bound: map[Variable]Term{x: a}},
+		{title: "char list and list", x: charList("ab"), y: List(a, b), ok: true},
+		{title: "cyclic without occurs check", x: x, y: f.Apply(x), ok: true, bound: map[Variable]Term{x: f.Apply(x)}},
+		{title: "occurs check", check: occursCheckTrue, x: x, y: f.Apply(x), ok: false},
+		{title: "occurs check error", check: occursCheckError, x: x, y: f.Apply(x), ok: false, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			vm := VM{occursCheck: tt.check}
+			env, ok, err := vm.unifyRegister(tt.x, tt.y, tt.env)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.err, err != nil)
+			for v, want := range tt.bound {
+				assert.Equal(t, want, env.Resolve(v))
+			}
+		})
+	}
+}
@@ -26,6 +26,9 @@ type Stream struct {
 	buf          *bufio.Reader
 	lastRuneSize int
 
+	// textPos is where the next rune is in the text. lastTextPos is for UnreadRune.
+	textPos, lastTextPos textPosition
+
 	mode        ioMode
 	alias       Atom
 	position    int64
@@ -179,6 +182,10 @@ func (s *Stream) ReadRune() (r rune, size int, err error) {
 	r, n, err := s.buf.ReadRune()
 	s.position += int64(n)
 	s.lastRuneSize = n
+	s.lastTextPos = s.textPos
+	if n > 0 {
+		s.textPos.advance(r)
+	}
 	switch {
 	case n == 0:
 		s.endOfStream = endOfStreamPast
@@ -204,6 +211,7 @@ func (s *Stream) UnreadRune() error {
 		s.position -= int64(s.lastRuneSize)
 		s.endOfStream = endOfStreamNot
 		s.lastRuneSize = 0
+		s.textPos = s.lastTextPos
 	}
 	return err
 }
@@ -228,6 +236,9 @@ func (s *Stream) Seek(offset int64, whence int) (int64, error) {
 	}
 
 	s.position = n
+	if n == 0 {
+		s.textPos = textPosition{}
+	}
 
 	if r, ok := sk.(io.Reader); ok && s.buf != nil {
 		s.buf.Reset(r)
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -12,16 +13,23 @@ type Term interface {
 	Compare(t Term, env *Env) int
 }
 
+// maxTermDepth is the maximum nesting of compound terms that the recursive conversions handle, e.g. the parentheses and
+// the arguments in the parser. Beyond that, they fail with resource_error(term_depth) instead of exhausting the call stack.
+const maxTermDepth = 100_000
+
+var errMaxTermDepth = errors.New("max term depth exceeded")
+
 // WriteOptions specify how the Term writes itself.
 type WriteOptions struct {
 	ignoreOps     bool
 	quoted        bool
 	variableNames map[Variable]Atom
 	numberVars    bool
+	cycles        bool
 
 	ops         operators
 	priority    Integer
-	visited     map[termID]struct{}
+	visited     map[termID]struct{} // compounds on the path from the root to the current one
 	prefixMinus bool
 	left, right operator
 }
@@ -31,15 +39,6 @@ func (o WriteOptions) withQuoted(quoted bool) *WriteOptions {
 	return &o
 }
 
-func (o WriteOptions) withFreshVisited() *WriteOptions {
-	visited := make(map[termID]struct{}, len(o.visited))
-	for k, v := range o.visited {
-		visited[k] = v
-	}
-	o.visited = visited
-	return &o
-}
-
 func (o WriteOptions) withPriority(priority Integer) *WriteOptions {
 	o.priority = priority
 	return &o
@@ -93,6 +92,57 @@ type termIDer interface {
 // termID is an identifier for a Term.
 type termID interface{}
 
+// visitThreshold is the number of compounds a traversal goes through before it starts remembering them.
+// Most terms are small and finite so that they don't pay for the bookkeeping.
+// Traversals of rational trees still terminate since they eventually come back to a remembered compound.
+const visitThreshold = 64
+
+// visitedTerms remembers compounds visited while traversing a term.
+type visitedTerms struct {
+	n     int
+	terms map[termID]struct{}
+}
+
+// visit reports whether c has been visited before and remembers it.
+func (v *visitedTerms) visit(c Compound) bool {
+	if v.n < visitThreshold {
+		v.n++
+		return false
+	}
+	if v.terms == nil {
+		v.terms = map[termID]struct{}{}
+	}
+	k := id(c)
+	if _, ok := v.terms[k]; ok {
+		return true
+	}
+	v.terms[k] = struct{}{}
+	return false
+}
+
+// visitedPairs remembers pairs of compounds visited while traversing two terms side by side.
+type visitedPairs struct {
+	n     int
+	pairs map[[2]termID]struct{}
+}
+
+// visit reports whether the pair of x and y has been visited before and remembers it.
+func (v *visitedPairs) visit(x, y Compound) bool {
+	if v.n < visitThreshold {
+		v.n++
+		return false
+	}
+	if v.pairs == nil {
+		v.pairs = map[[2]termID]struct{}{}
+	}
+	k := [2]termID{id(x), id(y)}
+	if _, ok := v.pairs[k]; ok {
+		return true
+	}
+	v.pairs[k] = struct{}{}
+	return false
+}
+
 // id returns a termID for the Term.
 func id(t Term) termID {
 	switch t := t.(type) {
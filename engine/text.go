@@ -2,60 +2,260 @@ package engine
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"path"
+	"sort"
 	"strings"
+	"time"
 )
 
-// discontiguousError is an error that the user-defined predicate is defined by clauses which are not consecutive read-terms.
-type discontiguousError struct {
-	pi procedureIndicator
+var (
+	errNoIf           = errors.New("no matching if directive")
+	errElseAfterElse  = errors.New("else directive after else directive")
+	errUnterminatedIf = errors.New("unterminated if directive")
+)
+
+// WarningKind is a kind of Warning.
+type WarningKind int8
+
+const (
+	// WarningSingletons is a warning that a clause contains variables which appear only once.
+	WarningSingletons WarningKind = iota
+	// WarningDiscontiguous is a warning that a procedure is defined by clauses which are not consecutive read-terms.
+	WarningDiscontiguous
+	// WarningRedefined is a warning that a procedure defined in another file is replaced.
+	WarningRedefined
+	// WarningUnusedDynamic is a warning that a procedure is declared dynamic but never used in the text.
+	WarningUnusedDynamic
+)
+
+// Warning is a suspicious construct found in a Prolog text which doesn't prevent the text from being loaded.
+type Warning struct {
+	Kind WarningKind
+
+	// File is the name of the file where it's found. It's empty if the text is not from a file.
+	File     string
+	Position Position
+
+	// Procedure is the predicate indicator of the procedure in question, if any.
+	Procedure Term
+
+	// Variables are the names of the singleton variables for WarningSingletons.
+	Variables []string
+
+	// PreviousFile is the name of the file which previously defined the procedure for WarningRedefined.
+	PreviousFile string
+}
+
+func (w Warning) String() string {
+	var proc strings.Builder
+	if w.Procedure != nil {
+		_ = w.Procedure.WriteTerm(&proc, &defaultWriteOptions, nil)
+	}
+
+	var sb strings.Builder
+	if w.File != "" {
+		_, _ = fmt.Fprintf(&sb, "%s:%d: ", w.File, w.Position.Line)
+	} else {
+		_, _ = fmt.Fprintf(&sb, "%s: ", w.Position)
+	}
+	switch w.Kind {
+	case WarningSingletons:
+		_, _ = fmt.Fprintf(&sb, "singleton variables [%s]", strings.Join(w.Variables, ","))
+		if w.Procedure != nil {
+			_, _ = fmt.Fprintf(&sb, " in %s", &proc)
+		}
+	case WarningDiscontiguous:
+		_, _ = fmt.Fprintf(&sb, "clauses of %s are not together", &proc)
+	case WarningRedefined:
+		_, _ = fmt.Fprintf(&sb, "%s is redefined (previously loaded from %s)", &proc, w.PreviousFile)
+	case WarningUnusedDynamic:
+		_, _ = fmt.Fprintf(&sb, "%s is declared dynamic but never used", &proc)
+	}
+	return sb.String()
 }
 
-func (e *discontiguousError) Error() string {
-	return fmt.Sprintf("%s is discontiguous", e.pi)
+func (vm *VM) warn(w Warning) {
+	if vm.Warn == nil {
+		return
+	}
+	vm.Warn(w)
+}
+
+// ClauseErr is an error caused by a clause or a directive which begins at Position.
+type ClauseErr struct {
+	Position Position
+	Err      error
+}
+
+func (e *ClauseErr) Error() string {
+	return fmt.Sprintf("%s: %v", e.Position, e.Err)
+}
+
+func (e *ClauseErr) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is a list of errors found while compiling a Prolog text.
+// The elements are either *SyntaxErr or *ClauseErr unless it's an error from other files.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	var sb strings.Builder
+	for i, e := range l {
+		if i > 0 {
+			_ = sb.WriteByte('\n')
+		}
+		_, _ = sb.WriteString(e.Error())
+	}
+	return sb.String()
+}
+
+// Unwrap returns the errors in the list.
+func (l ErrorList) Unwrap() []error {
+	return l
+}
+
+func (l *ErrorList) add(err error) {
+	if el, ok := err.(ErrorList); ok {
+		*l = append(*l, el...)
+		return
+	}
+	*l = append(*l, err)
+}
+
+func (l ErrorList) err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
 }
 
 // Compile compiles the Prolog text and updates the DB accordingly.
+// Even if it finds errors in the text, it compiles the rest of the text and returns all of them as an ErrorList.
 func (vm *VM) Compile(ctx context.Context, s string, args ...interface{}) error {
-	var t text
+	return vm.compileFile(ctx, "", s, args...)
+}
+
+func (vm *VM) compileFile(ctx context.Context, file string, s string, args ...interface{}) error {
+	var (
+		t    = text{vm: vm, source: file, file: file}
+		errs ErrorList
+	)
+
+	loading := vm.loading
+	vm.loading = &t
+	defer func() {
+		vm.loading = loading
+	}()
+
 	if err := vm.compile(ctx, &t, s, args...); err != nil {
-		return err
+		if _, ok := err.(ErrorList); !ok {
+			return err
+		}
+		errs.add(err)
 	}
 
-	if err := t.flush(); err != nil {
-		return err
-	}
+	t.flush()
+	t.checkDynamic()
+
+	vm.install(&t)
 
+	return vm.initialize(ctx, &t, errs)
+}
+
+// install adds the procedures defined in the text to the DB.
+func (vm *VM) install(t *text) {
 	if vm.procedures == nil {
 		vm.procedures = map[procedureIndicator]procedure{}
 	}
+	vm.installTo(vm.procedures, t)
+}
+
+// installTo adds the procedures defined in the text to the procedure table procs.
+func (vm *VM) installTo(procs map[procedureIndicator]procedure, t *text) {
+	lf := vm.loaded[t.source]
 	for pi, u := range t.clauses {
-		if existing, ok := vm.procedures[pi].(*userDefined); ok && existing.multifile && u.multifile {
+		if t.source != "" {
+			for i := range u.clauses {
+				u.clauses[i].file = t.source
+			}
+		}
+
+		existing, ok := procs[pi].(*userDefined)
+		if ok && existing.multifile && u.multifile {
 			existing.clauses = append(existing.clauses, u.clauses...)
+			existing.invalidate()
+			if lf != nil {
+				lf.procedures[pi] = existing
+			}
 			continue
 		}
+		if ok && len(existing.clauses) > 0 && len(u.clauses) > 0 && existing.file != "" && existing.file != u.file {
+			vm.warn(Warning{
+				Kind:         WarningRedefined,
+				File:         u.file,
+				Position:     t.defined[pi],
+				Procedure:    pi.Term(),
+				PreviousFile: existing.file,
+			})
+		}
 
-		vm.procedures[pi] = u
+		procs[pi] = u
+		if lf != nil {
+			lf.procedures[pi] = u
+		}
 	}
 
+	if lf != nil {
+		for _, g := range t.goals {
+			lf.goals = append(lf.goals, g.goal)
+		}
+		for f, s := range t.includes {
+			lf.includes[f] = s
+		}
+	}
+}
+
+// LoadedFiles returns the files loaded by consult/1, ensure_loaded/1, and the like in the order they're first loaded.
+func (vm *VM) LoadedFiles() []string {
+	files := make([]string, 0, len(vm.loaded))
+	for f := range vm.loaded {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return vm.loaded[files[i]].order < vm.loaded[files[j]].order
+	})
+	return files
+}
+
+// initialize runs the initialization goals in the text and returns errs with the errors caused by them.
+func (vm *VM) initialize(ctx context.Context, t *text, errs ErrorList) error {
 	for _, g := range t.goals {
-		ok, err := Call(vm, g, Success, nil).Force(ctx)
+		ok, err := Call(vm, g.goal, Success, NewEnv().WithContext(ctx)).Force(ctx)
 		if err != nil {
-			return err
+			if ctx.Err() != nil {
+				return err
+			}
+			errs.add(&ClauseErr{Position: g.pos, Err: err})
+			continue
 		}
 		if !ok {
 			var sb strings.Builder
 			s := NewOutputTextStream(&sb)
-			_, _ = WriteTerm(vm, s, g, List(atomQuoted.Apply(atomTrue)), Success, nil).Force(ctx)
-			return fmt.Errorf("failed initialization goal: %s", sb.String())
+			_, _ = WriteTerm(vm, s, g.goal, List(atomQuoted.Apply(atomTrue)), Success, nil).Force(ctx)
+			errs.add(&ClauseErr{Position: g.pos, Err: fmt.Errorf("failed initialization goal: %s", sb.String())})
 		}
 	}
 
-	return nil
+	return errs.err()
 }
 
-// Consult executes Prolog texts in files.
+// Consult executes Prolog texts in files. If a file is already loaded, it replaces the procedures the file defined.
 func Consult(vm *VM, files Term, k Cont, env *Env) *Promise {
 	var filenames []Term
 	iter := ListIterator{List: files, Env: env}
@@ -68,7 +268,11 @@ func Consult(vm *VM, files Term, k Cont, env *Env) *Promise {
 
 	return Delay(func(ctx context.Context) *Promise {
 		for _, filename := range filenames {
-			if err := vm.ensureLoaded(ctx, filename, env); err != nil {
+			f, b, err := vm.open(ctx, filename, env)
+			if err != nil {
+				return Error(err)
+			}
+			if err := vm.reload(ctx, f, b); err != nil {
 				return Error(err)
 			}
 		}
@@ -88,85 +292,214 @@ func (vm *VM) compile(ctx context.Context, text *text, s string, args ...interfa
 		return err
 	}
 
+	var errs ErrorList
+	depth := len(text.conds)
 	for p.More() {
-		p.Vars = p.Vars[:]
-		t, err := p.Term()
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		et, err := expand(vm, t, nil)
+		p.Vars = p.Vars[:0]
+		t, err := p.Term()
 		if err != nil {
-			return err
-		}
+			if err == io.EOF {
+				err = &SyntaxErr{Position: p.lexer.pos.Position(), Err: io.ErrUnexpectedEOF}
+			}
+			errs.add(err)
 
-		pi, arg, err := piArg(et, nil)
-		if err != nil {
-			return err
-		}
-		switch pi {
-		case procedureIndicator{name: atomIf, arity: 1}: // Directive
-			if err := vm.directive(ctx, text, arg(0)); err != nil {
-				return err
+			// Skip the rest of the erroneous clause and carry on with the next one.
+			if err := p.resync(); err != nil {
+				break
 			}
 			continue
-		case procedureIndicator{name: atomIf, arity: 2}: // Rule
-			pi, arg, err = piArg(arg(0), nil)
+		}
+
+		text.pos = p.termPosition()
+		if ok, err := vm.conditional(ctx, text, t); ok {
 			if err != nil {
-				return err
-			}
-			fallthrough
-		default:
-			if len(text.buf) > 0 && pi != text.buf[0].pi {
-				if err := text.flush(); err != nil {
+				if ctx.Err() != nil {
 					return err
 				}
+				errs.add(&ClauseErr{Position: text.pos, Err: err})
 			}
+			continue
+		}
+		if text.skipping() {
+			continue
+		}
 
-			cs, err := compile(et, nil)
-			if err != nil {
+		text.warnSingletons(t, p.Vars)
+		if err := vm.compileClause(ctx, text, t); err != nil {
+			if ctx.Err() != nil {
 				return err
 			}
+			switch err.(type) {
+			case ErrorList, *ClauseErr:
+				errs.add(err)
+			default:
+				errs.add(&ClauseErr{Position: text.pos, Err: err})
+			}
+		}
+	}
+
+	// Conditional compilation doesn't span across files.
+	for _, c := range text.conds[depth:] {
+		errs.add(&ClauseErr{Position: c.pos, Err: errUnterminatedIf})
+	}
+	text.conds = text.conds[:depth]
+
+	return errs.err()
+}
+
+// conditional handles if/1, elif/1, else/0, and endif/0 directives and reports if t is one of them.
+func (vm *VM) conditional(ctx context.Context, text *text, t Term) (bool, error) {
+	pi, arg, err := piArg(t, nil)
+	if err != nil || pi != (procedureIndicator{name: atomIf, arity: 1}) {
+		return false, nil
+	}
 
-			text.buf = append(text.buf, cs...)
+	switch pi, arg, _ := piArg(arg(0), nil); pi {
+	case procedureIndicator{name: atomIfDirective, arity: 1}:
+		if text.skipping() {
+			// The whole if/elif/else/endif is skipped.
+			text.conds = append(text.conds, condition{pos: text.pos, done: true})
+			return true, nil
 		}
+		ok, err := Call(vm, arg(0), Success, NewEnv().WithContext(ctx)).Force(ctx)
+		text.conds = append(text.conds, condition{pos: text.pos, active: ok, done: ok})
+		return true, err
+	case procedureIndicator{name: atomElif, arity: 1}:
+		if len(text.conds) == 0 {
+			return true, errNoIf
+		}
+		c := &text.conds[len(text.conds)-1]
+		if c.sawElse {
+			return true, errElseAfterElse
+		}
+		if c.done {
+			c.active = false
+			return true, nil
+		}
+		ok, err := Call(vm, arg(0), Success, NewEnv().WithContext(ctx)).Force(ctx)
+		c.active, c.done = ok, ok
+		return true, err
+	case procedureIndicator{name: atomElse, arity: 0}:
+		if len(text.conds) == 0 {
+			return true, errNoIf
+		}
+		c := &text.conds[len(text.conds)-1]
+		if c.sawElse {
+			return true, errElseAfterElse
+		}
+		c.active, c.done, c.sawElse = !c.done, true, true
+		return true, nil
+	case procedureIndicator{name: atomEndif, arity: 0}:
+		if len(text.conds) == 0 {
+			return true, errNoIf
+		}
+		text.conds = text.conds[:len(text.conds)-1]
+		return true, nil
+	default:
+		return false, nil
 	}
-	return nil
 }
 
-func (vm *VM) directive(ctx context.Context, text *text, d Term) error {
-	if err := text.flush(); err != nil {
+func (vm *VM) compileClause(ctx context.Context, text *text, t Term) error {
+	et, err := expand(vm, t, nil)
+	if err != nil {
 		return err
 	}
 
+	pi, arg, err := piArg(et, nil)
+	if err != nil {
+		return err
+	}
+	switch pi {
+	case procedureIndicator{name: atomIf, arity: 1}: // Directive
+		d, err := expandGoal(ctx, vm, arg(0), 0, nil)
+		if err != nil {
+			return err
+		}
+		return vm.directive(ctx, text, d)
+	case procedureIndicator{name: atomIf, arity: 2}: // Rule
+		head, body := arg(0), arg(1)
+		body, err = expandGoal(ctx, vm, body, 0, nil)
+		if err != nil {
+			return err
+		}
+		et = atomIf.Apply(head, body)
+
+		pi, _, err = piArg(head, nil)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	default:
+		if len(text.buf) > 0 && pi != text.buf[0].pi {
+			text.flush()
+		}
+
+		cs, err := compile(et, nil)
+		if err != nil {
+			return err
+		}
+
+		if len(text.buf) == 0 {
+			text.bufFile, text.bufPos = text.file, text.pos
+		}
+		text.buf = append(text.buf, cs...)
+		return nil
+	}
+}
+
+func (vm *VM) directive(ctx context.Context, text *text, d Term) error {
+	text.flush()
+
 	switch pi, arg, _ := piArg(d, nil); pi {
 	case procedureIndicator{name: atomDynamic, arity: 1}:
-		return text.forEachUserDefined(arg(0), func(u *userDefined) {
+		return text.forEachUserDefined(arg(0), func(pi procedureIndicator, u *userDefined) {
 			u.dynamic = true
 			u.public = true
+			if _, ok := text.dynamic[pi]; !ok {
+				if text.dynamic == nil {
+					text.dynamic = map[procedureIndicator]Position{}
+				}
+				text.dynamic[pi] = text.pos
+			}
 		})
 	case procedureIndicator{name: atomMultifile, arity: 1}:
-		return text.forEachUserDefined(arg(0), func(u *userDefined) {
+		return text.forEachUserDefined(arg(0), func(_ procedureIndicator, u *userDefined) {
 			u.multifile = true
 		})
 	case procedureIndicator{name: atomDiscontiguous, arity: 1}:
-		return text.forEachUserDefined(arg(0), func(u *userDefined) {
+		return text.forEachUserDefined(arg(0), func(_ procedureIndicator, u *userDefined) {
 			u.discontiguous = true
 		})
 	case procedureIndicator{name: atomInitialization, arity: 1}:
-		text.goals = append(text.goals, arg(0))
+		text.goals = append(text.goals, textGoal{goal: arg(0), pos: text.pos})
 		return nil
 	case procedureIndicator{name: atomInclude, arity: 1}:
-		_, b, err := vm.open(arg(0), nil)
+		f, b, err := vm.openText(ctx, arg(0), []string{"", ".pl"}, nil)
 		if err != nil {
 			return err
 		}
+		if text.includes == nil {
+			text.includes = map[string]*fileStamp{}
+		}
+		s := vm.stamp(f, b)
+		text.includes[f] = &s
 
+		file := text.file
+		text.file = f
+		defer func() {
+			text.file = file
+		}()
 		return vm.compile(ctx, text, string(b))
 	case procedureIndicator{name: atomEnsureLoaded, arity: 1}:
 		return vm.ensureLoaded(ctx, arg(0), nil)
 	default:
-		ok, err := Call(vm, d, Success, nil).Force(ctx)
+		text.refs = append(text.refs, d)
+		ok, err := Call(vm, d, Success, NewEnv().WithContext(ctx)).Force(ctx)
 		if err != nil {
 			return err
 		}
@@ -181,51 +514,406 @@ func (vm *VM) directive(ctx context.Context, text *text, d Term) error {
 }
 
 func (vm *VM) ensureLoaded(ctx context.Context, file Term, env *Env) error {
-	f, b, err := vm.open(file, env)
+	f, b, err := vm.open(ctx, file, env)
 	if err != nil {
 		return err
 	}
 
-	if vm.loaded == nil {
-		vm.loaded = map[string]struct{}{}
-	}
 	if _, ok := vm.loaded[f]; ok {
 		return nil
 	}
+
+	return vm.load(ctx, f, b)
+}
+
+// loadedFile is a file loaded into the DB.
+type loadedFile struct {
+	order int // the files are reloaded in the order they're loaded first
+	fileStamp
+	procedures map[procedureIndicator]*userDefined // the procedures the file defines or adds clauses to
+	goals      []Term                              // the initialization goals
+	includes   map[string]*fileStamp               // the files included by include/1
+}
+
+func (vm *VM) newLoadedFile(f string, b []byte) *loadedFile {
+	return &loadedFile{
+		order:      len(vm.loaded),
+		fileStamp:  vm.stamp(f, b),
+		procedures: map[procedureIndicator]*userDefined{},
+		includes:   map[string]*fileStamp{},
+	}
+}
+
+// fileStamp identifies the content of a file when it's read.
+type fileStamp struct {
+	modTime time.Time
+	digest  [sha256.Size]byte
+}
+
+func (vm *VM) stamp(f string, b []byte) fileStamp {
+	s := fileStamp{digest: sha256.Sum256(b)}
+	if fi, err := fs.Stat(vm.FS, f); err == nil {
+		s.modTime = fi.ModTime()
+	}
+	return s
+}
+
+// load compiles the content b of the file f and records that f is loaded.
+func (vm *VM) load(ctx context.Context, f string, b []byte) error {
+	if path.Ext(f) == imageExt {
+		return vm.loadImageFile(ctx, f, b)
+	}
+	if vm.loaded == nil {
+		vm.loaded = map[string]*loadedFile{}
+	}
+	vm.loaded[f] = vm.newLoadedFile(f, b)
+
+	return vm.compileFile(ctx, f, string(b))
+}
+
+// reload replaces the procedures defined by the file f with the ones in the new content b.
+// If b contains errors, it keeps the procedures as they are and returns the errors.
+// The directives in b run while the old procedures are still in the DB, and their side effects remain even if b
+// contains errors. Like the other updates to the DB, it must not run alongside queries in other goroutines.
+func (vm *VM) reload(ctx context.Context, f string, b []byte) error {
+	if path.Ext(f) == imageExt {
+		return vm.loadImageFile(ctx, f, b)
+	}
+	if _, ok := vm.loaded[f]; !ok {
+		return vm.load(ctx, f, b)
+	}
+
+	t := text{vm: vm, source: f, file: f}
+	loading := vm.loading
+	vm.loading = &t
 	defer func() {
-		vm.loaded[f] = struct{}{}
+		vm.loading = loading
 	}()
 
-	return vm.Compile(ctx, string(b))
+	if err := vm.compile(ctx, &t, string(b)); err != nil {
+		return err
+	}
+	t.flush()
+	t.checkDynamic()
+
+	vm.replace(f, b, &t)
+
+	return vm.initialize(ctx, &t, nil)
 }
 
-func (vm *VM) open(file Term, env *Env) (string, []byte, error) {
-	switch f := env.Resolve(file).(type) {
-	case Variable:
-		return "", nil, InstantiationError(env)
-	case Atom:
-		s := f.String()
-		for _, f := range []string{s, s + ".pl"} {
+// replace replaces the procedures defined by the file f with the ones in the text t compiled from the new content b.
+// It updates a copy of the procedure table and swaps it in one step so that the DB never lacks the procedures of f.
+func (vm *VM) replace(f string, b []byte, t *text) {
+	if vm.loaded == nil {
+		vm.loaded = map[string]*loadedFile{}
+	}
+	procs := make(map[procedureIndicator]procedure, len(vm.procedures))
+	for pi, p := range vm.procedures {
+		procs[pi] = p
+	}
+	lf := vm.newLoadedFile(f, b)
+	if old, ok := vm.loaded[f]; ok {
+		vm.unload(procs, f)
+		lf.order = old.order
+	}
+	vm.loaded[f] = lf
+	vm.installTo(procs, t)
+	vm.procedures = procs
+}
+
+// unload removes the procedures and the clauses defined by the file f from the procedure table procs.
+func (vm *VM) unload(procs map[procedureIndicator]procedure, f string) {
+	lf, ok := vm.loaded[f]
+	if !ok {
+		return
+	}
+	for pi, u := range lf.procedures {
+		if u.multifile {
+			// The old clauses may be still in use by a running query. So we don't filter them in place.
+			cs := make(clauses, 0, len(u.clauses))
+			for _, c := range u.clauses {
+				if c.file != f {
+					cs = append(cs, c)
+				}
+			}
+			u.clauses = cs
+			u.invalidate()
+			continue
+		}
+
+		// Unless it's redefined by another file.
+		if procs[pi] == procedure(u) {
+			delete(procs, pi)
+		}
+	}
+}
+
+// modified checks if the file f or one of the files it includes is modified since it's loaded and returns the new
+// content of f if so.
+func (vm *VM) modified(f string) ([]byte, bool, error) {
+	lf := vm.loaded[f]
+	b, ok, err := vm.changed(f, &lf.fileStamp)
+	if err != nil || ok {
+		return b, ok, err
+	}
+	for inc, s := range lf.includes {
+		_, ok, err := vm.changed(inc, s)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
 			b, err := fs.ReadFile(vm.FS, f)
 			if err != nil {
+				return nil, false, err
+			}
+			return b, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// changed checks if the content of the file f differs from the one stamped with s and returns the new content if so.
+func (vm *VM) changed(f string, s *fileStamp) ([]byte, bool, error) {
+	fi, err := fs.Stat(vm.FS, f)
+	if err != nil {
+		return nil, false, err
+	}
+	if !s.modTime.IsZero() && fi.ModTime().Equal(s.modTime) {
+		return nil, false, nil
+	}
+	b, err := fs.ReadFile(vm.FS, f)
+	if err != nil {
+		return nil, false, err
+	}
+	if sha256.Sum256(b) == s.digest {
+		s.modTime = fi.ModTime()
+		return nil, false, nil
+	}
+	return b, true, nil
+}
+
+// Make reloads the files which are modified since they're loaded.
+// It must not run alongside queries in other goroutines since it updates the DB.
+func Make(vm *VM, k Cont, env *Env) *Promise {
+	return Delay(func(ctx context.Context) *Promise {
+		var errs ErrorList
+		for _, f := range vm.LoadedFiles() {
+			b, ok, err := vm.modified(f)
+			if err != nil {
+				errs.add(err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			if err := vm.reload(ctx, f, b); err != nil {
+				if ctx.Err() != nil {
+					return Error(err)
+				}
+				errs.add(err)
+			}
+		}
+		if err := errs.err(); err != nil {
+			return Error(err)
+		}
+		return k(env)
+	})
+}
+
+// ReloadFile loads the file replacing the procedures it previously defined.
+func ReloadFile(vm *VM, file Term, k Cont, env *Env) *Promise {
+	return Delay(func(ctx context.Context) *Promise {
+		f, b, err := vm.open(ctx, file, env)
+		if err != nil {
+			return Error(err)
+		}
+		if err := vm.reload(ctx, f, b); err != nil {
+			return Error(err)
+		}
+		return k(env)
+	})
+}
+
+func (vm *VM) open(ctx context.Context, file Term, env *Env) (string, []byte, error) {
+	return vm.openText(ctx, file, []string{"", imageExt, ".pl"}, env)
+}
+
+// openText reads the first existing file among the candidates with the extensions exts.
+func (vm *VM) openText(ctx context.Context, file Term, exts []string, env *Env) (string, []byte, error) {
+	ps, err := vm.filePaths(ctx, file, exts, vm.loadingDir(), env)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, p := range ps {
+		if vm.staleImage(p) {
+			continue
+		}
+		b, err := fs.ReadFile(vm.FS, p)
+		if err != nil {
+			continue
+		}
+
+		return p, b, nil
+	}
+	return "", nil, existenceError(objectTypeSourceSink, file, env)
+}
+
+// loadingDir returns the directory of the file being loaded, if any.
+func (vm *VM) loadingDir() string {
+	if vm.loading == nil || vm.loading.file == "" {
+		return ""
+	}
+	return path.Dir(vm.loading.file)
+}
+
+// maxFileSearchPathDepth is the limit of nested aliases in file_search_path/2.
+const maxFileSearchPathDepth = 16
+
+// filePaths returns the candidate paths of the file specified by spec in the order of preference.
+// spec is either a path or an alias spec Alias(Path) which is resolved by file_search_path/2.
+// A relative path is resolved against dir first then the current directory.
+func (vm *VM) filePaths(ctx context.Context, spec Term, exts []string, dir string, env *Env) ([]string, error) {
+	bases, err := vm.expandFileSpec(ctx, spec, dir, env, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var ps []string
+	seen := map[string]struct{}{}
+	for _, b := range bases {
+		for _, e := range exts {
+			p := b + e
+			if _, ok := seen[p]; ok {
 				continue
 			}
+			seen[p] = struct{}{}
+			ps = append(ps, p)
+		}
+	}
+	return ps, nil
+}
 
-			return f, b, nil
+func (vm *VM) expandFileSpec(ctx context.Context, spec Term, dir string, env *Env, depth int) ([]string, error) {
+	switch s := env.Resolve(spec).(type) {
+	case Variable:
+		return nil, InstantiationError(env)
+	case Atom:
+		p := s.String()
+		if dir == "" || path.IsAbs(p) {
+			return []string{path.Clean(p)}, nil
+		}
+		return []string{path.Join(dir, p), path.Clean(p)}, nil
+	case Compound:
+		if s.Arity() != 1 {
+			return nil, typeError(validTypeAtom, spec, env)
 		}
-		return "", nil, existenceError(objectTypeSourceSink, file, env)
+		sub, err := segments(s.Arg(0), env)
+		if err != nil {
+			return nil, err
+		}
+		if depth >= maxFileSearchPathDepth {
+			return nil, nil
+		}
+		if _, ok := vm.procedures[procedureIndicator{name: atomFileSearchPath, arity: 2}]; !ok {
+			return nil, nil
+		}
+
+		var dirs []Term
+		v := NewVariable()
+		if _, err := Call(vm, atomFileSearchPath.Apply(s.Functor(), v), func(env *Env) *Promise {
+			dirs = append(dirs, env.simplify(v))
+			return Bool(false)
+		}, env).Force(ctx); err != nil {
+			return nil, err
+		}
+
+		var ps []string
+		for _, d := range dirs {
+			// The directories in file_search_path/2 are relative to the current directory.
+			ds, err := vm.expandFileSpec(ctx, d, "", nil, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range ds {
+				ps = append(ps, path.Join(d, sub))
+			}
+		}
+		return ps, nil
+	default:
+		return nil, typeError(validTypeAtom, spec, env)
+	}
+}
+
+// segments returns a path from either an atom or a term of the form a/b/c.
+func segments(t Term, env *Env) (string, error) {
+	switch t := env.Resolve(t).(type) {
+	case Variable:
+		return "", InstantiationError(env)
+	case Atom:
+		return t.String(), nil
+	case Compound:
+		if t.Functor() != atomSlash || t.Arity() != 2 {
+			return "", typeError(validTypeAtom, t, env)
+		}
+		dir, err := segments(t.Arg(0), env)
+		if err != nil {
+			return "", err
+		}
+		base, err := segments(t.Arg(1), env)
+		if err != nil {
+			return "", err
+		}
+		return path.Join(dir, base), nil
 	default:
-		return "", nil, typeError(validTypeAtom, file, env)
+		return "", typeError(validTypeAtom, t, env)
 	}
 }
 
 type text struct {
+	vm      *VM
+	source  string // the file being loaded
+	file    string // the file being read which may differ from source if it's included
 	buf     clauses
 	clauses map[procedureIndicator]*userDefined
-	goals   []Term
+	goals   []textGoal
+	pos     Position // where the current clause begins
+	bufFile string   // where the first clause in buf is read from
+	bufPos  Position // where the first clause in buf begins
+
+	defined map[procedureIndicator]Position // where the procedures are first defined
+	dynamic map[procedureIndicator]Position // where the procedures are declared dynamic
+	refs    []Term                          // directives which may refer to dynamic procedures
+
+	conds []condition // nested if/elif/else/endif directives
+
+	includes map[string]*fileStamp // the files included by include/1
+}
+
+// condition is a state of an if/elif/else/endif directive.
+type condition struct {
+	pos     Position // where the if directive is
+	active  bool     // true if the current branch is compiled
+	done    bool     // true if one of the branches is taken
+	sawElse bool
+}
+
+// skipping reports if the current clause is in a branch which is not taken.
+func (t *text) skipping() bool {
+	for _, c := range t.conds {
+		if !c.active {
+			return true
+		}
+	}
+	return false
+}
+
+// textGoal is an initialization goal and where it's declared.
+type textGoal struct {
+	goal Term
+	pos  Position
 }
 
-func (t *text) forEachUserDefined(pi Term, f func(u *userDefined)) error {
+func (t *text) forEachUserDefined(pi Term, f func(pi procedureIndicator, u *userDefined)) error {
 	iter := anyIterator{Any: pi}
 	for iter.Next() {
 		switch pi := iter.Current().(type) {
@@ -244,12 +932,7 @@ func (t *text) forEachUserDefined(pi Term, f func(u *userDefined)) error {
 					return InstantiationError(nil)
 				case Integer:
 					pi := procedureIndicator{name: n, arity: a}
-					u, ok := t.clauses[pi]
-					if !ok {
-						u = &userDefined{}
-						t.clauses[pi] = u
-					}
-					f(u)
+					f(pi, t.userDefined(pi))
 				default:
 					return typeError(validTypePredicateIndicator, pi, nil)
 				}
@@ -263,23 +946,143 @@ func (t *text) forEachUserDefined(pi Term, f func(u *userDefined)) error {
 	return iter.Err()
 }
 
-func (t *text) flush() error {
+func (t *text) userDefined(pi procedureIndicator) *userDefined {
+	u, ok := t.clauses[pi]
+	if !ok {
+		u = &userDefined{file: t.file}
+		t.clauses[pi] = u
+	}
+	return u
+}
+
+func (t *text) flush() {
 	if len(t.buf) == 0 {
-		return nil
+		return
 	}
 
 	pi := t.buf[0].pi
 	u, ok := t.clauses[pi]
 	if !ok {
-		u = &userDefined{}
+		u = &userDefined{file: t.bufFile}
 		t.clauses[pi] = u
 	}
 	if len(u.clauses) > 0 && !u.discontiguous {
-		return &discontiguousError{pi: pi}
+		t.vm.warn(Warning{
+			Kind:      WarningDiscontiguous,
+			File:      t.bufFile,
+			Position:  t.bufPos,
+			Procedure: pi.Term(),
+		})
+	}
+	if _, ok := t.defined[pi]; !ok {
+		if t.defined == nil {
+			t.defined = map[procedureIndicator]Position{}
+		}
+		t.defined[pi] = t.bufPos
 	}
 	u.clauses = append(u.clauses, t.buf...)
 	t.buf = t.buf[:0]
-	return nil
+}
+
+// warnSingletons warns about the named variables which appear only once in the clause.
+func (t *text) warnSingletons(clause Term, vars []ParsedVariable) {
+	var names []string
+	for _, v := range vars {
+		if v.Count == 1 && !strings.HasPrefix(v.Name.String(), "_") {
+			names = append(names, v.Name.String())
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	var proc Term
+	if pi, arg, err := piArg(clause, nil); err == nil {
+		switch pi {
+		case procedureIndicator{name: atomIf, arity: 1}: // Directive
+			break
+		case procedureIndicator{name: atomIf, arity: 2}: // Rule
+			if pi, _, err := piArg(arg(0), nil); err == nil {
+				proc = pi.Term()
+			}
+		default:
+			proc = pi.Term()
+		}
+	}
+
+	t.vm.warn(Warning{
+		Kind:      WarningSingletons,
+		File:      t.file,
+		Position:  t.pos,
+		Procedure: proc,
+		Variables: names,
+	})
+}
+
+// checkDynamic warns about the dynamic procedures which have neither clauses nor references in the text.
+func (t *text) checkDynamic() {
+	if len(t.dynamic) == 0 {
+		return
+	}
+
+	used := map[procedureIndicator]struct{}{}
+	var stack []Term
+	for _, u := range t.clauses {
+		for _, c := range u.clauses {
+			stack = append(stack, c.raw)
+		}
+	}
+	for _, g := range t.goals {
+		stack = append(stack, g.goal)
+	}
+	stack = append(stack, t.refs...)
+	for len(stack) > 0 {
+		var term Term
+		term, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		switch term := term.(type) {
+		case Atom:
+			used[procedureIndicator{name: term, arity: 0}] = struct{}{}
+		case Compound:
+			used[procedureIndicator{name: term.Functor(), arity: Integer(term.Arity())}] = struct{}{}
+			if pi, ok := specifiedPI(term); ok {
+				used[pi] = struct{}{}
+			}
+			for i := 0; i < term.Arity(); i++ {
+				stack = append(stack, term.Arg(i))
+			}
+		}
+	}
+
+	for pi, pos := range t.dynamic {
+		if u := t.clauses[pi]; len(u.clauses) > 0 {
+			continue
+		}
+		if _, ok := used[pi]; ok {
+			continue
+		}
+		t.vm.warn(Warning{
+			Kind:      WarningUnusedDynamic,
+			File:      t.file,
+			Position:  pos,
+			Procedure: pi.Term(),
+		})
+	}
+}
+
+// specifiedPI returns the procedure indicator that term specifies in the form of Name/Arity.
+func specifiedPI(term Compound) (procedureIndicator, bool) {
+	if term.Functor() != atomSlash || term.Arity() != 2 {
+		return procedureIndicator{}, false
+	}
+	n, ok := term.Arg(0).(Atom)
+	if !ok {
+		return procedureIndicator{}, false
+	}
+	a, ok := term.Arg(1).(Integer)
+	if !ok {
+		return procedureIndicator{}, false
+	}
+	return procedureIndicator{name: n, arity: a}, true
 }
 
 func ignoreShebangLine(query string) string {
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"io"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -267,6 +269,7 @@ foo(b).
 :- include('testdata/foo').
 `, result: map[procedureIndicator]procedure{
 			{name: NewAtom("foo"), arity: 0}: &userDefined{
+				file: "testdata/foo.pl",
 				clauses: clauses{
 					{
 						pi:  procedureIndicator{name: NewAtom("foo"), arity: 0},
@@ -295,6 +298,7 @@ foo(b).
 :- ensure_loaded('testdata/foo').
 `, result: map[procedureIndicator]procedure{
 			{name: NewAtom("foo"), arity: 0}: &userDefined{
+				file: "testdata/foo.pl",
 				clauses: clauses{
 					{
 						pi:  procedureIndicator{name: NewAtom("foo"), arity: 0},
@@ -302,6 +306,7 @@ foo(b).
 						bytecode: bytecode{
 							{opcode: opExit},
 						},
+						file: "testdata/foo.pl",
 					},
 				},
 			},
@@ -359,65 +364,48 @@ foo(?).
 `, args: []interface{}{nil}, err: errors.New("can't convert to term: <invalid reflect.Value>")},
 		{title: "error: syntax error", text: `
 foo().
-`, err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}},
+`, err: ErrorList{&SyntaxErr{Position: Position{Offset: 5, Line: 2, Column: 5}, Err: unexpectedTokenError{actual: Token{kind: tokenClose, val: ")"}}}}},
 		{title: "error: expansion error", text: `
 :- ensure_loaded('testdata/break_term_expansion').
 foo(a).
-`, err: Exception{term: NewAtom("ball")}},
+`, err: ErrorList{&ClauseErr{Position: Position{Offset: 52, Line: 3, Column: 1}, Err: Exception{term: NewAtom("ball")}}}},
 		{title: "error: variable fact", text: `
 X.
-`, err: InstantiationError(nil)},
+`, err: ErrorList{&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: InstantiationError(nil)}}},
 		{title: "error: variable rule", text: `
 X :- X.
-`, err: InstantiationError(nil)},
+`, err: ErrorList{&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: InstantiationError(nil)}}},
 		{title: "error: non-callable rule body", text: `
 foo :- 1.
-`, err: typeError(validTypeCallable, Integer(1), nil)},
-		{title: "error: non-PI argument, variable", text: `:- dynamic(PI).`, err: InstantiationError(nil)},
-		{title: "error: non-PI argument, not compound", text: `:- dynamic(foo).`, err: typeError(validTypePredicateIndicator, NewAtom("foo"), nil)},
-		{title: "error: non-PI argument, compound", text: `:- dynamic(foo(a, b)).`, err: typeError(validTypePredicateIndicator, NewAtom("foo").Apply(NewAtom("a"), NewAtom("b")), nil)},
-		{title: "error: non-PI argument, name is variable", text: `:- dynamic(Name/2).`, err: InstantiationError(nil)},
-		{title: "error: non-PI argument, arity is variable", text: `:- dynamic(foo/Arity).`, err: InstantiationError(nil)},
-		{title: "error: non-PI argument, arity is not integer", text: `:- dynamic(foo/bar).`, err: typeError(validTypePredicateIndicator, atomSlash.Apply(NewAtom("foo"), NewAtom("bar")), nil)},
-		{title: "error: non-PI argument, name is not atom", text: `:- dynamic(0/2).`, err: typeError(validTypePredicateIndicator, atomSlash.Apply(Integer(0), Integer(2)), nil)},
+`, err: ErrorList{&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: typeError(validTypeCallable, Integer(1), nil)}}},
+		{title: "error: non-PI argument, variable", text: `:- dynamic(PI).`, err: ErrorList{&ClauseErr{Position: Position{Offset: 0, Line: 1, Column: 1}, Err: InstantiationError(nil)}}},
+		{title: "error: non-PI argument, not compound", text: `:- dynamic(foo).`, err: ErrorList{&ClauseErr{Position: Position{Offset: 0, Line: 1, Column: 1}, Err: typeError(validTypePredicateIndicator, NewAtom("foo"), nil)}}},
+		{title: "error: non-PI argument, compound", text: `:- dynamic(foo(a, b)).`, err: ErrorList{&ClauseErr{Position: Position{Offset: 0, Line: 1, Column: 1}, Err: typeError(validTypePredicateIndicator, NewAtom("foo").Apply(NewAtom("a"), NewAtom("b")), nil)}}},
+		{title: "error: non-PI argument, name is variable", text: `:- dynamic(Name/2).`, err: ErrorList{&ClauseErr{Position: Position{Offset: 0, Line: 1, Column: 1}, Err: InstantiationError(nil)}}},
+		{title: "error: non-PI argument, arity is variable", text: `:- dynamic(foo/Arity).`, err: ErrorList{&ClauseErr{Position: Position{Offset: 0, Line: 1, Column: 1}, Err: InstantiationError(nil)}}},
+		{title: "error: non-PI argument, arity is not integer", text: `:- dynamic(foo/bar).`, err: ErrorList{&ClauseErr{Position: Position{Offset: 0, Line: 1, Column: 1}, Err: typeError(validTypePredicateIndicator, atomSlash.Apply(NewAtom("foo"), NewAtom("bar")), nil)}}},
+		{title: "error: non-PI argument, name is not atom", text: `:- dynamic(0/2).`, err: ErrorList{&ClauseErr{Position: Position{Offset: 0, Line: 1, Column: 1}, Err: typeError(validTypePredicateIndicator, atomSlash.Apply(Integer(0), Integer(2)), nil)}}},
 		{title: "error: included variable", text: `
 :- include(X).
-`, err: InstantiationError(nil)},
+`, err: ErrorList{&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: InstantiationError(nil)}}},
 		{title: "error: included file not found", text: `
 :- include('testdata/not_found').
-`, err: existenceError(objectTypeSourceSink, NewAtom("testdata/not_found"), nil)},
+`, err: ErrorList{&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: existenceError(objectTypeSourceSink, NewAtom("testdata/not_found"), nil)}}},
 		{title: "error: included non-atom", text: `
 :- include(1).
-`, err: typeError(validTypeAtom, Integer(1), nil)},
+`, err: ErrorList{&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: typeError(validTypeAtom, Integer(1), nil)}}},
 		{title: "error: initialization exception", text: `
 :- initialization(bar).
-`, err: existenceError(objectTypeProcedure, atomSlash.Apply(NewAtom("bar"), Integer(0)), nil)},
+`, err: ErrorList{&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: existenceError(objectTypeProcedure, atomSlash.Apply(NewAtom("bar"), Integer(0)), nil)}}},
 		{title: "error: initialization failure", text: `
 :- initialization(foo(d)).
-`, err: errors.New("failed initialization goal: foo(d)")},
+`, err: ErrorList{&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: errors.New("failed initialization goal: foo(d)")}}},
 		{title: "error: predicate-backed directive exception", text: `
 :- bar.
-`, err: existenceError(objectTypeProcedure, atomSlash.Apply(NewAtom("bar"), Integer(0)), nil)},
+`, err: ErrorList{&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: existenceError(objectTypeProcedure, atomSlash.Apply(NewAtom("bar"), Integer(0)), nil)}}},
 		{title: "error: predicate-backed directive failure", text: `
 :- foo(d).
-`, err: errors.New("failed directive: foo(d)")},
-		{title: "error: discontiguous, end of text", text: `
-foo(a).
-bar(a).
-foo(b).
-`, err: &discontiguousError{pi: procedureIndicator{name: NewAtom("foo"), arity: 1}}},
-		{title: "error: discontiguous, before directive", text: `
-foo(a).
-bar(a).
-foo(b).
-:- foo(c).
-`, err: &discontiguousError{pi: procedureIndicator{name: NewAtom("foo"), arity: 1}}},
-		{title: "error: discontiguous, before other facts", text: `
-foo(a).
-bar(a).
-foo(b).
-bar(b).
-`, err: &discontiguousError{pi: procedureIndicator{name: NewAtom("foo"), arity: 1}}},
+`, err: ErrorList{&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: errors.New("failed directive: foo(d)")}}},
 	}
 
 	for _, tt := range tests {
@@ -454,6 +442,36 @@ bar(b).
 	}
 }
 
+func TestVM_Compile_recovery(t *testing.T) {
+	var vm VM
+	vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+	vm.operators.define(1200, operatorSpecifierFX, atomIf)
+	err := vm.Compile(context.Background(), `
+:- bar.
+foo(a).
+foo(b c).
+foo(c).
+foo(d.
+foo(e).
+`)
+	assert.Equal(t, ErrorList{
+		&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: existenceError(objectTypeProcedure, atomSlash.Apply(NewAtom("bar"), Integer(0)), nil)},
+		&SyntaxErr{Position: Position{Offset: 23, Line: 4, Column: 7}, Err: unexpectedTokenError{actual: Token{kind: tokenLetterDigit, val: "c"}}},
+		&SyntaxErr{Position: Position{Offset: 40, Line: 6, Column: 6}, Err: unexpectedTokenError{actual: Token{kind: tokenEnd, val: "."}}},
+	}, err)
+	assert.Equal(t, "2:1: error(existence_error(procedure,bar/0),root)\n4:7: unexpected token: letter digit(c)\n6:6: unexpected token: end(.)", err.Error())
+
+	var es []Term
+	for _, c := range vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined).clauses {
+		es = append(es, c.raw)
+	}
+	assert.Equal(t, []Term{
+		&compound{functor: NewAtom("foo"), args: []Term{NewAtom("a")}},
+		&compound{functor: NewAtom("foo"), args: []Term{NewAtom("c")}},
+		&compound{functor: NewAtom("foo"), args: []Term{NewAtom("e")}},
+	}, es)
+}
+
 func TestVM_Consult(t *testing.T) {
 	x := NewVariable()
 
@@ -468,11 +486,11 @@ func TestVM_Consult(t *testing.T) {
 		{title: `:- consult(['testdata/empty.txt']).`, files: List(NewAtom("testdata/empty.txt")), ok: true},
 		{title: `:- consult(['testdata/empty.txt', 'testdata/empty.txt']).`, files: List(NewAtom("testdata/empty.txt"), NewAtom("testdata/empty.txt")), ok: true},
 
-		{title: `:- consult('testdata/abc.txt').`, files: NewAtom("testdata/abc.txt"), err: io.EOF},
-		{title: `:- consult(['testdata/abc.txt']).`, files: List(NewAtom("testdata/abc.txt")), err: io.EOF},
+		{title: `:- consult('testdata/abc.txt').`, files: NewAtom("testdata/abc.txt"), err: ErrorList{&SyntaxErr{Position: Position{Offset: 3, Line: 1, Column: 4}, Err: io.ErrUnexpectedEOF}}},
+		{title: `:- consult(['testdata/abc.txt']).`, files: List(NewAtom("testdata/abc.txt")), err: ErrorList{&SyntaxErr{Position: Position{Offset: 3, Line: 1, Column: 4}, Err: io.ErrUnexpectedEOF}}},
 
 		{title: `:- consult(X).`, files: x, err: InstantiationError(nil)},
-		{title: `:- consult(foo(bar)).`, files: NewAtom("foo").Apply(NewAtom("bar")), err: typeError(validTypeAtom, NewAtom("foo").Apply(NewAtom("bar")), nil)},
+		{title: `:- consult(foo(bar)).`, files: NewAtom("foo").Apply(NewAtom("bar")), err: existenceError(objectTypeSourceSink, NewAtom("foo").Apply(NewAtom("bar")), nil)},
 		{title: `:- consult(1).`, files: Integer(1), err: typeError(validTypeAtom, Integer(1), nil)},
 		{title: `:- consult(['testdata/empty.txt'|_]).`, files: PartialList(NewVariable(), NewAtom("testdata/empty.txt")), err: typeError(validTypeAtom, PartialList(NewVariable(), NewAtom("testdata/empty.txt")), nil)},
 		{title: `:- consult([X]).`, files: List(x), err: InstantiationError(nil)},
@@ -499,7 +517,450 @@ func TestVM_Consult(t *testing.T) {
 	}
 }
 
-func TestDiscontiguousError_Error(t *testing.T) {
-	e := discontiguousError{pi: procedureIndicator{name: NewAtom("foo"), arity: 1}}
-	assert.Equal(t, "foo/1 is discontiguous", e.Error())
+func TestVM_Compile_fileSearchPath(t *testing.T) {
+	var vm VM
+	vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+	vm.operators.define(1200, operatorSpecifierFX, atomIf)
+	vm.operators.define(400, operatorSpecifierYFX, atomSlash)
+	vm.FS = fstest.MapFS{
+		"app/main.pl":                &fstest.MapFile{Data: []byte(":- ensure_loaded(helper).\n:- ensure_loaded(library(lists)).\n:- ensure_loaded(rules(policy/core)).\n")},
+		"app/helper.pl":              &fstest.MapFile{Data: []byte("helper.\n")},
+		"lib/lists.pl":               &fstest.MapFile{Data: []byte("lists.\n")},
+		"share/rules/policy/core.pl": &fstest.MapFile{Data: []byte("core.\n")},
+	}
+	assert.NoError(t, vm.Compile(context.Background(), `
+file_search_path(library, lib).
+file_search_path(rules, share(rules)).
+file_search_path(share, share).
+`))
+	assert.NoError(t, vm.Compile(context.Background(), `:- ensure_loaded('app/main').`))
+
+	for _, name := range []string{"helper", "lists", "core"} {
+		_, ok := vm.procedures[procedureIndicator{name: NewAtom(name), arity: 0}]
+		assert.True(t, ok, name)
+	}
+	var loaded []string
+	for f := range vm.loaded {
+		loaded = append(loaded, f)
+	}
+	assert.ElementsMatch(t, []string{"app/main.pl", "app/helper.pl", "lib/lists.pl", "share/rules/policy/core.pl"}, loaded)
+
+	t.Run("cancellation", func(t *testing.T) {
+		assert.NoError(t, vm.Compile(context.Background(), `
+file_search_path(loop, Dir) :- loop(Dir).
+loop(Dir) :- loop(Dir).
+`))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		ok, err := Consult(&vm, NewAtom("loop").Apply(NewAtom("foo")), Success, nil).Force(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestVM_Compile_conditional(t *testing.T) {
+	tests := []struct {
+		title string
+		text  string
+		foo   []Term
+		err   error
+	}{
+		{title: "if", text: `
+:- if(true).
+foo(a).
+:- endif.
+:- if(fail).
+foo(b).
+:- endif.
+`, foo: []Term{NewAtom("a")}},
+		{title: "else", text: `
+:- if(fail).
+foo(a).
+:- else.
+foo(b).
+:- endif.
+`, foo: []Term{NewAtom("b")}},
+		{title: "elif", text: `
+:- if(fail).
+foo(a).
+:- elif(fail).
+foo(b).
+:- elif(true).
+foo(c).
+:- elif(true).
+foo(d).
+:- else.
+foo(e).
+:- endif.
+foo(f).
+`, foo: []Term{NewAtom("c"), NewAtom("f")}},
+		{title: "nested", text: `
+:- if(true).
+:- if(fail).
+foo(a).
+:- else.
+foo(b).
+:- endif.
+:- else.
+:- if(true).
+foo(c).
+:- else.
+foo(d).
+:- endif.
+:- endif.
+`, foo: []Term{NewAtom("b")}},
+		{title: "skipped clauses are not evaluated", text: `
+:- if(fail).
+:- undefined.
+foo(a).
+:- endif.
+foo(b).
+`, foo: []Term{NewAtom("b")}},
+		{title: "predicates defined in Go", text: `
+:- if(predicate_property(go(_), defined)).
+foo(a).
+:- endif.
+:- if(predicate_property(missing(_), defined)).
+foo(b).
+:- endif.
+`, foo: []Term{NewAtom("a")}},
+		{title: "error: exception", text: `
+:- if(undefined).
+foo(a).
+:- else.
+foo(b).
+:- endif.
+`, foo: []Term{NewAtom("b")}, err: ErrorList{
+			&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: existenceError(objectTypeProcedure, atomSlash.Apply(NewAtom("undefined"), Integer(0)), nil)},
+		}},
+		{title: "error: no if", text: `
+foo(a).
+:- elif(true).
+:- else.
+:- endif.
+`, foo: []Term{NewAtom("a")}, err: ErrorList{
+			&ClauseErr{Position: Position{Offset: 9, Line: 3, Column: 1}, Err: errNoIf},
+			&ClauseErr{Position: Position{Offset: 24, Line: 4, Column: 1}, Err: errNoIf},
+			&ClauseErr{Position: Position{Offset: 33, Line: 5, Column: 1}, Err: errNoIf},
+		}},
+		{title: "error: else after else", text: `
+:- if(true).
+foo(a).
+:- else.
+foo(b).
+:- else.
+foo(c).
+:- elif(true).
+:- endif.
+`, foo: []Term{NewAtom("a")}, err: ErrorList{
+			&ClauseErr{Position: Position{Offset: 39, Line: 6, Column: 1}, Err: errElseAfterElse},
+			&ClauseErr{Position: Position{Offset: 56, Line: 8, Column: 1}, Err: errElseAfterElse},
+		}},
+		{title: "error: unterminated", text: `
+:- if(true).
+foo(a).
+`, foo: []Term{NewAtom("a")}, err: ErrorList{
+			&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: errUnterminatedIf},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			var vm VM
+			vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+			vm.operators.define(1200, operatorSpecifierFX, atomIf)
+			vm.Register0(atomTrue, func(_ *VM, k Cont, env *Env) *Promise {
+				return k(env)
+			})
+			vm.Register0(atomFail, func(*VM, Cont, *Env) *Promise {
+				return Bool(false)
+			})
+			vm.Register1(NewAtom("go"), func(_ *VM, _ Term, k Cont, env *Env) *Promise {
+				return k(env)
+			})
+			vm.Register2(NewAtom("predicate_property"), PredicateProperty)
+			assert.Equal(t, tt.err, vm.Compile(context.Background(), tt.text))
+
+			var foo []Term
+			u, _ := vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined)
+			if u != nil {
+				for _, c := range u.clauses {
+					foo = append(foo, c.raw.(Compound).Arg(0))
+				}
+			}
+			assert.Equal(t, tt.foo, foo)
+		})
+	}
+}
+
+func TestVM_Compile_goalExpansion(t *testing.T) {
+	var vm VM
+	vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+	vm.operators.define(1200, operatorSpecifierFX, atomIf)
+	vm.operators.define(1000, operatorSpecifierXFY, atomComma)
+	vm.operators.define(900, operatorSpecifierFY, atomNegation)
+	vm.operators.define(700, operatorSpecifierXFX, atomEqual)
+	vm.Register2(atomEqual, Unify)
+	assert.NoError(t, vm.Compile(context.Background(), `
+goal_expansion(level(X), X = 3).
+`))
+
+	assert.NoError(t, vm.Compile(context.Background(), `
+foo(X) :- level(X), \+ level(4).
+:- level(3).
+`))
+
+	raw := vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 1}].(*userDefined).clauses[0].raw
+	x := raw.(Compound).Arg(0).(Compound).Arg(0)
+	assert.Equal(t, atomIf.Apply(
+		NewAtom("foo").Apply(x),
+		atomComma.Apply(
+			atomEqual.Apply(x, Integer(3)),
+			atomNegation.Apply(atomEqual.Apply(Integer(4), Integer(3))),
+		),
+	), raw)
+
+	assert.NoError(t, vm.Compile(context.Background(), `
+goal_expansion(G, (G, true)).
+`))
+	assert.Equal(t, ErrorList{
+		&ClauseErr{Position: Position{Offset: 1, Line: 2, Column: 1}, Err: resourceError(resourceGoalExpansionDepth, nil)},
+	}, vm.Compile(context.Background(), `
+bar :- baz.
+`))
+}
+
+func TestVM_Compile_warnings(t *testing.T) {
+	tests := []struct {
+		title    string
+		text     string
+		warnings []Warning
+	}{
+		{title: "singletons", text: `
+foo(X, Y, _Z, _) :- bar(Y).
+bar(X).
+:- baz(X), baz(f(Y, Y)).
+`, warnings: []Warning{
+			{Kind: WarningSingletons, Position: Position{Offset: 1, Line: 2, Column: 1}, Procedure: procedureIndicator{name: NewAtom("foo"), arity: 4}.Term(), Variables: []string{"X"}},
+			{Kind: WarningSingletons, Position: Position{Offset: 29, Line: 3, Column: 1}, Procedure: procedureIndicator{name: NewAtom("bar"), arity: 1}.Term(), Variables: []string{"X"}},
+			{Kind: WarningSingletons, Position: Position{Offset: 37, Line: 4, Column: 1}, Variables: []string{"X"}},
+		}},
+		{title: "discontiguous", text: `
+foo(a).
+bar(a).
+foo(b).
+bar(b).
+`, warnings: []Warning{
+			{Kind: WarningDiscontiguous, Position: Position{Offset: 17, Line: 4, Column: 1}, Procedure: procedureIndicator{name: NewAtom("foo"), arity: 1}.Term()},
+			{Kind: WarningDiscontiguous, Position: Position{Offset: 25, Line: 5, Column: 1}, Procedure: procedureIndicator{name: NewAtom("bar"), arity: 1}.Term()},
+		}},
+		{title: "discontiguous, declared", text: `
+:- discontiguous(foo/1).
+foo(a).
+bar(a).
+foo(b).
+`},
+		{title: "unused dynamic", text: `
+:- dynamic(foo/1).
+:- dynamic(bar/1).
+:- dynamic(baz/1).
+:- dynamic(qux/1).
+bar(a).
+baz :- baz(_).
+:- initialization(retractall(qux(_))).
+`, warnings: []Warning{
+			{Kind: WarningUnusedDynamic, Position: Position{Offset: 1, Line: 2, Column: 1}, Procedure: procedureIndicator{name: NewAtom("foo"), arity: 1}.Term()},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			var vm VM
+			vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+			vm.operators.define(1200, operatorSpecifierFX, atomIf)
+			vm.operators.define(1000, operatorSpecifierXFY, atomComma)
+			vm.operators.define(400, operatorSpecifierYFX, atomSlash)
+			vm.Register1(NewAtom("retractall"), func(_ *VM, _ Term, k Cont, env *Env) *Promise {
+				return k(env)
+			})
+			vm.Register1(NewAtom("baz"), func(_ *VM, _ Term, k Cont, env *Env) *Promise {
+				return k(env)
+			})
+			var warnings []Warning
+			vm.Warn = func(w Warning) {
+				warnings = append(warnings, w)
+			}
+			assert.NoError(t, vm.Compile(context.Background(), tt.text))
+			assert.Equal(t, tt.warnings, warnings)
+		})
+	}
+
+	t.Run("redefined", func(t *testing.T) {
+		var vm VM
+		vm.operators.define(1200, operatorSpecifierFX, atomIf)
+		vm.FS = fstest.MapFS{
+			"a.pl": &fstest.MapFile{Data: []byte("foo(a).\n")},
+			"b.pl": &fstest.MapFile{Data: []byte("bar(b).\nfoo(b).\n")},
+		}
+		var warnings []Warning
+		vm.Warn = func(w Warning) {
+			warnings = append(warnings, w)
+		}
+		assert.NoError(t, vm.Compile(context.Background(), `
+:- ensure_loaded(a).
+:- ensure_loaded(b).
+`))
+		assert.Equal(t, []Warning{
+			{Kind: WarningRedefined, File: "b.pl", Position: Position{Offset: 8, Line: 2, Column: 1}, Procedure: procedureIndicator{name: NewAtom("foo"), arity: 1}.Term(), PreviousFile: "a.pl"},
+		}, warnings)
+	})
+}
+
+func TestWarning_String(t *testing.T) {
+	foo := procedureIndicator{name: NewAtom("foo"), arity: 1}.Term()
+	tests := []struct {
+		warning Warning
+		output  string
+	}{
+		{warning: Warning{Kind: WarningSingletons, File: "a.pl", Position: Position{Offset: 10, Line: 2, Column: 3}, Procedure: foo, Variables: []string{"X", "Y"}}, output: "a.pl:2: singleton variables [X,Y] in foo/1"},
+		{warning: Warning{Kind: WarningSingletons, Position: Position{Offset: 10, Line: 2, Column: 3}, Variables: []string{"X"}}, output: "2:3: singleton variables [X]"},
+		{warning: Warning{Kind: WarningDiscontiguous, File: "a.pl", Position: Position{Line: 4}, Procedure: foo}, output: "a.pl:4: clauses of foo/1 are not together"},
+		{warning: Warning{Kind: WarningRedefined, File: "b.pl", Position: Position{Line: 1}, Procedure: foo, PreviousFile: "a.pl"}, output: "b.pl:1: foo/1 is redefined (previously loaded from a.pl)"},
+		{warning: Warning{Kind: WarningUnusedDynamic, File: "a.pl", Position: Position{Line: 5}, Procedure: foo}, output: "a.pl:5: foo/1 is declared dynamic but never used"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			assert.Equal(t, tt.output, tt.warning.String())
+		})
+	}
+}
+
+func TestMake(t *testing.T) {
+	var vm VM
+	vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+	vm.operators.define(1200, operatorSpecifierFX, atomIf)
+	vm.operators.define(400, operatorSpecifierYFX, atomSlash)
+	fsys := fstest.MapFS{
+		"a.pl": &fstest.MapFile{Data: []byte(":- multifile(m/1).\nfoo(1).\nfoo(2).\nm(a).\n"), ModTime: time.Unix(1, 0)},
+		"b.pl": &fstest.MapFile{Data: []byte(":- multifile(m/1).\nm(b).\nbar.\n"), ModTime: time.Unix(1, 0)},
+	}
+	vm.FS = fsys
+
+	clauses := func(name string, arity Integer) []Term {
+		u, ok := vm.procedures[procedureIndicator{name: NewAtom(name), arity: arity}].(*userDefined)
+		if !ok {
+			return nil
+		}
+		ts := make([]Term, len(u.clauses))
+		for i, c := range u.clauses {
+			ts[i] = c.raw
+		}
+		return ts
+	}
+	f, m := NewAtom("foo"), NewAtom("m")
+
+	ok, err := Consult(&vm, List(NewAtom("a"), NewAtom("b")), Success, nil).Force(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []Term{f.Apply(Integer(1)), f.Apply(Integer(2))}, clauses("foo", 1))
+	assert.Equal(t, []Term{m.Apply(NewAtom("a")), m.Apply(NewAtom("b"))}, clauses("m", 1))
+
+	t.Run("modified", func(t *testing.T) {
+		fsys["a.pl"] = &fstest.MapFile{Data: []byte(":- multifile(m/1).\nfoo(3).\nm(c).\n"), ModTime: time.Unix(2, 0)}
+		ok, err := Make(&vm, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []Term{f.Apply(Integer(3))}, clauses("foo", 1))
+		assert.Equal(t, []Term{m.Apply(NewAtom("b")), m.Apply(NewAtom("c"))}, clauses("m", 1))
+		assert.Equal(t, []Term{NewAtom("bar")}, clauses("bar", 0))
+	})
+
+	t.Run("same content", func(t *testing.T) {
+		fsys["b.pl"] = &fstest.MapFile{Data: fsys["b.pl"].Data, ModTime: time.Unix(2, 0)}
+		ok, err := Make(&vm, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []Term{m.Apply(NewAtom("b")), m.Apply(NewAtom("c"))}, clauses("m", 1))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		fsys["a.pl"] = &fstest.MapFile{Data: []byte("foo(4).\nfoo(.\n"), ModTime: time.Unix(3, 0)}
+		ok, err := Make(&vm, Success, nil).Force(context.Background())
+		assert.Equal(t, ErrorList{
+			&SyntaxErr{Position: Position{Offset: 12, Line: 2, Column: 5}, Err: unexpectedTokenError{actual: Token{kind: tokenEnd, val: "."}}},
+		}, err)
+		assert.False(t, ok)
+		assert.Equal(t, []Term{f.Apply(Integer(3))}, clauses("foo", 1))
+	})
+
+	t.Run("failed part-way", func(t *testing.T) {
+		fsys["a.pl"] = &fstest.MapFile{Data: []byte(":- multifile(m/1).\nm(d).\nfoo(4).\nfoo(.\nfoo(5).\n"), ModTime: time.Unix(4, 0)}
+		ok, err := Make(&vm, Success, nil).Force(context.Background())
+		assert.Error(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, []Term{f.Apply(Integer(3))}, clauses("foo", 1))
+		assert.Equal(t, []Term{m.Apply(NewAtom("b")), m.Apply(NewAtom("c"))}, clauses("m", 1))
+		assert.Equal(t, []Term{NewAtom("bar")}, clauses("bar", 0))
+	})
+
+	t.Run("clauses in use", func(t *testing.T) {
+		inUse := vm.procedures[procedureIndicator{name: m, arity: 1}].(*userDefined).clauses
+		fsys["a.pl"] = &fstest.MapFile{Data: []byte(":- multifile(m/1).\nfoo(3).\nm(d).\n"), ModTime: time.Unix(5, 0)}
+		ok, err := Make(&vm, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []Term{m.Apply(NewAtom("b")), m.Apply(NewAtom("d"))}, clauses("m", 1))
+		assert.Equal(t, m.Apply(NewAtom("b")), inUse[0].raw)
+		assert.Equal(t, m.Apply(NewAtom("c")), inUse[1].raw)
+	})
+
+	t.Run("removed procedure", func(t *testing.T) {
+		fsys["a.pl"] = &fstest.MapFile{Data: []byte("baz.\n"), ModTime: time.Unix(6, 0)}
+		ok, err := Make(&vm, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Nil(t, clauses("foo", 1))
+		assert.Equal(t, []Term{NewAtom("baz")}, clauses("baz", 0))
+		assert.Equal(t, []Term{m.Apply(NewAtom("b"))}, clauses("m", 1))
+	})
+
+	t.Run("included file modified", func(t *testing.T) {
+		q := NewAtom("qux")
+		fsys["a.pl"] = &fstest.MapFile{Data: []byte(":- include(c).\nbaz.\n"), ModTime: time.Unix(7, 0)}
+		fsys["c.pl"] = &fstest.MapFile{Data: []byte("qux(1).\n"), ModTime: time.Unix(7, 0)}
+		ok, err := Make(&vm, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []Term{q.Apply(Integer(1))}, clauses("qux", 1))
+		assert.Contains(t, vm.loaded["a.pl"].includes, "c.pl")
+
+		fsys["c.pl"] = &fstest.MapFile{Data: []byte("qux(2).\n"), ModTime: time.Unix(8, 0)}
+		ok, err = Make(&vm, Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []Term{q.Apply(Integer(2))}, clauses("qux", 1))
+		assert.Equal(t, []Term{NewAtom("baz")}, clauses("baz", 0))
+	})
+}
+
+func TestReloadFile(t *testing.T) {
+	var vm VM
+	vm.operators.define(1200, operatorSpecifierFX, atomIf)
+	vm.FS = fstest.MapFS{
+		"a.pl": &fstest.MapFile{Data: []byte("foo.\n:- initialization(assertz(bar)).\n")},
+	}
+	vm.Register1(NewAtom("assertz"), Assertz)
+
+	for i := 0; i < 2; i++ {
+		ok, err := ReloadFile(&vm, NewAtom("a"), Success, nil).Force(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	assert.Len(t, vm.procedures[procedureIndicator{name: NewAtom("foo"), arity: 0}].(*userDefined).clauses, 1)
+	assert.Len(t, vm.procedures[procedureIndicator{name: NewAtom("bar"), arity: 0}].(*userDefined).clauses, 2)
+
+	ok, err := ReloadFile(&vm, NewAtom("b"), Success, nil).Force(context.Background())
+	assert.Equal(t, existenceError(objectTypeSourceSink, NewAtom("b"), nil), err)
+	assert.False(t, ok)
 }
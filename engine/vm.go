@@ -51,19 +51,28 @@ type VM struct {
 	// Unknown is a callback that is triggered when the VM reaches to an unknown predicate while current_prolog_flag(unknown, warning).
 	Unknown func(name Atom, args []Term, env *Env)
 
+	// Warn is a callback that is triggered when the VM finds a suspicious construct while loading a Prolog text.
+	Warn func(w Warning)
+
+	// Backend is an abstract machine that executes user-defined procedures. The default is BackendZIP.
+	Backend Backend
+
 	procedures map[procedureIndicator]procedure
 	unknown    unknownAction
 
 	// FS is a file system that is referenced when the VM loads Prolog texts e.g. ensure_loaded/1.
 	// It has no effect on open/4 nor open/3 which always access the actual file system.
-	FS     fs.FS
-	loaded map[string]struct{}
+	// Also, qcompile/1 reads the Prolog text through FS but writes the image to the actual file system.
+	FS      fs.FS
+	loaded  map[string]*loadedFile
+	loading *text // the Prolog text being loaded
 
 	// Internal/external expression
 	operators       operators
 	charConversions map[rune]rune
 	charConvEnabled bool
 	doubleQuotes    doubleQuotes
+	occursCheck     occursCheck
 
 	// I/O
 	streams       streams
@@ -161,6 +170,31 @@ func (u unknownAction) String() string {
 	}[u]
 }
 
+type occursCheck int
+
+const (
+	occursCheckFalse occursCheck = iota
+	occursCheckTrue
+	occursCheckError
+)
+
+func (o occursCheck) String() string {
+	return [...]string{
+		occursCheckFalse: "false",
+		occursCheckTrue:  "true",
+		occursCheckError: "error",
+	}[o]
+}
+
+// unify unifies x and y according to current_prolog_flag(occurs_check, _).
+func (vm *VM) unify(x, y Term, env *Env) (*Env, bool, error) {
+	var check occursCheck
+	if vm != nil {
+		check = vm.occursCheck
+	}
+	return env.unify(x, y, check)
+}
+
 type procedure interface {
 	call(*VM, []Term, Cont, *Env) *Promise
 }
@@ -191,12 +225,28 @@ func (vm *VM) Arrive(name Atom, args []Term, k Cont, env *Env) *Promise {
 	// bind the special variable to inform the predicate about the context.
 	env = env.bind(varContext, pi.Term())
 
-	return p.call(vm, args, k, env)
+	promise := p.call(vm, args, k, env)
+	if _, ok := p.(*userDefined); ok {
+		return promise
+	}
+
+	// A typed error, e.g. *TypeErr, returned by a Go predicate is raised as an exception in this context.
+	if promise.err != nil {
+		if e, ok := exceptionOf(promise.err, env); ok {
+			return Error(e)
+		}
+		return promise
+	}
+	if len(promise.delayed) > 0 {
+		promise.env = env
+	}
+	return promise
 }
 
 func (vm *VM) exec(pc bytecode, vars []Variable, cont Cont, args []Term, astack [][]Term, env *Env, cutParent *Promise) *Promise {
 	var (
 		ok  = true
+		err error
 		op  instruction
 		arg Term
 	)
@@ -205,13 +255,13 @@ func (vm *VM) exec(pc bytecode, vars []Variable, cont Cont, args []Term, astack
 		switch opcode, operand := op.opcode, op.operand; opcode {
 		case opGetConst:
 			arg, args = args[0], args[1:]
-			env, ok = env.Unify(arg, operand)
+			env, ok, err = vm.unify(arg, operand, env)
 		case opPutConst:
 			args = append(args, operand)
 		case opGetVar:
 			v := vars[operand.(Integer)]
 			arg, args = args[0], args[1:]
-			env, ok = env.Unify(arg, v)
+			env, ok, err = vm.unify(arg, v, env)
 		case opPutVar:
 			v := vars[operand.(Integer)]
 			args = append(args, v)
@@ -222,7 +272,7 @@ func (vm *VM) exec(pc bytecode, vars []Variable, cont Cont, args []Term, astack
 			for i := range args {
 				args[i] = NewVariable()
 			}
-			env, ok = env.Unify(arg, pi.name.Apply(args...))
+			env, ok, err = vm.unify(arg, pi.name.Apply(args...), env)
 		case opPutFunctor:
 			pi := operand.(procedureIndicator)
 			vs := make([]Term, int(pi.arity))
@@ -252,7 +302,7 @@ func (vm *VM) exec(pc bytecode, vars []Variable, cont Cont, args []Term, astack
 			for i := range args {
 				args[i] = NewVariable()
 			}
-			env, ok = env.Unify(arg, list(args))
+			env, ok, err = vm.unify(arg, list(args), env)
 		case opPutList:
 			l := operand.(Integer)
 			vs := make([]Term, int(l))
@@ -267,7 +317,7 @@ func (vm *VM) exec(pc bytecode, vars []Variable, cont Cont, args []Term, astack
 			for i := range args {
 				args[i] = NewVariable()
 			}
-			env, ok = env.Unify(arg, PartialList(args[0], args[1:]...))
+			env, ok, err = vm.unify(arg, PartialList(args[0], args[1:]...), env)
 		case opPutPartial:
 			l := operand.(Integer)
 			vs := make([]Term, int(l+1))
@@ -280,6 +330,9 @@ func (vm *VM) exec(pc bytecode, vars []Variable, cont Cont, args []Term, astack
 			args = vs[:0]
 		}
 	}
+	if err != nil {
+		return Error(err)
+	}
 
 	return Bool(false)
 }
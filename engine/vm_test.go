@@ -204,6 +204,56 @@ func TestVM_Arrive(t *testing.T) {
 		assert.True(t, ok)
 	})
 
+	t.Run("delayed typed error", func(t *testing.T) {
+		vm := VM{
+			procedures: map[procedureIndicator]procedure{
+				{name: NewAtom("foo"), arity: 1}: Predicate1(func(_ *VM, t Term, k Cont, env *Env) *Promise {
+					return Delay(func(context.Context) *Promise {
+						return Error(&TypeErr{Type: atomInteger, Culprit: t})
+					})
+				}),
+			},
+		}
+		_, err := vm.Arrive(NewAtom("foo"), []Term{NewAtom("a")}, Success, nil).Force(context.Background())
+		assert.Equal(t, NewException(atomError.Apply(atomTypeError.Apply(atomInteger, NewAtom("a")), atomSlash.Apply(NewAtom("foo"), Integer(1))), nil), err)
+	})
+
+	t.Run("user-defined", func(t *testing.T) {
+		for _, backend := range []Backend{BackendZIP, BackendRegister} {
+			t.Run(backend.String(), func(t *testing.T) {
+				vm := VM{Backend: backend}
+				vm.operators.define(1200, operatorSpecifierXFX, atomIf)
+				vm.operators.define(1000, operatorSpecifierXFY, atomComma)
+				assert.NoError(t, vm.Compile(context.Background(), `
+foo(a).
+foo(X) :- bar(X), !.
+foo(f(X, X)).
+bar(b).
+bar(c).
+baz(X) :- qux(X).
+`))
+
+				x := NewVariable()
+				var xs []Term
+				ok, err := vm.Arrive(NewAtom("foo"), []Term{x}, func(env *Env) *Promise {
+					xs = append(xs, env.Resolve(x))
+					return Bool(false)
+				}, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.False(t, ok)
+				assert.Equal(t, []Term{NewAtom("a"), NewAtom("b")}, xs)
+
+				ok, err = vm.Arrive(NewAtom("foo"), []Term{NewAtom("f").Apply(NewAtom("a"), NewAtom("b"))}, Success, nil).Force(context.Background())
+				assert.NoError(t, err)
+				assert.False(t, ok)
+
+				ok, err = vm.Arrive(NewAtom("baz"), []Term{x}, Success, nil).Force(context.Background())
+				assert.Equal(t, existenceError(objectTypeProcedure, atomSlash.Apply(NewAtom("qux"), Integer(1)), NewEnv().bind(varContext, atomSlash.Apply(NewAtom("baz"), Integer(1)))), err)
+				assert.False(t, ok)
+			})
+		}
+	})
+
 	t.Run("unknown procedure", func(t *testing.T) {
 		t.Run("error", func(t *testing.T) {
 			vm := VM{
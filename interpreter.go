@@ -1,6 +1,7 @@
 package prolog
 
 import (
+	"bytes"
 	"context"
 	_ "embed" // for go:embed
 	"errors"
@@ -11,8 +12,12 @@ import (
 	"strings"
 )
 
-//go:embed bootstrap.pl
-var bootstrap string
+//go:generate go test -run TestBootstrapImage -update
+
+// bootstrapImage is the image of bootstrap.pl so that New doesn't parse it at runtime.
+//
+//go:embed bootstrap.qlf
+var bootstrapImage []byte
 
 // Interpreter is a Prolog interpreter. The zero value is a valid interpreter without any predicates/operators defined.
 type Interpreter struct {
@@ -26,7 +31,13 @@ func New(in io.Reader, out io.Writer) *Interpreter {
 	i.FS = defaultFS{}
 	i.SetUserInput(engine.NewInputTextStream(in))
 	i.SetUserOutput(engine.NewOutputTextStream(out))
+	i.register()
+	_ = i.LoadImage(bytes.NewReader(bootstrapImage))
+	return &i
+}
 
+// register registers the builtin predicates defined in Go.
+func (i *Interpreter) register() {
 	// Control constructs
 	i.Register1(engine.NewAtom("call"), engine.Call)
 	i.Register3(engine.NewAtom("catch"), engine.Catch)
@@ -56,6 +67,9 @@ func New(in io.Reader, out io.Writer) *Interpreter {
 	i.Register2(engine.NewAtom("=.."), engine.Univ)
 	i.Register2(engine.NewAtom("copy_term"), engine.CopyTerm)
 	i.Register2(engine.NewAtom("term_variables"), engine.TermVariables)
+	i.Register3(engine.NewAtom("term_factorized"), engine.TermFactorized)
+	i.Register2(engine.NewAtom("term_to_binary"), engine.TermToBinary)
+	i.Register2(engine.NewAtom("binary_to_term"), engine.BinaryToTerm)
 
 	// Arithmetic evaluation
 	i.Register2(engine.NewAtom("is"), engine.Is)
@@ -71,6 +85,7 @@ func New(in io.Reader, out io.Writer) *Interpreter {
 	// Clause retrieval and information
 	i.Register2(engine.NewAtom("clause"), engine.Clause)
 	i.Register1(engine.NewAtom("current_predicate"), engine.CurrentPredicate)
+	i.Register2(engine.NewAtom("predicate_property"), engine.PredicateProperty)
 
 	// Clause creation and destruction
 	i.Register1(engine.NewAtom("asserta"), engine.Asserta)
@@ -140,10 +155,16 @@ func New(in io.Reader, out io.Writer) *Interpreter {
 
 	// Consult
 	i.Register1(engine.NewAtom("consult"), engine.Consult)
+	i.Register0(engine.NewAtom("make"), engine.Make)
+	i.Register1(engine.NewAtom("reload_file"), engine.ReloadFile)
+	i.Register1(engine.NewAtom("qcompile"), engine.QCompile)
+	i.Register2(engine.NewAtom("prolog_load_context"), engine.PrologLoadContext)
+	i.Register3(engine.NewAtom("absolute_file_name"), engine.AbsoluteFileName)
 
 	// Definite clause grammar
 	i.Register3(engine.NewAtom("phrase"), engine.Phrase)
 	i.Register2(engine.NewAtom("expand_term"), engine.ExpandTerm)
+	i.Register2(engine.NewAtom("expand_goal"), engine.ExpandGoal)
 
 	// Prolog prologue
 	i.Register3(engine.NewAtom("append"), engine.Append)
@@ -153,10 +174,6 @@ func New(in io.Reader, out io.Writer) *Interpreter {
 	i.Register3(engine.NewAtom("nth0"), engine.Nth0)
 	i.Register3(engine.NewAtom("nth1"), engine.Nth1)
 	i.Register2(engine.NewAtom("call_nth"), engine.CallNth)
-
-	_ = i.Exec(bootstrap)
-
-	return &i
 }
 
 // Exec executes a prolog program.
@@ -175,6 +192,7 @@ func (i *Interpreter) Query(query string, args ...interface{}) (*Solutions, erro
 }
 
 // QueryContext executes a prolog query and returns *Solutions with context.
+// Predicates can access ctx through (*engine.Env).Context(), e.g. for cancellation and request-scoped values.
 func (i *Interpreter) QueryContext(ctx context.Context, query string, args ...interface{}) (*Solutions, error) {
 	p := engine.NewParser(&i.VM, strings.NewReader(query))
 	if err := p.SetPlaceholder(engine.NewAtom("?"), args...); err != nil {
@@ -186,31 +204,34 @@ func (i *Interpreter) QueryContext(ctx context.Context, query string, args ...in
 		return nil, err
 	}
 
-	var env *engine.Env
-
-	more := make(chan bool, 1)
-	next := make(chan *engine.Env)
-	sols := Solutions{
-		vm:   &i.VM,
-		vars: p.Vars,
-		more: more,
-		next: next,
+	call := func(vm *engine.VM, k engine.Cont, env *engine.Env) *engine.Promise {
+		return engine.Call(vm, t, k, env)
 	}
+	return newSolutions(ctx, &i.VM, p.Vars, call, engine.NewEnv().WithContext(ctx)), nil
+}
 
-	go func() {
-		defer close(next)
-		if !<-more {
-			return
-		}
-		if _, err := engine.Call(&i.VM, t, func(env *engine.Env) *engine.Promise {
-			next <- env
-			return engine.Bool(!<-more)
-		}, env).Force(ctx); err != nil {
-			sols.err = err
+// Limit is an argument for QueryAll which limits the number of solutions. It's not an argument for placeholders.
+type Limit int
+
+// QueryAll executes a prolog query with context and copies the variable values of the solutions into the slice which dest
+// points to in the same way as Solutions.ScanAll.
+// If args contain a Limit, it copies at most that number of solutions.
+func (i *Interpreter) QueryAll(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	limit := -1
+	var rest []interface{}
+	for _, a := range args {
+		if l, ok := a.(Limit); ok {
+			limit = int(l)
+			continue
 		}
-	}()
+		rest = append(rest, a)
+	}
 
-	return &sols, nil
+	sols, err := i.QueryContext(ctx, query, rest...)
+	if err != nil {
+		return err
+	}
+	return sols.scanAll(dest, limit)
 }
 
 // ErrNoSolutions indicates there's no solutions for the query.
@@ -224,6 +245,11 @@ func (i *Interpreter) QuerySolution(query string, args ...interface{}) *Solution
 // QuerySolutionContext executes a Prolog query with context.
 func (i *Interpreter) QuerySolutionContext(ctx context.Context, query string, args ...interface{}) *Solution {
 	sols, err := i.QueryContext(ctx, query, args...)
+	return firstSolution(sols, err)
+}
+
+// firstSolution retrieves the first solution from sols and closes it.
+func firstSolution(sols *Solutions, err error) *Solution {
 	if err != nil {
 		return &Solution{err: err}
 	}
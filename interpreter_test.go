@@ -3,7 +3,9 @@ package prolog
 import (
 	"bytes"
 	"context"
+	_ "embed" // for go:embed
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/ichiban/prolog/engine"
 	"github.com/stretchr/testify/assert"
@@ -14,13 +16,49 @@ import (
 	"time"
 )
 
+//go:embed bootstrap.pl
+var bootstrap string
+
+var update = flag.Bool("update", false, "update bootstrap.qlf")
+
+// backends are the abstract machines which the conformance tests run on.
+var backends = []engine.Backend{engine.BackendZIP, engine.BackendRegister}
+
+func newInterpreter(backend engine.Backend, in io.Reader, out io.Writer) *Interpreter {
+	i := New(in, out)
+	i.Backend = backend
+	return i
+}
+
+func TestBootstrapImage(t *testing.T) {
+	var i Interpreter
+	i.register()
+	assert.NoError(t, i.Exec(bootstrap))
+	var buf bytes.Buffer
+	assert.NoError(t, i.SaveImage(&buf))
+
+	if *update {
+		assert.NoError(t, os.WriteFile("bootstrap.qlf", buf.Bytes(), 0644))
+		return
+	}
+	assert.Equal(t, buf.Bytes(), bootstrapImage, "bootstrap.qlf is stale; run go generate")
+}
+
 func TestNew(t *testing.T) {
-	i := New(nil, nil)
+	for _, backend := range backends {
+		t.Run(backend.String(), func(t *testing.T) {
+			testNew(t, backend)
+		})
+	}
+}
+
+func testNew(t *testing.T, backend engine.Backend) {
+	i := newInterpreter(backend, nil, nil)
 	assert.NotNil(t, i)
 
 	t.Run("number_chars", func(t *testing.T) {
 		// http://www.complang.tuwien.ac.at/ulrich/iso-prolog/number_chars
-		p := New(nil, nil)
+		p := newInterpreter(backend, nil, nil)
 
 		// Section 0
 		assert.NoError(t, p.QuerySolution(`number_chars(1.2,['1',.,'2']).`).Err())
@@ -83,7 +121,7 @@ func TestNew(t *testing.T) {
 
 	t.Run("length", func(t *testing.T) {
 		// http://www.complang.tuwien.ac.at/ulrich/iso-prolog/length_quad.pl
-		p := New(nil, nil)
+		p := newInterpreter(backend, nil, nil)
 
 		var s struct {
 			L []interface{}
@@ -164,7 +202,7 @@ func TestNew(t *testing.T) {
 			Nth int
 		}
 
-		p := New(nil, nil)
+		p := newInterpreter(backend, nil, nil)
 
 		assert.NoError(t, p.QuerySolution(`call_nth(true, Nth), Nth = 1.`).Err())
 
@@ -212,11 +250,19 @@ func TestNew(t *testing.T) {
 }
 
 func TestNew_variableNames(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.String(), func(t *testing.T) {
+			testNew_variableNames(t, backend)
+		})
+	}
+}
+
+func testNew_variableNames(t *testing.T, backend engine.Backend) {
 	// http://www.complang.tuwien.ac.at/ulrich/iso-prolog/variable_names
 	// I wanted to put this under TestNew() as t.Run("variable_names", ...) but GoLand didn't recognize it as a table-driven test.
 
 	var out bytes.Buffer
-	p := New(nil, &out)
+	p := newInterpreter(backend, nil, &out)
 
 	defer func() {
 		_ = os.Remove("f") // Some test cases open a file 'f'.
@@ -336,6 +382,14 @@ func TestNew_variableNames(t *testing.T) {
 }
 
 func TestNew_conformity(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.String(), func(t *testing.T) {
+			testNew_conformity(t, backend)
+		})
+	}
+}
+
+func testNew_conformity(t *testing.T, backend engine.Backend) {
 	// http://www.complang.tuwien.ac.at/ulrich/iso-prolog/conformity_testing
 
 	tests := []struct {
@@ -687,7 +741,7 @@ a.`, output: `syntax err.`},
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var out bytes.Buffer
-			p := New(bytes.NewBufferString(tt.input+"\n"), &out)
+			p := newInterpreter(backend, bytes.NewBufferString(tt.input+"\n"), &out)
 			if tt.premise != "" {
 				assert.NoError(t, p.QuerySolution(tt.premise).Err())
 			}
@@ -721,6 +775,8 @@ func TestInterpreter_Exec(t *testing.T) {
 
 		{query: `foo(?, ?, ?, ?).`, args: []interface{}{"a", 1, 2.0, []string{"abc", "def"}}},
 		{query: `foo(?).`, args: []interface{}{nil}, err: true},
+		{query: `foo(:a). bar(:b).`, args: []interface{}{map[string]int{"a": 1, "b": 2}}},
+		{query: `foo(:a). bar(:b).`, args: []interface{}{map[string]int{"a": 1, "b": 2, "c": 3}}, err: true},
 
 		{query: `#!/usr/bin/env 1pl
 append(nil, L, L).`},
@@ -785,6 +841,9 @@ func TestInterpreter_Query(t *testing.T) {
 		}},
 		{query: `foo(?, ?, ?, ?).`, args: []interface{}{"a", 1, 2.0, []string{"abc", "def"}}, scan: map[string]interface{}{}, result: map[string]interface{}{}},
 		{query: `foo(?, ?, ?, ?).`, args: []interface{}{nil, 1, 2.0, []string{"abc", "def"}}, queryErr: true, result: nil},
+		{query: `foo(:a, ?{b}, :c, :d).`, args: []interface{}{map[string]interface{}{"a": "a", "b": 1, "c": 2.0, "d": []string{"abc", "def"}}}, scan: map[string]interface{}{}, result: map[string]interface{}{}},
+		{query: `foo(:a, :b, :c, :D).`, args: []interface{}{result{A: "a", B: 1, C: 2.0, List: []string{"abc", "def"}}}, scan: map[string]interface{}{}, result: map[string]interface{}{}},
+		{query: `foo(:a, :b, :c, :e).`, args: []interface{}{map[string]interface{}{"a": "a", "b": 1, "c": 2.0, "d": []string{"abc", "def"}}}, queryErr: true},
 		{query: `foo(A, B, C, D).`, scan: &result{}, result: &result{
 			A:    "a",
 			B:    1,
@@ -826,6 +885,34 @@ foo(a, 1, 2.0, [abc, def]).
 	}
 }
 
+func TestInterpreter_QueryAll(t *testing.T) {
+	p := New(nil, nil)
+
+	tests := []struct {
+		title  string
+		query  string
+		args   []interface{}
+		result []int
+		err    bool
+	}{
+		{title: "all", query: `between(1, 5, X).`, result: []int{1, 2, 3, 4, 5}},
+		{title: "limit", query: `between(1, 5, X).`, args: []interface{}{Limit(2)}, result: []int{1, 2}},
+		{title: "limit: infinite", query: `repeat, X = 1.`, args: []interface{}{Limit(3)}, result: []int{1, 1, 1}},
+		{title: "placeholders", query: `between(?, ?, X).`, args: []interface{}{2, Limit(2), 5}, result: []int{2, 3}},
+		{title: "named placeholders", query: `between(:from, :to, X).`, args: []interface{}{Limit(1), map[string]int{"from": 3, "to": 5}}, result: []int{3}},
+		{title: "syntax error", query: `between(1, 5, X`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			var result []int
+			err := p.QueryAll(context.Background(), &result, tt.query, tt.args...)
+			assert.Equal(t, tt.err, err != nil)
+			assert.Equal(t, tt.result, result)
+		})
+	}
+}
+
 func TestInterpreter_Query_close(t *testing.T) {
 	var i Interpreter
 	i.Register0(engine.NewAtom("do_not_call"), func(_ *engine.VM, k engine.Cont, env *engine.Env) *engine.Promise {
@@ -837,9 +924,216 @@ func TestInterpreter_Query_close(t *testing.T) {
 	assert.NoError(t, sols.Close())
 }
 
+func TestInterpreter_Query_synchronous(t *testing.T) {
+	var (
+		i     Interpreter
+		calls int
+	)
+	i.Register1(engine.NewAtom("count"), func(_ *engine.VM, n engine.Term, k engine.Cont, env *engine.Env) *engine.Promise {
+		ks := make([]func(context.Context) *engine.Promise, 3)
+		for j := range ks {
+			j := engine.Integer(j)
+			ks[j] = func(context.Context) *engine.Promise {
+				calls++
+				return engine.Unify(nil, n, j, k, env)
+			}
+		}
+		return engine.Delay(ks...)
+	})
+
+	i.Register0(engine.NewAtom("explode"), func(*engine.VM, engine.Cont, *engine.Env) *engine.Promise {
+		panic("boom")
+	})
+
+	sols, err := i.Query("count(N).")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, calls)
+
+	assert.True(t, sols.Next())
+	assert.Equal(t, 1, calls)
+	assert.True(t, sols.Next())
+	assert.Equal(t, 2, calls)
+
+	// The remaining alternative is dropped without running.
+	assert.NoError(t, sols.Close())
+	assert.False(t, sols.Next())
+	assert.Equal(t, 2, calls)
+
+	// A panic in a predicate reaches the caller of Next since nothing runs on another goroutine.
+	sols, err = i.Query("explode.")
+	assert.NoError(t, err)
+	assert.PanicsWithValue(t, "boom", func() {
+		sols.Next()
+	})
+}
+
+func TestInterpreter_QueryContext_context(t *testing.T) {
+	type key struct{}
+
+	i := New(nil, nil)
+	i.Register1(engine.NewAtom("tenant"), func(_ *engine.VM, tenant engine.Term, k engine.Cont, env *engine.Env) *engine.Promise {
+		return engine.Unify(nil, tenant, engine.NewAtom(env.Context().Value(key{}).(string)), k, env)
+	})
+	i.Register0(engine.NewAtom("wait"), func(_ *engine.VM, k engine.Cont, env *engine.Env) *engine.Promise {
+		<-env.Context().Done()
+		return engine.Error(env.Context().Err())
+	})
+
+	t.Run("value", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), key{}, "acme")
+		sols, err := i.QueryContext(ctx, "tenant(T), call(tenant, U).")
+		assert.NoError(t, err)
+		defer sols.Close()
+		assert.True(t, sols.Next())
+		var s struct{ T, U string }
+		assert.NoError(t, sols.Scan(&s))
+		assert.Equal(t, "acme", s.T)
+		assert.Equal(t, "acme", s.U)
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		sols, err := i.QueryContext(ctx, "wait.")
+		assert.NoError(t, err)
+		defer sols.Close()
+		assert.False(t, sols.Next())
+		assert.Equal(t, context.DeadlineExceeded, sols.Err())
+	})
+}
+
+func TestInterpreter_Backend(t *testing.T) {
+	program := `
+nrev([], []).
+nrev([H|T], R) :- nrev(T, RT), append(RT, [H], R).
+
+max_of([X], X) :- !.
+max_of([X|Xs], M) :- max_of(Xs, M0), (X > M0 -> M = X ; M = M0).
+
+tree(leaf, 0).
+tree(node(L, _, R), N) :- tree(L, NL), tree(R, NR), N is NL + NR + 1.
+`
+	queries := []string{
+		`nrev([a, b, c, d], X).`,
+		`member(X, [a, f(b), "str", 1.5]).`,
+		`max_of([3, 1, 4, 1, 5, 9, 2, 6], X).`,
+		`tree(node(node(leaf, a, leaf), b, leaf), N).`,
+		`catch(max_of([a, 1], _), error(E, _), true).`,
+		`findall(X-Y, (member(X, [1, 2]), member(Y, [a, b])), L), length(L, N), X = x, Y = y.`,
+	}
+
+	answers := func(backend engine.Backend) [][]map[string]TermString {
+		i := New(nil, nil)
+		i.Backend = backend
+		assert.NoError(t, i.Exec(program))
+
+		var ret [][]map[string]TermString
+		for _, q := range queries {
+			sols, err := i.Query(q)
+			assert.NoError(t, err)
+
+			var as []map[string]TermString
+			for n := 0; n < 4 && sols.Next(); n++ {
+				m := map[string]TermString{}
+				assert.NoError(t, sols.Scan(m))
+				as = append(as, m)
+			}
+			assert.NoError(t, sols.Err())
+			assert.NoError(t, sols.Close())
+			ret = append(ret, as)
+		}
+		return ret
+	}
+
+	zip, reg := answers(engine.BackendZIP), answers(engine.BackendRegister)
+	assert.Equal(t, zip, reg)
+	assert.Equal(t, []map[string]TermString{{"X": "[d,c,b,a]"}}, reg[0])
+}
+
+func BenchmarkInterpreter_Backend(b *testing.B) {
+	for _, backend := range backends {
+		b.Run(backend.String(), func(b *testing.B) {
+			i := newInterpreter(backend, nil, nil)
+			if err := i.Exec(`
+nrev([], []).
+nrev([H|T], R) :- nrev(T, RT), append(RT, [H], R).
+
+range(N, N, [N]) :- !.
+range(M, N, [M|Ns]) :- M1 is M + 1, range(M1, N, Ns).
+`); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				if err := i.QuerySolution(`range(1, 30, L), nrev(L, _).`).Err(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestInterpreter_occursCheck(t *testing.T) {
+	for _, backend := range backends {
+		i := New(nil, nil)
+		i.Backend = backend
+		assert.NoError(t, i.Exec(`
+same(X, X).
+wrap(X, f(X)).
+`))
+
+		tests := []struct {
+			flag, query string
+			ok, err     bool
+		}{
+			{flag: "false", query: `same(X, f(X)).`, ok: true},
+			{flag: "false", query: `wrap(X, X).`, ok: true},
+			{flag: "true", query: `same(X, f(X)).`, ok: false},
+			{flag: "true", query: `wrap(X, X).`, ok: false},
+			{flag: "true", query: `X = f(X).`, ok: false},
+			{flag: "true", query: `wrap(a, Y).`, ok: true},
+			{flag: "error", query: `same(X, f(X)).`, err: true},
+			{flag: "error", query: `catch(wrap(X, X), error(occurs_check(_, _), _), true).`, ok: true},
+			{flag: "error", query: `catch(X = f(X), error(occurs_check(_, _), _), true).`, ok: true},
+		}
+
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%d %s %s", backend, tt.flag, tt.query), func(t *testing.T) {
+				assert.NoError(t, i.QuerySolution(fmt.Sprintf(`set_prolog_flag(occurs_check, %s).`, tt.flag)).Err())
+				sol := i.QuerySolution(tt.query)
+				switch {
+				case tt.err:
+					assert.Error(t, sol.Err())
+				case tt.ok:
+					assert.NoError(t, sol.Err())
+				default:
+					assert.Equal(t, ErrNoSolutions, sol.Err())
+				}
+			})
+		}
+	}
+}
+
 func TestMisc(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.String(), func(t *testing.T) {
+			testMisc(t, backend)
+		})
+	}
+}
+
+func testMisc(t *testing.T, backend engine.Backend) {
+	t.Run("rational trees", func(t *testing.T) {
+		var out bytes.Buffer
+		i := newInterpreter(backend, nil, &out)
+		sol := i.QuerySolution(`X = f(X, a), Y = f(Y, a), X == Y, copy_term(X, Z), Z = f(Z, a), write_term(X, [cycles(true)]).`)
+		assert.NoError(t, sol.Err())
+		assert.Equal(t, "@(_S1,[_S1=f(_S1,a)])", out.String())
+	})
+
 	t.Run("negation", func(t *testing.T) {
-		i := New(nil, nil)
+		i := newInterpreter(backend, nil, nil)
 		sols, err := i.Query(`\+true.`)
 		assert.NoError(t, err)
 
@@ -849,7 +1143,7 @@ func TestMisc(t *testing.T) {
 	t.Run("cut", func(t *testing.T) {
 		// https://www.cs.uleth.ca/~gaur/post/prolog-cut-negation/
 		t.Run("p", func(t *testing.T) {
-			i := New(nil, nil)
+			i := newInterpreter(backend, nil, nil)
 			assert.NoError(t, i.Exec(`
 p(a).
 p(b):-!.
@@ -916,7 +1210,7 @@ p(c).
 
 		// http://www.cse.unsw.edu.au/~billw/dictionaries/prolog/cut.html
 		t.Run("teaches", func(t *testing.T) {
-			i := New(nil, nil)
+			i := newInterpreter(backend, nil, nil)
 			assert.NoError(t, i.Exec(`
 teaches(dr_fred, history).
 teaches(dr_fred, english).
@@ -1038,7 +1332,7 @@ studies(alex, physics).
 
 		t.Run("call/1 makes a difference", func(t *testing.T) {
 			t.Run("with", func(t *testing.T) {
-				i := New(nil, nil)
+				i := newInterpreter(backend, nil, nil)
 				sols, err := i.Query(`call(!), fail; true.`)
 				assert.NoError(t, err)
 				defer func() {
@@ -1049,7 +1343,7 @@ studies(alex, physics).
 			})
 
 			t.Run("without", func(t *testing.T) {
-				i := New(nil, nil)
+				i := newInterpreter(backend, nil, nil)
 				sols, err := i.Query(`!, fail; true.`)
 				assert.NoError(t, err)
 				defer func() {
@@ -1063,14 +1357,14 @@ studies(alex, physics).
 
 	t.Run("repeat", func(t *testing.T) {
 		t.Run("cut", func(t *testing.T) {
-			i := New(nil, nil)
+			i := newInterpreter(backend, nil, nil)
 			sols, err := i.Query("repeat, !, fail.")
 			assert.NoError(t, err)
 			assert.False(t, sols.Next())
 		})
 
 		t.Run("stream", func(t *testing.T) {
-			i := New(nil, nil)
+			i := newInterpreter(backend, nil, nil)
 			sols, err := i.Query("repeat, (X = a; X = b).")
 			assert.NoError(t, err)
 
@@ -1097,21 +1391,21 @@ studies(alex, physics).
 	})
 
 	t.Run("atom_chars", func(t *testing.T) {
-		i := New(nil, nil)
+		i := newInterpreter(backend, nil, nil)
 		sols, err := i.Query("atom_chars(f(a), L).")
 		assert.NoError(t, err)
 		assert.False(t, sols.Next())
 	})
 
 	t.Run("term_eq", func(t *testing.T) {
-		i := New(nil, nil)
+		i := newInterpreter(backend, nil, nil)
 		sols, err := i.Query("f(a) == f(a).")
 		assert.NoError(t, err)
 		assert.True(t, sols.Next())
 	})
 
 	t.Run("call cut", func(t *testing.T) {
-		i := New(nil, nil)
+		i := newInterpreter(backend, nil, nil)
 		assert.NoError(t, i.Exec(`
 foo :- call(true), !.
 foo :- throw(unreachable).
@@ -1124,7 +1418,7 @@ foo :- throw(unreachable).
 	})
 
 	t.Run("catch cut", func(t *testing.T) {
-		i := New(nil, nil)
+		i := newInterpreter(backend, nil, nil)
 		assert.NoError(t, i.Exec(`
 foo :- catch(true, _, true), !.
 foo :- throw(unreachable).
@@ -1137,7 +1431,7 @@ foo :- throw(unreachable).
 	})
 
 	t.Run("counter", func(t *testing.T) {
-		i := New(nil, nil)
+		i := newInterpreter(backend, nil, nil)
 		assert.NoError(t, i.Exec(`
 :- dynamic(count/1).
 count(0).
@@ -1595,6 +1889,59 @@ func ExampleNew_arg() {
 	// error(type_error(compound,3),arg/3)
 }
 
+func TestInterpreter_typedErrors(t *testing.T) {
+	p := New(nil, nil)
+	p.Register1(engine.NewAtom("positive"), func(_ *engine.VM, x engine.Term, k engine.Cont, env *engine.Env) *engine.Promise {
+		switch x := env.Resolve(x).(type) {
+		case engine.Variable:
+			return engine.Error(&engine.InstantiationErr{})
+		case engine.Integer:
+			if x <= 0 {
+				return engine.Error(&engine.DomainErr{Domain: engine.NewAtom("positive"), Culprit: x})
+			}
+			return k(env)
+		default:
+			return engine.Error(&engine.TypeErr{Type: engine.NewAtom("integer"), Culprit: x})
+		}
+	})
+	p.Register0(engine.NewAtom("delayed"), func(*engine.VM, engine.Cont, *engine.Env) *engine.Promise {
+		return engine.Delay(func(context.Context) *engine.Promise {
+			return engine.Error(&engine.TypeErr{Type: engine.NewAtom("integer"), Culprit: engine.NewAtom("a")})
+		})
+	})
+
+	t.Run("errors.As", func(t *testing.T) {
+		var te *engine.TypeErr
+		assert.True(t, errors.As(p.QuerySolution(`positive(foo).`).Err(), &te))
+		assert.Equal(t, engine.NewAtom("integer"), te.Type)
+		assert.Equal(t, engine.NewAtom("foo"), te.Culprit)
+		assert.Equal(t, engine.NewAtom("/").Apply(engine.NewAtom("positive"), engine.Integer(1)), te.Context)
+
+		var de *engine.DomainErr
+		assert.True(t, errors.As(p.QuerySolution(`positive(-1).`).Err(), &de))
+		assert.Equal(t, engine.Integer(-1), de.Culprit)
+		assert.False(t, errors.As(p.QuerySolution(`positive(foo).`).Err(), &de))
+
+		var ee *engine.ExistenceErr
+		assert.True(t, errors.As(p.QuerySolution(`negative(-1).`).Err(), &ee))
+		assert.Equal(t, engine.NewAtom("/").Apply(engine.NewAtom("negative"), engine.Integer(1)), ee.Culprit)
+	})
+
+	t.Run("catch", func(t *testing.T) {
+		assert.NoError(t, p.QuerySolution(`catch(positive(_), error(instantiation_error, positive/1), true).`).Err())
+		assert.NoError(t, p.QuerySolution(`catch(positive(0), error(domain_error(positive, 0), positive/1), true).`).Err())
+		assert.NoError(t, p.QuerySolution(`catch(positive(a), error(type_error(integer, a), positive/1), true).`).Err())
+
+		var s struct {
+			Name  string
+			Arity int
+		}
+		assert.NoError(t, p.QuerySolution(`catch(delayed, error(type_error(_, _), Name/Arity), true).`).Scan(&s))
+		assert.Equal(t, "delayed", s.Name)
+		assert.Equal(t, 0, s.Arity)
+	})
+}
+
 func TestDefaultFS_Open(t *testing.T) {
 	var fs defaultFS
 	f, err := fs.Open("interpreter.go")
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/ichiban/prolog/engine"
 )
@@ -18,37 +20,68 @@ var errConversion = errors.New("conversion failed")
 // Solutions is the result of a query. Everytime the Next method is called, it searches for the next solution.
 // By calling the Scan method, you can retrieve the content of the solution.
 type Solutions struct {
+	ctx    context.Context
 	vm     *engine.VM
 	env    *engine.Env
 	vars   []engine.ParsedVariable
-	more   chan<- bool
-	next   <-chan *engine.Env
+	t      *engine.Trampoline
 	err    error
 	closed bool
 }
 
+// newSolutions creates Solutions which search for the solutions of the goal executed by call.
+func newSolutions(ctx context.Context, vm *engine.VM, vars []engine.ParsedVariable, call func(*engine.VM, engine.Cont, *engine.Env) *engine.Promise, env *engine.Env) *Solutions {
+	sols := Solutions{
+		ctx:  ctx,
+		vm:   vm,
+		vars: vars,
+	}
+	sols.t = engine.NewTrampoline(engine.Delay(func(context.Context) *engine.Promise {
+		return call(vm, func(env *engine.Env) *engine.Promise {
+			sols.env = env
+			return engine.Bool(true)
+		}, env)
+	}))
+	return &sols
+}
+
 // Close closes the Solutions and terminates the search for other solutions.
 func (s *Solutions) Close() error {
 	if s.closed {
 		return ErrClosed
 	}
-	close(s.more)
+	if s.t != nil {
+		s.t.Close()
+	}
 	s.closed = true
 	return nil
 }
 
 // Next prepares the next solution for reading with the Scan method. It returns true if it finds another solution,
 // or false if there's no further solutions or if there's an error.
+// The search runs on the caller's goroutine and stops at the solution until the subsequent call.
 func (s *Solutions) Next() bool {
-	if s.closed {
+	if s.closed || s.err != nil || s.t == nil {
+		return false
+	}
+	ok, err := s.t.Next(s.ctx)
+	if err != nil {
+		s.err = err
+		s.t.Close()
 		return false
 	}
-	s.more <- true
-	var ok bool
-	s.env, ok = <-s.next
+	if !ok {
+		s.t.Close()
+	}
 	return ok
 }
 
+// Deterministic reports whether the current solution is the last one, i.e. there's no choice point left to search for
+// other solutions. Even if it returns false, the subsequent call of Next may find no more solutions.
+func (s *Solutions) Deterministic() bool {
+	return s.t == nil || s.t.Deterministic()
+}
+
 // Scan copies the variable values of the current solution into the specified struct/map.
 func (s *Solutions) Scan(dest interface{}) error {
 	o := reflect.ValueOf(dest)
@@ -100,7 +133,92 @@ func (s *Solutions) Scan(dest interface{}) error {
 	}
 }
 
-var atomEmptyList = engine.NewAtom("[]")
+// ScanAll copies the variable values of the rest of the solutions into the slice which dest points to and closes the
+// Solutions.
+// The elements are either structs/maps, which are filled in the same way as Scan, or other values converted from the
+// only variable in the query.
+// If an error occurs on the way, dest holds the solutions before the error and ScanAll returns the error.
+func (s *Solutions) ScanAll(dest interface{}) error {
+	return s.scanAll(dest, -1)
+}
+
+// scanAll is ScanAll which copies at most limit solutions if limit is not negative.
+func (s *Solutions) scanAll(dest interface{}, limit int) error {
+	defer func() {
+		_ = s.Close()
+	}()
+
+	o := reflect.ValueOf(dest)
+	if o.Kind() != reflect.Ptr || o.IsNil() || o.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("not a pointer to slice: %T", dest)
+	}
+	o = o.Elem()
+
+	for n := 0; limit < 0 || n < limit; n++ {
+		if !s.Next() {
+			break
+		}
+		e := reflect.New(o.Type().Elem())
+		if err := s.scanElem(e); err != nil {
+			return err
+		}
+		o.Set(reflect.Append(o, e.Elem()))
+	}
+	return s.Err()
+}
+
+// scanElem copies the current solution into the value which e points to.
+func (s *Solutions) scanElem(e reflect.Value) error {
+	t := e.Type().Elem()
+	if t.Kind() == reflect.Ptr && scansByName(t.Elem()) {
+		e.Elem().Set(reflect.New(t.Elem()))
+		return s.Scan(e.Elem().Interface())
+	}
+	if !scansByName(t) {
+		var vs []engine.ParsedVariable
+		for _, v := range s.vars {
+			if !strings.HasPrefix(v.Name.String(), "_") {
+				vs = append(vs, v)
+			}
+		}
+		if len(vs) != 1 {
+			return fmt.Errorf("%s needs exactly one variable in the query, got %d", t, len(vs))
+		}
+		return convertAssign(e.Interface(), s.vm, vs[0].Variable, s.env)
+	}
+	if t.Kind() == reflect.Map {
+		e.Elem().Set(reflect.MakeMap(t))
+	}
+	return s.Scan(e.Interface())
+}
+
+// scansByName checks if Scan fills the values of the type t by the variable names.
+func scansByName(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String
+	case reflect.Struct:
+		p := reflect.PtrTo(t)
+		if t == reflect.TypeOf(time.Time{}) || p.Implements(scannerType) || p.Implements(termUnmarshalerType) {
+			return false
+		}
+		// A struct for a compound is a single value.
+		_, _, err := engine.StructFields(t)
+		return err != nil
+	default:
+		return false
+	}
+}
+
+var (
+	scannerType         = reflect.TypeOf((*Scanner)(nil)).Elem()
+	termUnmarshalerType = reflect.TypeOf((*engine.TermUnmarshaler)(nil)).Elem()
+)
+
+var (
+	atomEmptyList = engine.NewAtom("[]")
+	atomMinus     = engine.NewAtom("-")
+)
 
 func convertAssign(dest interface{}, vm *engine.VM, t engine.Term, env *engine.Env) error {
 	switch d := dest.(type) {
@@ -124,8 +242,29 @@ func convertAssign(dest interface{}, vm *engine.VM, t engine.Term, env *engine.E
 		return convertAssignFloat64(d, t, env)
 	case Scanner:
 		return d.Scan(vm, t, env)
+	case engine.TermUnmarshaler:
+		return d.UnmarshalTerm(t, env)
+	case *time.Time:
+		return convertAssignTime(d, t, env)
+	case *[]byte:
+		return convertAssignBytes(d, t, env)
 	default:
-		return convertAssignSlice(d, vm, t, env)
+		v := reflect.ValueOf(d)
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return errConversion
+		}
+		switch v.Elem().Kind() {
+		case reflect.Slice:
+			return convertAssignSlice(d, vm, t, env)
+		case reflect.Map:
+			return convertAssignMap(v.Elem(), vm, t, env)
+		case reflect.Ptr:
+			return convertAssignPtr(v.Elem(), vm, t, env)
+		case reflect.Struct:
+			return convertAssignStruct(v.Elem(), vm, t, env)
+		default:
+			return errConversion
+		}
 	}
 }
 
@@ -273,6 +412,108 @@ func convertAssignSlice(d interface{}, vm *engine.VM, t engine.Term, env *engine
 	return nil
 }
 
+// convertAssignTime converts a number of seconds since the epoch to time.Time.
+// Since a float number of seconds keeps only microsecond precision around the current date, a float is rounded to the
+// nearest microsecond. Thus, a time with microsecond precision comes back as it is.
+func convertAssignTime(d *time.Time, t engine.Term, env *engine.Env) error {
+	switch t := env.Resolve(t).(type) {
+	case engine.Integer:
+		*d = time.Unix(int64(t), 0)
+		return nil
+	case engine.Float:
+		*d = time.UnixMicro(int64(math.Round(float64(t) * 1e6)))
+		return nil
+	default:
+		return errConversion
+	}
+}
+
+func convertAssignBytes(d *[]byte, t engine.Term, env *engine.Env) error {
+	var b []byte
+	iter := engine.ListIterator{List: t, Env: env}
+	for iter.Next() {
+		e, ok := env.Resolve(iter.Current()).(engine.Integer)
+		if !ok || e < 0 || e > 255 {
+			return errConversion
+		}
+		b = append(b, byte(e))
+	}
+	if err := iter.Err(); err != nil {
+		return errConversion
+	}
+	*d = b
+	return nil
+}
+
+// convertAssignMap converts a list of pairs Key-Value to a map.
+func convertAssignMap(v reflect.Value, vm *engine.VM, t engine.Term, env *engine.Env) error {
+	m := reflect.MakeMap(v.Type())
+	iter := engine.ListIterator{List: t, Env: env}
+	for iter.Next() {
+		p, ok := env.Resolve(iter.Current()).(engine.Compound)
+		if !ok || p.Functor() != atomMinus || p.Arity() != 2 {
+			return errConversion
+		}
+		k := reflect.New(v.Type().Key())
+		if err := convertAssign(k.Interface(), vm, p.Arg(0), env); err != nil {
+			return err
+		}
+		e := reflect.New(v.Type().Elem())
+		if err := convertAssign(e.Interface(), vm, p.Arg(1), env); err != nil {
+			return err
+		}
+		m.SetMapIndex(k.Elem(), e.Elem())
+	}
+	if err := iter.Err(); err != nil {
+		return errConversion
+	}
+	v.Set(m)
+	return nil
+}
+
+// convertAssignPtr converts a variable to a nil pointer and other terms to a pointer to a new value.
+func convertAssignPtr(v reflect.Value, vm *engine.VM, t engine.Term, env *engine.Env) error {
+	if _, ok := env.Resolve(t).(engine.Variable); ok {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	e := reflect.New(v.Type().Elem())
+	if err := convertAssign(e.Interface(), vm, t, env); err != nil {
+		return err
+	}
+	v.Set(e)
+	return nil
+}
+
+// convertAssignStruct converts a compound to a struct which has a blank field with a compound tag, e.g.
+// `prolog:"person(name,age)"`.
+func convertAssignStruct(v reflect.Value, vm *engine.VM, t engine.Term, env *engine.Env) error {
+	name, fields, err := engine.StructFields(v.Type())
+	if err != nil {
+		return err
+	}
+
+	switch t := env.Resolve(t).(type) {
+	case engine.Atom:
+		if len(fields) != 0 || t != engine.NewAtom(name) {
+			return errConversion
+		}
+		return nil
+	case engine.Compound:
+		if t.Functor() != engine.NewAtom(name) || t.Arity() != len(fields) {
+			return errConversion
+		}
+		for i, f := range fields {
+			if err := convertAssign(v.Field(f).Addr().Interface(), vm, t.Arg(i), env); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errConversion
+	}
+}
+
 // Err returns the error if exists.
 func (s *Solutions) Err() error {
 	return s.err
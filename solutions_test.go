@@ -1,9 +1,11 @@
 package prolog
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/ichiban/prolog/engine"
 
@@ -11,8 +13,7 @@ import (
 )
 
 func TestSolutions_Close(t *testing.T) {
-	ch := make(chan bool)
-	sols := Solutions{more: ch}
+	sols := Solutions{t: engine.NewTrampoline(engine.Bool(true))}
 	assert.NoError(t, sols.Close())
 	assert.Error(t, sols.Close())
 }
@@ -21,14 +22,37 @@ func TestSolutions_Next(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		v := engine.NewVariable()
 		env, _ := engine.NewEnv().Unify(v, engine.NewAtom("foo"))
-		more := make(chan bool, 1)
-		defer close(more)
-		next := make(chan *engine.Env, 1)
-		defer close(next)
-		next <- env
-		sols := Solutions{more: more, next: next}
+		var sols Solutions
+		sols.ctx = context.Background()
+		sols.t = engine.NewTrampoline(engine.Delay(func(context.Context) *engine.Promise {
+			sols.env = env
+			return engine.Bool(true)
+		}))
 		assert.True(t, sols.Next())
 		assert.Equal(t, engine.NewAtom("foo"), sols.env.Resolve(v))
+		assert.False(t, sols.Next())
+		assert.NoError(t, sols.Err())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		sols := Solutions{
+			ctx: context.Background(),
+			t:   engine.NewTrampoline(engine.Error(errors.New("failed"))),
+		}
+		assert.False(t, sols.Next())
+		assert.Error(t, sols.Err())
+		assert.False(t, sols.Next())
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		sols := Solutions{
+			ctx: ctx,
+			t:   engine.NewTrampoline(engine.Bool(true)),
+		}
+		assert.False(t, sols.Next())
+		assert.Equal(t, context.Canceled, sols.Err())
 	})
 
 	t.Run("closed", func(t *testing.T) {
@@ -171,6 +195,49 @@ func TestSolutions_Scan(t *testing.T) {
 			"X": engine.Integer(1),
 		}), dest: &struct{ X bool }{}, err: errConversion},
 
+		{title: "struct: compound", sols: sols(map[string]engine.Term{
+			"X": engine.NewAtom("person").Apply(engine.NewAtom("alice"), engine.Integer(30), engine.NewAtom("address").Apply(engine.NewAtom("tokyo"))),
+		}), dest: &struct{ X testPerson }{}, result: &struct{ X testPerson }{X: testPerson{Name: "alice", Age: 30, Address: &testAddress{City: "tokyo"}}}},
+		{title: "struct: compound, nil pointer", sols: sols(map[string]engine.Term{
+			"X": engine.NewAtom("person").Apply(engine.NewAtom("bob"), engine.Integer(20), engine.NewVariable()),
+		}), dest: &struct{ X testPerson }{}, result: &struct{ X testPerson }{X: testPerson{Name: "bob", Age: 20}}},
+		{title: "struct: compound, atom", sols: sols(map[string]engine.Term{
+			"X": engine.NewAtom("nobody"),
+		}), dest: &struct{ X testNobody }{}, result: &struct{ X testNobody }{}},
+		{title: "struct: compound, different functor", sols: sols(map[string]engine.Term{
+			"X": engine.NewAtom("company").Apply(engine.NewAtom("acme"), engine.Integer(30), engine.NewVariable()),
+		}), dest: &struct{ X testPerson }{}, err: errConversion},
+		{title: "struct: compound, no compound tag", sols: sols(map[string]engine.Term{
+			"X": engine.NewAtom("foo").Apply(engine.Integer(1)),
+		}), dest: &struct{ X struct{ A int } }{}, err: errors.New("no compound tag: struct { A int }")},
+		{title: "struct: map, pairs", sols: sols(map[string]engine.Term{
+			"X": engine.List(engine.NewAtom("-").Apply(engine.NewAtom("a"), engine.Integer(1)), engine.NewAtom("-").Apply(engine.NewAtom("b"), engine.Integer(2))),
+		}), dest: &struct{ X map[string]int }{}, result: &struct{ X map[string]int }{X: map[string]int{"a": 1, "b": 2}}},
+		{title: "struct: map, non-pair", sols: sols(map[string]engine.Term{
+			"X": engine.List(engine.NewAtom("a")),
+		}), dest: &struct{ X map[string]int }{}, err: errConversion},
+		{title: "struct: pointer, integer", sols: sols(map[string]engine.Term{
+			"X": engine.Integer(1),
+		}), dest: &struct{ X *int }{}, result: &struct{ X *int }{X: func() *int { i := 1; return &i }()}},
+		{title: "struct: time, integer", sols: sols(map[string]engine.Term{
+			"X": engine.Integer(1700000000),
+		}), dest: &struct{ X time.Time }{}, result: &struct{ X time.Time }{X: time.Unix(1700000000, 0)}},
+		{title: "struct: time, float", sols: sols(map[string]engine.Term{
+			"X": engine.Float(1.5),
+		}), dest: &struct{ X time.Time }{}, result: &struct{ X time.Time }{X: time.Unix(1, 500_000_000)}},
+		{title: "struct: time, float with microseconds", sols: sols(map[string]engine.Term{
+			"X": engine.Float(1700000000.123456),
+		}), dest: &struct{ X time.Time }{}, result: &struct{ X time.Time }{X: time.UnixMicro(1700000000123456)}},
+		{title: "struct: bytes, list", sols: sols(map[string]engine.Term{
+			"X": engine.List(engine.Integer(0), engine.Integer(255)),
+		}), dest: &struct{ X []byte }{}, result: &struct{ X []byte }{X: []byte{0, 255}}},
+		{title: "struct: bytes, non-byte", sols: sols(map[string]engine.Term{
+			"X": engine.List(engine.Integer(256)),
+		}), dest: &struct{ X []byte }{}, err: errConversion},
+		{title: "struct: term unmarshaler", sols: sols(map[string]engine.Term{
+			"X": engine.NewAtom("red"),
+		}), dest: &struct{ X testColor }{}, result: &struct{ X testColor }{X: testColor{name: "red"}}},
+
 		{title: "struct: alias", sols: sols(map[string]engine.Term{
 			"Y": engine.Integer(1),
 		}), dest: &struct {
@@ -212,6 +279,76 @@ func TestSolutions_Scan(t *testing.T) {
 	}
 }
 
+func TestSolutions_ScanAll(t *testing.T) {
+	type pair struct {
+		X int
+		Y string
+	}
+
+	p := New(nil, nil)
+	tests := []struct {
+		title  string
+		query  string
+		dest   interface{}
+		result interface{}
+		err    error
+	}{
+		{title: "structs", query: `member(X-Y, [1-a, 2-b]).`, dest: &[]pair{}, result: &[]pair{{X: 1, Y: "a"}, {X: 2, Y: "b"}}},
+		{title: "struct pointers", query: `member(X-Y, [1-a, 2-b]).`, dest: &[]*pair{}, result: &[]*pair{{X: 1, Y: "a"}, {X: 2, Y: "b"}}},
+		{title: "maps", query: `member(X, [1, 2]).`, dest: &[]map[string]int{}, result: &[]map[string]int{{"X": 1}, {"X": 2}}},
+		{title: "scalars", query: `member(X, [a, b, c]).`, dest: &[]string{}, result: &[]string{"a", "b", "c"}},
+		{title: "scalars: ignored variables", query: `member(X-_Y, [a-1, b-2]).`, dest: &[]string{}, result: &[]string{"a", "b"}},
+		{title: "scalars: compounds", query: `member(X, [f(a), g(b)]).`, dest: &[]TermString{}, result: &[]TermString{"f(a)", "g(b)"}},
+		{title: "scalars: too many variables", query: `member(X-Y, [1-a]).`, dest: &[]string{}, result: &[]string{}, err: errors.New("string needs exactly one variable in the query, got 2")},
+		{title: "append", query: `member(X, [b, c]).`, dest: &[]string{"a"}, result: &[]string{"a", "b", "c"}},
+		{title: "no solutions", query: `fail.`, dest: &[]string{}, result: &[]string{}},
+		{title: "partial results", query: `member(X, [a, b]) ; throw(ball).`, dest: &[]string{}, result: &[]string{"a", "b"}, err: engine.NewException(engine.NewAtom("ball"), nil)},
+		{title: "conversion error", query: `member(X, [a, 1]).`, dest: &[]string{}, result: &[]string{"a"}, err: errConversion},
+		{title: "not a pointer to slice", query: `true.`, dest: []string{}, result: []string{}, err: errors.New("not a pointer to slice: []string")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			sols, err := p.Query(tt.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.err, sols.ScanAll(tt.dest))
+			assert.Equal(t, tt.result, tt.dest)
+			assert.Equal(t, ErrClosed, sols.Close())
+		})
+	}
+}
+
+func TestSolutions_Deterministic(t *testing.T) {
+	p := New(nil, nil)
+
+	tests := []struct {
+		query         string
+		deterministic []bool
+	}{
+		{query: `X = 1.`, deterministic: []bool{true}},
+		{query: `X = 1 ; X = 2.`, deterministic: []bool{false, true}},
+		{query: `between(1, 3, X).`, deterministic: []bool{false, false, true}},
+		{query: `(X = 1 ; X = 2), !.`, deterministic: []bool{true}},
+		{query: `findall(X, member(X, [a, b]), L).`, deterministic: []bool{true}},
+		{query: `fail.`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			sols, err := p.Query(tt.query)
+			assert.NoError(t, err)
+			defer sols.Close()
+
+			var deterministic []bool
+			for sols.Next() {
+				deterministic = append(deterministic, sols.Deterministic())
+			}
+			assert.NoError(t, sols.Err())
+			assert.Equal(t, tt.deterministic, deterministic)
+		})
+	}
+}
+
 func TestSolutions_Err(t *testing.T) {
 	err := errors.New("ng")
 	sols := Solutions{err: err}
@@ -284,3 +421,36 @@ func ExampleSolutions_Scan_list() {
 	// Floats = [1.1 2.1]
 	// Mixed = [foo 1 1.1]
 }
+
+type testPerson struct {
+	_       struct{} `prolog:"person(name,age,address)"`
+	Name    string
+	Age     int
+	Address *testAddress
+}
+
+type testAddress struct {
+	_    struct{} `prolog:"address(city)"`
+	City string   `prolog:"city"`
+}
+
+type testNobody struct {
+	_ struct{} `prolog:"nobody"`
+}
+
+type testColor struct {
+	name string
+}
+
+func (c testColor) MarshalTerm() (engine.Term, error) {
+	return engine.NewAtom(c.name), nil
+}
+
+func (c *testColor) UnmarshalTerm(t engine.Term, env *engine.Env) error {
+	a, ok := env.Resolve(t).(engine.Atom)
+	if !ok {
+		return errConversion
+	}
+	c.name = a.String()
+	return nil
+}
@@ -0,0 +1,68 @@
+package prolog
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ichiban/prolog/engine"
+)
+
+// Stmt is a prepared query. The query is parsed and compiled once and executed as many times as needed with
+// different arguments for the placeholders.
+// A Stmt is safe for concurrent use by multiple goroutines.
+type Stmt struct {
+	vm     *engine.VM
+	goal   *engine.PreparedGoal
+	vars   []engine.ParsedVariable
+	params engine.Placeholders
+}
+
+// Prepare creates a prepared query for later executions. The placeholders in query, either positional or named, are
+// filled with the arguments given to each execution.
+func (i *Interpreter) Prepare(query string) (*Stmt, error) {
+	p := engine.NewParser(&i.VM, strings.NewReader(query))
+	p.SetPlaceholderVariables(engine.NewAtom("?"))
+
+	t, err := p.Term()
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := engine.PrepareGoal(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stmt{
+		vm:     &i.VM,
+		goal:   g,
+		vars:   p.Vars,
+		params: p.Placeholders,
+	}, nil
+}
+
+// Query executes the prepared query and returns *Solutions.
+func (s *Stmt) Query(args ...interface{}) (*Solutions, error) {
+	return s.QueryContext(context.Background(), args...)
+}
+
+// QueryContext executes the prepared query and returns *Solutions with context.
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Solutions, error) {
+	env, err := s.params.Bind(engine.NewEnv().WithContext(ctx), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSolutions(ctx, s.vm, s.vars, s.goal.Call, env), nil
+}
+
+// QuerySolution executes the prepared query for the first solution.
+func (s *Stmt) QuerySolution(args ...interface{}) *Solution {
+	return s.QuerySolutionContext(context.Background(), args...)
+}
+
+// QuerySolutionContext executes the prepared query for the first solution with context.
+func (s *Stmt) QuerySolutionContext(ctx context.Context, args ...interface{}) *Solution {
+	sols, err := s.QueryContext(ctx, args...)
+	return firstSolution(sols, err)
+}
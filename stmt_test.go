@@ -0,0 +1,152 @@
+package prolog
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpreter_Prepare(t *testing.T) {
+	i := New(nil, nil)
+
+	tests := []struct {
+		title string
+		query string
+		err   bool
+	}{
+		{title: "ok", query: `member(X, ?).`},
+		{title: "syntax error", query: `member(X, ?`, err: true},
+		{title: "not callable", query: `1.`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			_, err := i.Prepare(tt.query)
+			assert.Equal(t, tt.err, err != nil)
+		})
+	}
+}
+
+func TestStmt_Query(t *testing.T) {
+	i := New(nil, nil)
+	assert.NoError(t, i.Exec(`
+parent(alice, bob).
+parent(alice, carol).
+parent(bob, dave).
+`))
+
+	stmt, err := i.Prepare(`parent(?, X).`)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		title  string
+		args   []interface{}
+		result []string
+		err    error
+	}{
+		{title: "alice", args: []interface{}{"alice"}, result: []string{"bob", "carol"}},
+		{title: "bob", args: []interface{}{"bob"}, result: []string{"dave"}},
+		{title: "dave", args: []interface{}{"dave"}},
+		{title: "variable", args: []interface{}{nil}, err: errors.New("can't convert to term: <invalid reflect.Value>")},
+		{title: "not enough arguments", args: nil, err: errors.New("not enough arguments for placeholders")},
+		{title: "too many arguments", args: []interface{}{"alice", "bob"}, err: errors.New("too many arguments for placeholders: [bob]")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			sols, err := stmt.Query(tt.args...)
+			assert.Equal(t, tt.err, err)
+			if err != nil {
+				return
+			}
+			defer sols.Close()
+
+			var result []string
+			for sols.Next() {
+				var s struct{ X string }
+				assert.NoError(t, sols.Scan(&s))
+				result = append(result, s.X)
+			}
+			assert.NoError(t, sols.Err())
+			assert.Equal(t, tt.result, result)
+		})
+	}
+
+	t.Run("concurrent", func(t *testing.T) {
+		stmt, err := i.Prepare(`length(L, ?), maplist(=(?), L), atom_chars(A, L).`)
+		assert.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for n := 0; n < 10; n++ {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				var s struct{ A string }
+				assert.NoError(t, stmt.QuerySolution(n, "a").Scan(&s))
+				assert.Equal(t, strings.Repeat("a", n), s.A)
+			}(n)
+		}
+		wg.Wait()
+	})
+}
+
+func TestStmt_Query_named(t *testing.T) {
+	i := New(nil, nil)
+	stmt, err := i.Prepare(`atom_length(:name, N), N >= ?{min}.`)
+	assert.NoError(t, err)
+
+	type user struct {
+		Name string
+		Min  int `prolog:"min"`
+	}
+
+	tests := []struct {
+		title string
+		arg   interface{}
+		n     int
+		err   error
+	}{
+		{title: "map", arg: map[string]interface{}{"name": "alice", "min": 3}, n: 5},
+		{title: "struct", arg: user{Name: "bob", Min: 3}, n: 3},
+		{title: "no solutions", arg: user{Name: "bob", Min: 4}, err: ErrNoSolutions},
+		{title: "missing", arg: map[string]interface{}{"name": "alice"}, err: errors.New("no argument for named placeholder: min")},
+		{title: "unused", arg: map[string]interface{}{"name": "alice", "min": 3, "max": 10}, err: errors.New("unused arguments for named placeholders: max")},
+		{title: "not a map or a struct", arg: "alice", err: errors.New("named placeholders require a map or a struct: string")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			var s struct{ N int }
+			err := stmt.QuerySolution(tt.arg).Scan(&s)
+			if tt.err != nil {
+				assert.EqualError(t, err, tt.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.n, s.N)
+		})
+	}
+}
+
+func TestStmt_QuerySolution(t *testing.T) {
+	i := New(nil, nil)
+	stmt, err := i.Prepare(`atom_length(?, N).`)
+	assert.NoError(t, err)
+
+	var s struct{ N int }
+	assert.NoError(t, stmt.QuerySolution("foo").Scan(&s))
+	assert.Equal(t, 3, s.N)
+
+	assert.NoError(t, stmt.QuerySolution("foobar").Scan(&s))
+	assert.Equal(t, 6, s.N)
+
+	assert.Error(t, stmt.QuerySolution().Err())
+
+	stmt, err = i.Prepare(`atom_length(?, 3).`)
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.QuerySolution("foo").Err())
+	assert.Equal(t, ErrNoSolutions, stmt.QuerySolution("foobar").Err())
+}